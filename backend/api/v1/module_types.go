@@ -30,24 +30,81 @@ type ModuleSpec struct {
 }
 
 type ModuleSource struct {
-	// +kubebuilder:validation:Enum=git;http
-	Type    string `json:"type"`
-	URL     string `json:"url"`
+	// +kubebuilder:validation:Enum=git;http;registry;oci
+	Type string `json:"type"`
+	// URL is the clone URL for git, the archive URL for http, the
+	// "[host/]namespace/name/provider" module address for registry sources, or
+	// the "[oci://]registry/repository" artifact address for oci sources.
+	URL string `json:"url"`
+	// Version is the git ref for git sources, a version constraint such as
+	// "~> 5.0" for registry sources, or a tag or "sha256:..." digest for oci sources.
 	Version string `json:"version,omitempty"`
-	Path    string `json:"path,omitempty"`
+	// Path selects the module directory inside the repository or archive, using
+	// the same semantics as a Terraform "//subdir" source suffix. One path
+	// segment may be a glob pattern such as "*", e.g. to match an archive's
+	// top-level directory; it must match exactly one directory.
+	Path string `json:"path,omitempty"`
+	// SecretRef names a Secret in the Module's namespace with credentials for
+	// git sources: "username" and "password" or "token" for HTTPS, or
+	// "ssh-privatekey" and "known_hosts" for SSH. oci sources read "username"
+	// and "password" or "token" to pull from a private registry.
+	SecretRef *ModuleSecretRef `json:"secretRef,omitempty"`
+	// PollInterval makes the controller check a git source's ref upstream with
+	// "git ls-remote" at this interval and re-parse the module when the commit
+	// changes. Useful for branches or an empty version.
+	PollInterval *metav1.Duration `json:"pollInterval,omitempty"`
+	// Checksum pins the digest of an http archive download as
+	// "sha256:<hex>" or "sha512:<hex>". A mismatch keeps the Module from
+	// becoming Ready. Not supported for registry and oci sources.
+	// +kubebuilder:validation:Pattern=`^(sha256:[0-9a-fA-F]{64}|sha512:[0-9a-fA-F]{128})$`
+	Checksum string `json:"checksum,omitempty"`
+	// Verification requires a valid OpenPGP signature on the source: a detached
+	// signature for http archives, or a signed annotated tag for git sources.
+	// Not supported for registry and oci sources.
+	Verification *ModuleVerification `json:"verification,omitempty"`
+}
+
+// ModuleVerification configures OpenPGP signature verification of a Module source.
+type ModuleVerification struct {
+	// SecretRef names a Secret in the Module's namespace whose "public-key" key
+	// holds one or more ASCII-armored OpenPGP public keys trusted to sign the source.
+	SecretRef ModuleSecretRef `json:"secretRef"`
+	// SignatureURL locates the detached signature of an archive. Defaults to the
+	// archive URL with ".sig" appended to its path.
+	SignatureURL string `json:"signatureURL,omitempty"`
+}
+
+// ModuleSecretRef references a Secret in the same namespace as the Module.
+type ModuleSecretRef struct {
+	Name string `json:"name"`
 }
 
 // ModuleStatus defines the observed state of Module.
 type ModuleStatus struct {
-	Description  string             `json:"description,omitempty"`
-	Inputs       []ModuleInput      `json:"inputs"`
-	Outputs      []ModuleOutput     `json:"outputs"`
-	Providers    []ModuleProvider   `json:"providers"`
-	Requirements ModuleRequirements `json:"requirements"`
-	Resources    []ModuleResource   `json:"resources"`
-	Submodules   []ModuleSubmodule  `json:"submodules"`
-	Conditions   []ModuleCondition  `json:"conditions"`
-	LastSynced   metav1.Time        `json:"lastSynced"`
+	Description string `json:"description,omitempty"`
+	// ResolvedVersion is the concrete version selected for a registry source's constraint
+	ResolvedVersion string `json:"resolvedVersion,omitempty"`
+	// ResolvedDigest is the manifest digest an oci source's tag resolved to
+	ResolvedDigest string `json:"resolvedDigest,omitempty"`
+	// ResolvedCommit is the commit a git source's version was checked out at
+	ResolvedCommit string `json:"resolvedCommit,omitempty"`
+	// ResolvedChecksum is the "<algorithm>:<hex>" digest of the http archive
+	// that passed verification; Stacks download exactly this archive
+	ResolvedChecksum string        `json:"resolvedChecksum,omitempty"`
+	Inputs           []ModuleInput `json:"inputs"`
+	// InputSchema is a JSON Schema (draft 2020-12) for the object of variable
+	// values the module accepts, derived from the inputs' Terraform type
+	// constraints including optional attributes and their defaults.
+	InputSchema  *apiextensionsv1.JSON `json:"inputSchema,omitempty"`
+	Outputs      []ModuleOutput        `json:"outputs"`
+	Providers    []ModuleProvider      `json:"providers"`
+	Requirements ModuleRequirements    `json:"requirements"`
+	Resources    []ModuleResource      `json:"resources"`
+	Submodules   []ModuleSubmodule     `json:"submodules"`
+	Conditions   []ModuleCondition     `json:"conditions"`
+	LastSynced   metav1.Time           `json:"lastSynced"`
+	// ObservedGeneration is the metadata.generation the status was computed for
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
 }
 
 type ModuleInput struct {
@@ -1,6 +1,7 @@
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -10,17 +11,130 @@ type StackSpec struct {
 	BackendConfig BackendConfigSpec   `json:"backendConfig"`
 	CredentialRef *StackCredentialRef `json:"credentialRef,omitempty"`
 	Modules       []StackModuleRef    `json:"modules"`
+	// RenderFormat selects the syntax of the generated root configuration:
+	// "hcl" writes .tf files and "json" writes .tf.json files. Defaults to the
+	// controller's --render-format flag.
+	// +kubebuilder:validation:Enum=hcl;json
+	// +optional
+	RenderFormat string `json:"renderFormat,omitempty"`
+	// ShareOutputsWith lists the other namespaces whose Stacks may reference
+	// this Stack's outputs with stackInputs; "*" allows every namespace.
+	// Stacks in the same namespace may always reference them.
+	// +optional
+	ShareOutputsWith []string `json:"shareOutputsWith,omitempty"`
+	// Approval selects how plans are applied: "auto" applies every plan,
+	// "manual" saves it and applies it only once the ApprovedPlanAnnotation
+	// names its hash. Defaults to "auto".
+	// +kubebuilder:validation:Enum=auto;manual
+	// +optional
+	Approval string `json:"approval,omitempty"`
+	// Runner overrides how the Job runs terraform when the controller runs
+	// Stacks in Jobs (--runner=job). Ignored by the in-process runner.
+	// +optional
+	Runner *StackRunnerSpec `json:"runner,omitempty"`
 }
 
+// StackRunnerSpec configures the Jobs that run terraform for a Stack.
+type StackRunnerSpec struct {
+	// Image is the terraform image, which needs sh and cp besides
+	// terraform. Defaults to the controller's --runner-image.
+	// +optional
+	Image string `json:"image,omitempty"`
+	// ServiceAccountName is the service account of the Job's pod, for
+	// example one bound to a cloud identity. Defaults to the controller's
+	// --runner-service-account.
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+	// Resources of the terraform container. Defaults to the controller's
+	// --runner-cpu and --runner-memory limits.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// Render formats for the root configuration of a Stack.
+const (
+	RenderFormatHCL  = "hcl"
+	RenderFormatJSON = "json"
+)
+
+// Approval modes of a Stack.
+const (
+	ApprovalAuto   = "auto"
+	ApprovalManual = "manual"
+)
+
+// ApprovedPlanAnnotation approves the saved plan of a Stack with manual
+// approval when set to the plan's status.plan.hash. The controller removes
+// it once the plan was applied, so every plan needs its own approval.
+const ApprovedPlanAnnotation = "astrolabe.io/approved-plan"
+
 // BackendConfigSpec defines the desired state of BackendConfig (inlined for Stack)
 type BackendConfigSpec struct {
-	Type     string               `json:"type"`
-	Settings apiextensionsv1.JSON `json:"settings"`
+	// Type is a Terraform backend type, or BackendTypeManaged for state the
+	// controller keeps in the Stack's namespace.
+	Type string `json:"type"`
+	// Settings of the backend. With BackendTypeManaged only secret_suffix,
+	// which defaults to the Stack's name, and labels may be set.
+	// +optional
+	Settings apiextensionsv1.JSON `json:"settings,omitempty"`
 }
+
+// BackendTypeManaged has the controller manage Terraform's kubernetes backend
+// for a Stack: the state is kept in the Secret tfstate-default-<secret_suffix>
+// and locked with a Lease in the Stack's namespace, and both are deleted once
+// the Stack was destroyed. The service account terraform runs as needs
+// access to Secrets and Leases in that namespace.
+const BackendTypeManaged = "managed"
+
 type StackModuleRef struct {
 	Name      string               `json:"name"`
 	Variables apiextensionsv1.JSON `json:"variables,omitempty"`
 	DependsOn []string             `json:"dependsOn,omitempty"`
+	// LinkedInputs set variables of this module from outputs of other
+	// modules in the Stack. Each linked module is an implicit dependency.
+	LinkedInputs []LinkedInput `json:"linkedInputs,omitempty"`
+	// StackInputs set variables of this module from the outputs of other
+	// Stacks. The Stack waits until they are Ready and is planned again
+	// when a referenced output changes.
+	StackInputs []StackOutputReference `json:"stackInputs,omitempty"`
+}
+
+// Dependencies returns the modules this module depends on: those named in
+// DependsOn and those it takes linked inputs from, without duplicates.
+func (m StackModuleRef) Dependencies() []string {
+	deps := []string{}
+	seen := map[string]bool{}
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			deps = append(deps, name)
+		}
+	}
+	for _, dep := range m.DependsOn {
+		add(dep)
+	}
+	for _, link := range m.LinkedInputs {
+		add(link.FromModuleID)
+	}
+	return deps
+}
+
+// StackOutputReference passes an output of another Stack to a variable of a module.
+type StackOutputReference struct {
+	// StackRef names the Stack whose status.outputs provides the value.
+	StackRef StackReference `json:"stackRef"`
+	// Output is the key of the value in the referenced Stack's status.outputs.
+	Output string `json:"output"`
+	// ToVariable is the variable of this module that receives the value.
+	ToVariable string `json:"toVariable"`
+}
+
+// StackReference references a Stack, in the referencing Stack's namespace
+// unless Namespace is set.
+type StackReference struct {
+	Name string `json:"name"`
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
 }
 
 // StackCredentialRef matches CredentialRef in stack.yaml
@@ -28,10 +142,14 @@ type StackCredentialRef struct {
 	Name string `json:"name"`
 }
 
+// LinkedInput passes an output of one Stack module to a variable of another.
 type LinkedInput struct {
+	// FromModuleID is the name of the Stack module that provides the output.
 	FromModuleID string `json:"fromModuleId"`
-	ToVariable   string `json:"toVariable"`
-	FromOutput   string `json:"fromOutput"`
+	// ToVariable is the variable of this module that receives the value.
+	ToVariable string `json:"toVariable"`
+	// FromOutput is the name of the output of the providing module.
+	FromOutput string `json:"fromOutput"`
 }
 
 // StackStatus defines the observed state of Stack
@@ -44,23 +162,87 @@ type StackStatus struct {
 	Ready     bool                 `json:"ready,omitempty"`
 	// Conditions represent the latest available observations of an object's state
 	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+	// UpstreamOutputsHash is a hash of the outputs of other Stacks that the
+	// last successful apply consumed through stackInputs.
+	UpstreamOutputsHash string `json:"upstreamOutputsHash,omitempty"`
+	// Plan describes the latest saved plan.
+	// +optional
+	Plan *StackPlan `json:"plan,omitempty"`
+	// Runs is the history of the latest runs, oldest first.
+	// +optional
+	Runs []StackApply `json:"runs,omitempty"`
 }
 
+// StackPlan summarizes a saved Terraform plan of a Stack.
+type StackPlan struct {
+	// Hash identifies the planned changes. Two plans with the same changes
+	// have the same hash.
+	Hash string `json:"hash"`
+	// ConfigHash identifies the rendered configuration the plan was made from.
+	ConfigHash string `json:"configHash,omitempty"`
+	// Summary reads like Terraform's "Plan: 1 to add, 0 to change, 0 to destroy."
+	Summary string `json:"summary,omitempty"`
+	Add     int32  `json:"add"`
+	Change  int32  `json:"change"`
+	Destroy int32  `json:"destroy"`
+	// Replace counts resources destroyed and created again. As in Terraform's
+	// summary, each is also counted in Add and Destroy.
+	Replace int32 `json:"replace"`
+	// Changes lists the resources the plan changes, in plan order, with
+	// sensitive values redacted. At most MaxPlanChanges are listed; the
+	// counts always cover the whole plan.
+	// +optional
+	Changes []StackPlanChange `json:"changes,omitempty"`
+	// CreatedAt is when the plan was made.
+	CreatedAt metav1.Time `json:"createdAt,omitempty"`
+}
+
+// MaxPlanChanges bounds StackPlan.Changes so large plans fit in the status.
+const MaxPlanChanges = 100
+
 type StackResource struct {
 	Name string `json:"name"`
 }
 
+// StackPlanChange is the planned change of one resource.
 type StackPlanChange struct {
+	// Resource identifies the resource by address, module, type, name and
+	// provider. For updates and replacements "before" and "after" hold the
+	// attributes that change, for creates "after" and for deletes "before"
+	// hold all of them. Sensitive values read "(sensitive value)" and values
+	// computed during apply read "(known after apply)".
 	Resource apiextensionsv1.JSON `json:"resource,omitempty"`
-	Action   string               `json:"action,omitempty"`
+	// Action is one of create, update, delete, replace or read.
+	Action string `json:"action,omitempty"`
 }
 
+// StackApply records one run of terraform for a Stack.
 type StackApply struct {
-	Status  string `json:"status,omitempty"`
+	// ID identifies the run among the runs of the Stack.
+	ID string `json:"id"`
+	// Trigger is why the run started: Created, SpecChanged,
+	// UpstreamOutputsChanged, Approved, Retry, Resync or Deleted.
+	Trigger string `json:"trigger,omitempty"`
+	// Generation is the Stack generation the run applied.
+	Generation int64 `json:"generation,omitempty"`
+	// Status is Running, Succeeded, Failed or AwaitingApproval.
+	Status string `json:"status,omitempty"`
+	// FailedStep is the step that failed: init, plan, apply, outputs or destroy.
+	FailedStep string `json:"failedStep,omitempty"`
+	// Summary is the outcome of the run, or its error.
 	Summary string `json:"summary,omitempty"`
-	Logs    string `json:"logs,omitempty"`
+	// PlanSummary and PlanHash describe the plan the run made or applied.
+	PlanSummary string `json:"planSummary,omitempty"`
+	PlanHash    string `json:"planHash,omitempty"`
+	// Logs points to the full output of the run's steps.
+	Logs       string       `json:"logs,omitempty"`
+	StartedAt  metav1.Time  `json:"startedAt,omitempty"`
+	FinishedAt *metav1.Time `json:"finishedAt,omitempty"`
 }
 
+// MaxStackRuns bounds StackStatus.Runs; older runs are dropped first.
+const MaxStackRuns = 10
+
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="NAME",type=string,JSONPath=".metadata.name",description="Name of the stack"
@@ -21,6 +21,7 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
@@ -62,7 +63,7 @@ func (in *Module) DeepCopyInto(out *Module) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -220,9 +221,39 @@ func (in *ModuleResource) DeepCopy() *ModuleResource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModuleSecretRef) DeepCopyInto(out *ModuleSecretRef) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModuleSecretRef.
+func (in *ModuleSecretRef) DeepCopy() *ModuleSecretRef {
+	if in == nil {
+		return nil
+	}
+	out := new(ModuleSecretRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ModuleSource) DeepCopyInto(out *ModuleSource) {
 	*out = *in
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(ModuleSecretRef)
+		**out = **in
+	}
+	if in.PollInterval != nil {
+		in, out := &in.PollInterval, &out.PollInterval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.Verification != nil {
+		in, out := &in.Verification, &out.Verification
+		*out = new(ModuleVerification)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModuleSource.
@@ -238,7 +269,7 @@ func (in *ModuleSource) DeepCopy() *ModuleSource {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ModuleSpec) DeepCopyInto(out *ModuleSpec) {
 	*out = *in
-	out.Source = in.Source
+	in.Source.DeepCopyInto(&out.Source)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModuleSpec.
@@ -261,6 +292,11 @@ func (in *ModuleStatus) DeepCopyInto(out *ModuleStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.InputSchema != nil {
+		in, out := &in.InputSchema, &out.InputSchema
+		*out = new(apiextensionsv1.JSON)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Outputs != nil {
 		in, out := &in.Outputs, &out.Outputs
 		*out = make([]ModuleOutput, len(*in))
@@ -332,6 +368,22 @@ func (in *ModuleTerraformRequirements) DeepCopy() *ModuleTerraformRequirements {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModuleVerification) DeepCopyInto(out *ModuleVerification) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModuleVerification.
+func (in *ModuleVerification) DeepCopy() *ModuleVerification {
+	if in == nil {
+		return nil
+	}
+	out := new(ModuleVerification)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Stack) DeepCopyInto(out *Stack) {
 	*out = *in
@@ -362,6 +414,11 @@ func (in *Stack) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *StackApply) DeepCopyInto(out *StackApply) {
 	*out = *in
+	in.StartedAt.DeepCopyInto(&out.StartedAt)
+	if in.FinishedAt != nil {
+		in, out := &in.FinishedAt, &out.FinishedAt
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StackApply.
@@ -430,6 +487,16 @@ func (in *StackModuleRef) DeepCopyInto(out *StackModuleRef) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.LinkedInputs != nil {
+		in, out := &in.LinkedInputs, &out.LinkedInputs
+		*out = make([]LinkedInput, len(*in))
+		copy(*out, *in)
+	}
+	if in.StackInputs != nil {
+		in, out := &in.StackInputs, &out.StackInputs
+		*out = make([]StackOutputReference, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StackModuleRef.
@@ -442,6 +509,45 @@ func (in *StackModuleRef) DeepCopy() *StackModuleRef {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StackOutputReference) DeepCopyInto(out *StackOutputReference) {
+	*out = *in
+	out.StackRef = in.StackRef
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StackOutputReference.
+func (in *StackOutputReference) DeepCopy() *StackOutputReference {
+	if in == nil {
+		return nil
+	}
+	out := new(StackOutputReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StackPlan) DeepCopyInto(out *StackPlan) {
+	*out = *in
+	if in.Changes != nil {
+		in, out := &in.Changes, &out.Changes
+		*out = make([]StackPlanChange, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	in.CreatedAt.DeepCopyInto(&out.CreatedAt)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StackPlan.
+func (in *StackPlan) DeepCopy() *StackPlan {
+	if in == nil {
+		return nil
+	}
+	out := new(StackPlan)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *StackPlanChange) DeepCopyInto(out *StackPlanChange) {
 	*out = *in
@@ -458,6 +564,21 @@ func (in *StackPlanChange) DeepCopy() *StackPlanChange {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StackReference) DeepCopyInto(out *StackReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StackReference.
+func (in *StackReference) DeepCopy() *StackReference {
+	if in == nil {
+		return nil
+	}
+	out := new(StackReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *StackResource) DeepCopyInto(out *StackResource) {
 	*out = *in
@@ -473,6 +594,26 @@ func (in *StackResource) DeepCopy() *StackResource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StackRunnerSpec) DeepCopyInto(out *StackRunnerSpec) {
+	*out = *in
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = new(corev1.ResourceRequirements)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StackRunnerSpec.
+func (in *StackRunnerSpec) DeepCopy() *StackRunnerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(StackRunnerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *StackSpec) DeepCopyInto(out *StackSpec) {
 	*out = *in
@@ -489,6 +630,16 @@ func (in *StackSpec) DeepCopyInto(out *StackSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.ShareOutputsWith != nil {
+		in, out := &in.ShareOutputsWith, &out.ShareOutputsWith
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Runner != nil {
+		in, out := &in.Runner, &out.Runner
+		*out = new(StackRunnerSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StackSpec.
@@ -517,6 +668,18 @@ func (in *StackStatus) DeepCopyInto(out *StackStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Plan != nil {
+		in, out := &in.Plan, &out.Plan
+		*out = new(StackPlan)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Runs != nil {
+		in, out := &in.Runs, &out.Runs
+		*out = make([]StackApply, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StackStatus.
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
+	"fmt"
 	"os"
 	"path/filepath"
 
@@ -10,11 +12,15 @@ import (
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
@@ -23,6 +29,10 @@ import (
 
 	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
 	"github.com/junaid18183/astrolabe/controllers"
+	"github.com/junaid18183/astrolabe/internal/archive"
+	"github.com/junaid18183/astrolabe/internal/oci"
+	webhookv1 "github.com/junaid18183/astrolabe/internal/webhook/v1"
+	"github.com/junaid18183/astrolabe/internal/workspace"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -38,8 +48,36 @@ func init() {
 	// +kubebuilder:scaffold:scheme
 }
 
+// runJobResults is the JobResultsCommand of the manager image, run by the
+// results container of runner Jobs.
+func runJobResults(args []string) int {
+	fs := flag.NewFlagSet(controllers.JobResultsCommand, flag.ExitOnError)
+	namespace := fs.String("namespace", "", "Namespace of the Job's input Secret.")
+	secret := fs.String("secret", "", "Name of the Job's input Secret.")
+	dir := fs.String("dir", "", "Directory holding the files the Job produced.")
+	_ = fs.Parse(args)
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	c, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := controllers.StoreJobResults(context.Background(), c, *namespace, *secret, *dir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
 // nolint:gocyclo
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == controllers.JobResultsCommand {
+		os.Exit(runJobResults(os.Args[2:]))
+	}
 	var metricsAddr string
 	var metricsCertPath, metricsCertName, metricsCertKey string
 	var webhookCertPath, webhookCertName, webhookCertKey string
@@ -47,6 +85,11 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var ociPlainHTTP bool
+	var renderFormat string
+	var workspaceDir string
+	var runnerMode, runnerImage, runnerResultsImage, runnerServiceAccount, runnerCPU, runnerMemory string
+	archiveLimits := archive.DefaultLimits
 	var tlsOpts []func(*tls.Config)
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
@@ -65,6 +108,32 @@ func main() {
 	flag.StringVar(&metricsCertKey, "metrics-cert-key", "tls.key", "The name of the metrics server key file.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.BoolVar(&ociPlainHTTP, "oci-plain-http", false,
+		"If set, oci Module sources are pulled over plain HTTP. Only intended for local test registries.")
+	flag.Int64Var(&archiveLimits.MaxTotalSize, "archive-max-size", archiveLimits.MaxTotalSize,
+		"Maximum number of bytes a Module source archive may extract to. 0 disables the limit.")
+	flag.IntVar(&archiveLimits.MaxFiles, "archive-max-files", archiveLimits.MaxFiles,
+		"Maximum number of files a Module source archive may contain. 0 disables the limit.")
+	flag.Float64Var(&archiveLimits.MaxCompressionRatio, "archive-max-ratio", archiveLimits.MaxCompressionRatio,
+		"Maximum ratio of extracted to compressed size for Module source archives. 0 disables the limit.")
+	flag.StringVar(&renderFormat, "render-format", astrolabev1.RenderFormatHCL,
+		"Syntax of the Terraform configuration generated for Stacks that do not set spec.renderFormat: hcl or json.")
+	flag.StringVar(&workspaceDir, "workspace-dir", "/tmp/astrolabe",
+		"Directory holding the Terraform working directory of each Stack, including local state. "+
+			"Mount a PersistentVolume here to keep it across manager restarts.")
+	flag.StringVar(&runnerMode, "runner", controllers.RunnerLocal,
+		"Where Stacks run terraform: local, in the manager process, or job, in a Kubernetes Job per step. "+
+			"Stacks run by Jobs need the managed backend.")
+	flag.StringVar(&runnerImage, "runner-image", "hashicorp/terraform:1.9",
+		"Image of runner Jobs for Stacks that do not set spec.runner.image; it needs sh and cp besides terraform.")
+	flag.StringVar(&runnerResultsImage, "runner-results-image", "",
+		"Image of this manager, run by runner Jobs to store their results. Required with --runner=job.")
+	flag.StringVar(&runnerServiceAccount, "runner-service-account", "",
+		"Service account of runner Jobs for Stacks that do not set spec.runner.serviceAccountName. "+
+			"Besides the managed backend's Secrets and Leases it must get and update the Jobs' input Secrets.")
+	flag.StringVar(&runnerCPU, "runner-cpu", "", "CPU limit of runner Jobs for Stacks that do not set spec.runner.resources.")
+	flag.StringVar(&runnerMemory, "runner-memory", "",
+		"Memory limit of runner Jobs for Stacks that do not set spec.runner.resources.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -73,6 +142,31 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if renderFormat != astrolabev1.RenderFormatHCL && renderFormat != astrolabev1.RenderFormatJSON {
+		setupLog.Error(fmt.Errorf("unsupported render format %q", renderFormat), "invalid --render-format")
+		os.Exit(1)
+	}
+	if runnerMode != controllers.RunnerLocal && runnerMode != controllers.RunnerJob {
+		setupLog.Error(fmt.Errorf("unsupported runner %q", runnerMode), "invalid --runner")
+		os.Exit(1)
+	}
+	if runnerMode == controllers.RunnerJob && runnerResultsImage == "" {
+		setupLog.Error(fmt.Errorf("--runner-results-image is required with --runner=job"), "invalid runner configuration")
+		os.Exit(1)
+	}
+	runnerLimits := corev1.ResourceList{}
+	for name, value := range map[corev1.ResourceName]string{corev1.ResourceCPU: runnerCPU, corev1.ResourceMemory: runnerMemory} {
+		if value == "" {
+			continue
+		}
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			setupLog.Error(err, "invalid runner resource limit", "resource", name)
+			os.Exit(1)
+		}
+		runnerLimits[name] = q
+	}
+
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
 	// prevent from being vulnerable to the HTTP/2 Stream Cancellation and
@@ -189,9 +283,13 @@ func main() {
 	// +kubebuilder:scaffold:builder
 
 	// Register Module controller
+	ociClient := oci.NewClient(nil)
+	ociClient.PlainHTTP = ociPlainHTTP
 	if err = (&controllers.ModuleReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:        mgr.GetClient(),
+		Scheme:        mgr.GetScheme(),
+		OCI:           ociClient,
+		ArchiveLimits: &archiveLimits,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Module")
 		os.Exit(1)
@@ -201,13 +299,48 @@ func main() {
 	// BackendConfigReconciler setup removed
 
 	// Register Stack controller
+	var runner controllers.TerraformRunner
+	if runnerMode == controllers.RunnerJob {
+		clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+		if err != nil {
+			setupLog.Error(err, "unable to create clientset for runner job logs")
+			os.Exit(1)
+		}
+		runner = &controllers.JobRunner{
+			Client:             mgr.GetClient(),
+			APIReader:          mgr.GetAPIReader(),
+			JobLogs:            controllers.JobLogsFromClientset(clientset),
+			ResultsImage:       runnerResultsImage,
+			Image:              runnerImage,
+			ServiceAccountName: runnerServiceAccount,
+			Resources:          corev1.ResourceRequirements{Limits: runnerLimits},
+		}
+	}
 	if err = (&controllers.StackReconciler{
-		Client: mgr.GetClient(),
+		Client:        mgr.GetClient(),
+		RenderFormat:  renderFormat,
+		Runner:        runner,
+		Workspaces:    workspace.New(workspaceDir),
+		APIReader:     mgr.GetAPIReader(),
+		OCI:           ociClient,
+		ArchiveLimits: &archiveLimits,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Stack")
 		os.Exit(1)
 	}
 
+	// Webhooks need a serving certificate; set ENABLE_WEBHOOKS=false to run the manager locally without one
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = webhookv1.SetupStackWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Stack")
+			os.Exit(1)
+		}
+		if err = webhookv1.SetupModuleWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Module")
+			os.Exit(1)
+		}
+	}
+
 	if metricsCertWatcher != nil {
 		setupLog.Info("Adding metrics certificate watcher to manager")
 		if err := mgr.Add(metricsCertWatcher); err != nil {
@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Secret keys understood in a Module's spec.source.secretRef. They follow the
+// kubernetes.io/basic-auth and kubernetes.io/ssh-auth Secret type conventions.
+const (
+	gitSecretUsernameKey   = "username"
+	gitSecretPasswordKey   = "password"
+	gitSecretTokenKey      = "token"
+	gitSecretSSHKey        = "ssh-privatekey"
+	gitSecretKnownHostsKey = "known_hosts"
+)
+
+// gitAuth holds the credentials for one git source URL.
+type gitAuth struct {
+	URL        string
+	Username   string
+	Password   string
+	SSHKey     []byte
+	KnownHosts []byte
+}
+
+// loadGitAuth reads the Secret referenced by src.SecretRef in namespace.
+// It returns nil when the source has no secretRef.
+func loadGitAuth(ctx context.Context, c client.Reader, namespace string, src astrolabev1.ModuleSource) (*gitAuth, error) {
+	if src.SecretRef == nil {
+		return nil, nil
+	}
+	var secret corev1.Secret
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: src.SecretRef.Name}, &secret); err != nil {
+		return nil, fmt.Errorf("failed to get git credentials secret %q: %w", src.SecretRef.Name, err)
+	}
+	auth := &gitAuth{
+		URL:        src.URL,
+		Username:   string(secret.Data[gitSecretUsernameKey]),
+		Password:   string(secret.Data[gitSecretPasswordKey]),
+		SSHKey:     secret.Data[gitSecretSSHKey],
+		KnownHosts: secret.Data[gitSecretKnownHostsKey],
+	}
+	if token := string(secret.Data[gitSecretTokenKey]); token != "" && auth.Password == "" {
+		auth.Password = token
+	}
+	if auth.Password != "" && auth.Username == "" {
+		// GitHub, GitLab and Bitbucket all accept any username alongside a token
+		auth.Username = "x-access-token"
+	}
+	switch {
+	case len(auth.SSHKey) > 0 && len(auth.KnownHosts) == 0:
+		return nil, fmt.Errorf("git credentials secret %q has %s but no %s", src.SecretRef.Name, gitSecretSSHKey, gitSecretKnownHostsKey)
+	case len(auth.SSHKey) == 0 && auth.Password == "":
+		return nil, fmt.Errorf("git credentials secret %q must contain %s/%s, %s, or %s and %s",
+			src.SecretRef.Name, gitSecretUsernameKey, gitSecretPasswordKey, gitSecretTokenKey, gitSecretSSHKey, gitSecretKnownHostsKey)
+	}
+	return auth, nil
+}
+
+// gitAuthDirFor returns the directory holding the SSH keys of a Stack whose
+// working directory is workDir.
+func gitAuthDirFor(workDir string) string {
+	return workDir + "-git-auth"
+}
+
+// gitAuthEnv returns environment variables that make git (and terraform's
+// go-getter, which shells out to git) use the given credentials. HTTPS
+// credentials are scoped to each source URL via http.<url>.extraHeader so they
+// never appear on a command line; SSH keys and known_hosts are written into
+// dir, which the caller must remove afterwards.
+func gitAuthEnv(auths []*gitAuth, dir string) ([]string, error) {
+	var configs []string
+	var identities, knownHosts []string
+	for i, auth := range auths {
+		if auth == nil {
+			continue
+		}
+		if auth.Password != "" {
+			cred := base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))
+			configs = append(configs,
+				fmt.Sprintf("http.%s.extraHeader", auth.URL), "Authorization: Basic "+cred)
+		}
+		if len(auth.SSHKey) > 0 {
+			if err := os.MkdirAll(dir, 0700); err != nil {
+				return nil, err
+			}
+			keyPath := filepath.Join(dir, fmt.Sprintf("id_%d", i))
+			key := auth.SSHKey
+			if key[len(key)-1] != '\n' {
+				// ssh refuses keys without a trailing newline
+				key = append(append([]byte{}, key...), '\n')
+			}
+			if err := os.WriteFile(keyPath, key, 0600); err != nil {
+				return nil, err
+			}
+			hostsPath := filepath.Join(dir, fmt.Sprintf("known_hosts_%d", i))
+			if err := os.WriteFile(hostsPath, auth.KnownHosts, 0600); err != nil {
+				return nil, err
+			}
+			identities = append(identities, keyPath)
+			knownHosts = append(knownHosts, hostsPath)
+		}
+	}
+
+	env := []string{"GIT_TERMINAL_PROMPT=0"}
+	if len(configs) > 0 {
+		env = append(env, fmt.Sprintf("GIT_CONFIG_COUNT=%d", len(configs)/2))
+		for i := 0; i < len(configs); i += 2 {
+			env = append(env,
+				fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i/2, configs[i]),
+				fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i/2, configs[i+1]))
+		}
+	}
+	if len(identities) > 0 {
+		sshCmd := []string{"ssh", "-o", "IdentitiesOnly=yes", "-o", "StrictHostKeyChecking=yes",
+			"-o", "UserKnownHostsFile=\"" + strings.Join(knownHosts, " ") + "\""}
+		for _, id := range identities {
+			sshCmd = append(sshCmd, "-i", id)
+		}
+		env = append(env, "GIT_SSH_COMMAND="+strings.Join(sshCmd, " "))
+	}
+	return env, nil
+}
@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestLoadGitAuth(t *testing.T) {
+	c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "token", Namespace: "default"},
+			Data:       map[string][]byte{"token": []byte("ghp_secret")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "ssh-no-hosts", Namespace: "default"},
+			Data:       map[string][]byte{"ssh-privatekey": []byte("KEY")},
+		},
+	).Build()
+	ctx := context.Background()
+	src := astrolabev1.ModuleSource{Type: "git", URL: "https://github.com/acme/private.git"}
+
+	auth, err := loadGitAuth(ctx, c, "default", src)
+	require.NoError(t, err)
+	assert.Nil(t, auth)
+
+	src.SecretRef = &astrolabev1.ModuleSecretRef{Name: "token"}
+	auth, err = loadGitAuth(ctx, c, "default", src)
+	require.NoError(t, err)
+	assert.Equal(t, "x-access-token", auth.Username)
+	assert.Equal(t, "ghp_secret", auth.Password)
+
+	src.SecretRef = &astrolabev1.ModuleSecretRef{Name: "ssh-no-hosts"}
+	_, err = loadGitAuth(ctx, c, "default", src)
+	assert.ErrorContains(t, err, "known_hosts")
+
+	src.SecretRef = &astrolabev1.ModuleSecretRef{Name: "missing"}
+	_, err = loadGitAuth(ctx, c, "default", src)
+	assert.Error(t, err)
+}
+
+func TestGitAuthEnv(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "auth")
+	env, err := gitAuthEnv([]*gitAuth{
+		{URL: "https://github.com/acme/private.git", Username: "bot", Password: "s3cret"},
+		nil,
+		{URL: "git@github.com:acme/other.git", SSHKey: []byte("KEY"), KnownHosts: []byte("github.com ssh-ed25519 AAAA")},
+	}, dir)
+	require.NoError(t, err)
+
+	basic := base64.StdEncoding.EncodeToString([]byte("bot:s3cret"))
+	assert.Contains(t, env, "GIT_CONFIG_COUNT=1")
+	assert.Contains(t, env, "GIT_CONFIG_KEY_0=http.https://github.com/acme/private.git.extraHeader")
+	assert.Contains(t, env, "GIT_CONFIG_VALUE_0=Authorization: Basic "+basic)
+
+	var sshCmd string
+	for _, e := range env {
+		if strings.HasPrefix(e, "GIT_SSH_COMMAND=") {
+			sshCmd = e
+		}
+	}
+	keyPath := filepath.Join(dir, "id_2")
+	assert.Contains(t, sshCmd, "-i "+keyPath)
+	assert.Contains(t, sshCmd, "StrictHostKeyChecking=yes")
+	key, err := os.ReadFile(keyPath)
+	require.NoError(t, err)
+	assert.Equal(t, "KEY\n", string(key))
+	info, err := os.Stat(keyPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
@@ -1,17 +1,11 @@
 package controllers
 
 import (
-	"archive/zip"
-	"compress/gzip"
 	"context"
 	"crypto/sha256"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"net/http"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"strings"
@@ -21,11 +15,17 @@ import (
 	"k8s.io/client-go/tools/record"
 
 	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	"github.com/junaid18183/astrolabe/internal/archive"
+	"github.com/junaid18183/astrolabe/internal/oci"
+	"github.com/junaid18183/astrolabe/internal/registry"
+	"github.com/junaid18183/astrolabe/internal/tfmodule"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 )
 
 // ModuleReconciler reconciles a Module object
@@ -33,10 +33,17 @@ type ModuleReconciler struct {
 	client.Client
 	Scheme   *runtime.Scheme
 	Recorder record.EventRecorder
+	// Registry resolves registry module sources; a default client is used when nil
+	Registry *registry.Client
+	// OCI pulls oci module sources; a default client is used when nil
+	OCI *oci.Client
+	// ArchiveLimits bounds archive extraction; archive.DefaultLimits is used when nil
+	ArchiveLimits *archive.Limits
 }
 
 //+kubebuilder:rbac:groups=astrolabe.io,resources=modules,verbs=get;list;watch;update;patch
 //+kubebuilder:rbac:groups=astrolabe.io,resources=modules/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
 
 func (r *ModuleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	var module astrolabev1.Module
@@ -58,42 +65,71 @@ func (r *ModuleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 		return ctrl.Result{}, nil
 	}
 
-	// Check for terminal state and skip reconciliation if true
-	terminalStates := map[string]struct{}{
-		"Ready": {},
-	}
-	isTerminal := false
+	// Reconciliation is driven by metadata.generation: a parsed Module whose spec
+	// has not changed since it was last observed is a no-op.
+	ready := false
+	storedHash := ""
 	for _, cond := range module.Status.Conditions {
-		if _, ok := terminalStates[cond.Type]; ok && cond.Status == "True" {
-			isTerminal = true
-			break
+		switch cond.Type {
+		case "Ready":
+			ready = cond.Status == "True"
+		case "SourceHash":
+			storedHash = cond.Status
 		}
 	}
-	if isTerminal {
-		ctrl.Log.Info("Module is already in terminal state, skipping reconciliation", "name", module.Name)
-		r.emitModuleEvent(&module, "Normal", "TerminalState", "Module is already in terminal state, skipping reconciliation")
-		return ctrl.Result{}, nil
+	pollInterval := modulePollInterval(&module)
+	upstreamMoved := false
+	if ready && module.Status.ObservedGeneration == module.Generation {
+		if pollInterval == 0 {
+			ctrl.Log.Info("Module spec unchanged since last sync, skipping reconciliation", "name", module.Name, "generation", module.Generation)
+			return ctrl.Result{}, nil
+		}
+		changed, err := r.upstreamCommitChanged(ctx, &module)
+		if err != nil {
+			ctrl.Log.Error(err, "Failed to poll module upstream", "name", module.Name)
+			r.emitModuleEvent(&module, "Warning", "PollFailed", err.Error())
+			return ctrl.Result{RequeueAfter: pollInterval}, nil
+		}
+		if !changed {
+			return ctrl.Result{RequeueAfter: pollInterval}, nil
+		}
+		upstreamMoved = true
+		r.emitModuleEvent(&module, "Normal", "UpstreamChanged", fmt.Sprintf("Upstream %s moved, re-parsing module", refOrHead(module.Spec.Source.Version)))
 	}
 
-	// Emit event: reconciliation started
-	r.emitModuleEvent(&module, "Normal", "Reconciling", "Reconciling Module resource")
-	ctrl.Log.Info("Reconciling Module resource", "name", module.Name)
-
-	// Compute hash of type, url, version
+	// Compute hash of type, url, version, path
 	source := module.Spec.Source
-	hashInput := source.Type + "|" + source.URL + "|" + source.Version
+	hashInput := source.Type + "|" + source.URL + "|" + source.Version + "|" + source.Path
+	if moduleVerificationEnabled(source) {
+		// Appended only when set so existing Modules keep their hash
+		hashInput += "|" + source.Checksum
+		if source.Verification != nil {
+			hashInput += "|" + source.Verification.SecretRef.Name + "|" + source.Verification.SignatureURL
+		}
+	}
 	hash := fmt.Sprintf("%x", sha256.Sum256([]byte(hashInput)))
 
-	// Check if hash is already stored in status. If not, or if changed, proceed. Otherwise, skip reconcile.
-	if module.Status.Conditions != nil {
-		for _, cond := range module.Status.Conditions {
-			if cond.Type == "SourceHash" && cond.Status == hash {
-				ctrl.Log.Info("No change in type, url, or version; skipping reconciliation", "hash", hash)
-				return ctrl.Result{}, nil
-			}
+	// A spec change that leaves the source untouched (e.g. rotated credentials) needs no re-fetch
+	if ready && storedHash == hash && !upstreamMoved {
+		ctrl.Log.Info("No change in type, url, version, or path; recording observed generation", "hash", hash, "generation", module.Generation)
+		module.Status.ObservedGeneration = module.Generation
+		if err := r.Status().Update(ctx, &module); err != nil && !k8serrors.IsNotFound(err) {
+			return ctrl.Result{}, err
 		}
+		return ctrl.Result{RequeueAfter: pollInterval}, nil
 	}
 
+	// Emit event: reconciliation started
+	r.emitModuleEvent(&module, "Normal", "Reconciling", "Reconciling Module resource")
+	ctrl.Log.Info("Reconciling Module resource", "name", module.Name, "generation", module.Generation)
+	module.Status.ObservedGeneration = module.Generation
+	module.Status.ResolvedVersion = ""
+	module.Status.ResolvedDigest = ""
+	module.Status.ResolvedChecksum = ""
+	previousCommit := module.Status.ResolvedCommit
+	previousStatus := *module.Status.DeepCopy()
+	module.Status.ResolvedCommit = ""
+
 	setCondition := func(condType, status, reason, message string) {
 		now := metav1.Now()
 		found := false
@@ -145,119 +181,90 @@ func (r *ModuleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 	os.MkdirAll(workDir, 0755)
 
 	var fetchErr error
+	fetchReason := "CloneFailed"
 	var moduleDir string = workDir
+	subdir := source.Path
+	verifier, err := loadSourceVerifier(ctx, r.Client, module.Namespace, source)
+	if err != nil {
+		setCondition("Verified", "False", "VerificationKeyInvalid", err.Error())
+		setCondition("Ready", "False", "VerificationKeyInvalid", err.Error())
+		ctrl.Log.Error(err, "Failed to load module verification keys")
+		r.emitModuleEvent(&module, "Warning", "VerificationKeyInvalid", err.Error())
+		if module.ObjectMeta.DeletionTimestamp == nil {
+			_ = r.Status().Update(ctx, &module)
+		}
+		return ctrl.Result{RequeueAfter: 1 * time.Minute}, nil
+	}
 	switch module.Spec.Source.Type {
 	case "git":
-		ctrl.Log.Info("Cloning git repository", "url", module.Spec.Source.URL, "version", module.Spec.Source.Version)
-		cmd := exec.Command("git", "clone", module.Spec.Source.URL, workDir)
-		if module.Spec.Source.Version != "" {
-			cmd = exec.Command("git", "clone", "--branch", module.Spec.Source.Version, module.Spec.Source.URL, workDir)
+		auth, err := loadGitAuth(ctx, r.Client, module.Namespace, source)
+		if err != nil {
+			fetchErr = err
+			fetchReason = "CredentialsInvalid"
+			break
 		}
-		fetchErr = cmd.Run()
-	case "http":
-		ctrl.Log.Info("Downloading and extracting HTTP archive", "url", module.Spec.Source.URL)
-		resp, err := http.Get(module.Spec.Source.URL)
+		authDir := workDir + "-auth"
+		defer os.RemoveAll(authDir)
+		env, err := gitAuthEnv([]*gitAuth{auth}, authDir)
 		if err != nil {
 			fetchErr = err
 			break
 		}
-		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusOK {
-			fetchErr = fmt.Errorf("failed to download file: %s", resp.Status)
+		fetchErr = fetchGitSource(workDir, source.URL, source.Version, env)
+		if fetchErr == nil {
+			fetchErr = verifier.verifyGitTag(workDir, source.Version)
+		}
+		if fetchErr == nil {
+			module.Status.ResolvedCommit, fetchErr = gitHeadCommit(workDir)
+		}
+	case "http":
+		moduleDir, fetchErr = fetchHTTPSource(workDir, source.URL, r.archiveLimits(), verifier)
+		if fetchErr == nil && verifier != nil {
+			module.Status.ResolvedChecksum = verifier.ArchiveChecksum
+		}
+	case "registry":
+		if verifier != nil {
+			// terraform init downloads the module from the registry again, so the
+			// bytes Stacks run would never be the ones verified here
+			fetchErr = &errVerificationFailed{Reason: "checksum and verification are not supported for registry sources; use the http or git source the registry points to instead"}
+			break
+		}
+		var location, locationSubdir string
+		location, fetchErr = r.resolveRegistrySource(ctx, &module)
+		if fetchErr != nil {
+			fetchReason = "VersionResolutionFailed"
+			break
+		}
+		location, locationSubdir = splitGetterSubdir(location)
+		if locationSubdir != "" {
+			subdir = path.Join(locationSubdir, source.Path)
+		}
+		if gitURL, ref, ok := parseGitGetterAddress(location); ok {
+			fetchErr = fetchGitSource(workDir, gitURL, ref, nil)
+		} else {
+			moduleDir, fetchErr = fetchHTTPSource(workDir, location, r.archiveLimits(), nil)
+		}
+	case "oci":
+		if verifier != nil {
+			// oci artifacts are already content-addressed by their manifest digest
+			fetchErr = &errVerificationFailed{Reason: "checksum and verification are not supported for oci sources; pin the version to a digest instead"}
 			break
 		}
-		os.MkdirAll(workDir, 0755)
-		tmpFile, err := ioutil.TempFile(workDir, "module-archive-*")
+		ociClient, err := ociClientFor(ctx, r.Client, r.OCI, module.Namespace, source)
 		if err != nil {
 			fetchErr = err
+			fetchReason = "CredentialsInvalid"
 			break
 		}
-		defer os.Remove(tmpFile.Name())
-		_, err = io.Copy(tmpFile, resp.Body)
+		ref, err := oci.ParseReference(source.URL, source.Version)
 		if err != nil {
 			fetchErr = err
 			break
 		}
-		tmpFile.Close()
-		ext := strings.ToLower(path.Ext(module.Spec.Source.URL))
-		if ext == ".zip" {
-			r, err := zip.OpenReader(tmpFile.Name())
-			if err != nil {
-				fetchErr = err
-				break
-			}
-			defer r.Close()
-			for _, f := range r.File {
-				fpath := filepath.Join(workDir, f.Name)
-				if f.FileInfo().IsDir() {
-					os.MkdirAll(fpath, f.Mode())
-					continue
-				}
-				if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
-					fetchErr = err
-					break
-				}
-				outFile, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-				if err != nil {
-					fetchErr = err
-					break
-				}
-				rc, err := f.Open()
-				if err != nil {
-					outFile.Close()
-					fetchErr = err
-					break
-				}
-				_, err = io.Copy(outFile, rc)
-				outFile.Close()
-				rc.Close()
-				if err != nil {
-					fetchErr = err
-					break
-				}
-			}
-			// Detect single subdirectory after extraction
-			entries, err := ioutil.ReadDir(workDir)
-			if err == nil {
-				subdirs := []string{}
-				for _, entry := range entries {
-					if entry.IsDir() {
-						subdirs = append(subdirs, entry.Name())
-					}
-				}
-				if len(subdirs) == 1 {
-					moduleDir = filepath.Join(workDir, subdirs[0])
-					ctrl.Log.Info("Detected single subdirectory after extraction", "moduleDir", moduleDir)
-				} else {
-					ctrl.Log.Info("No single subdirectory detected after extraction", "dirs", subdirs)
-				}
-			}
-		} else if ext == ".gz" || ext == ".tgz" {
-			gz, err := os.Open(tmpFile.Name())
-			if err != nil {
-				fetchErr = err
-				break
-			}
-			defer gz.Close()
-			gzr, err := gzip.NewReader(gz)
-			if err != nil {
-				fetchErr = err
-				break
-			}
-			defer gzr.Close()
-			out, err := os.Create(filepath.Join(workDir, "main.tf"))
-			if err != nil {
-				fetchErr = err
-				break
-			}
-			defer out.Close()
-			_, err = io.Copy(out, gzr)
-			if err != nil {
-				fetchErr = err
-				break
-			}
-		} else {
-			fetchErr = fmt.Errorf("unsupported archive type: %s", ext)
+		var digest string
+		moduleDir, digest, fetchErr = fetchOCISource(ctx, ociClient, workDir, ref, r.archiveLimits())
+		if fetchErr == nil {
+			module.Status.ResolvedDigest = digest
 		}
 	case "local":
 		ctrl.Log.Info("Local source type not implemented yet")
@@ -268,158 +275,180 @@ func (r *ModuleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 	}
 
 	if fetchErr != nil {
-		setCondition("Ready", "False", "CloneFailed", "Failed to fetch module source")
+		var violation *archive.ViolationError
+		if errors.As(fetchErr, &violation) {
+			fetchReason = "UnsafeArchive"
+			r.emitModuleEvent(&module, "Warning", "UnsafeArchive", violation.Error())
+		}
+		var unverified *errVerificationFailed
+		if errors.As(fetchErr, &unverified) {
+			fetchReason = "VerificationFailed"
+			setCondition("Verified", "False", "VerificationFailed", unverified.Error())
+			r.emitModuleEvent(&module, "Warning", "VerificationFailed", unverified.Error())
+		}
+		// Do not leave partially extracted content on the node's disk
+		os.RemoveAll(workDir)
+		setCondition("Ready", "False", fetchReason, "Failed to fetch module source: "+fetchErr.Error())
 		ctrl.Log.Error(fetchErr, "Failed to fetch module source")
 		if module.ObjectMeta.DeletionTimestamp == nil {
 			_ = r.Status().Update(ctx, &module)
 		}
 		return ctrl.Result{RequeueAfter: 1 * time.Minute}, nil
 	}
+	// Honor spec.source.path relative to the repository or archive root
+	if subdir != "" {
+		resolvedDir, err := resolveModulePath(workDir, subdir)
+		if err != nil {
+			setCondition("Ready", "False", "PathNotFound", err.Error())
+			ctrl.Log.Error(err, "Failed to resolve module path", "path", subdir)
+			r.emitModuleEvent(&module, "Warning", "PathNotFound", err.Error())
+			if module.ObjectMeta.DeletionTimestamp == nil {
+				_ = r.Status().Update(ctx, &module)
+			}
+			return ctrl.Result{RequeueAfter: 1 * time.Minute}, nil
+		}
+		moduleDir = resolvedDir
+	}
+	if verifier != nil {
+		setCondition("Verified", "True", "Verified", verifier.describe())
+	} else {
+		removeModuleCondition(&module, "Verified")
+	}
 	setCondition("Ready", "False", "Cloned", "Module source cloned successfully")
 	// Store the new hash in status
 	setCondition("SourceHash", hash, "", "")
 
-	ctrl.Log.Info("Running terraform-docs", "dir", moduleDir)
-	docsCmd := exec.Command("terraform-docs", "json", moduleDir)
-	docsOut, err := docsCmd.Output()
+	ctrl.Log.Info("Parsing module", "dir", moduleDir)
+	parsed, err := tfmodule.Load(moduleDir)
 	if err != nil {
-		setCondition("Ready", "False", "ParseFailed", "Failed to run terraform-docs")
-		ctrl.Log.Error(err, "Failed to run terraform-docs", "dir", moduleDir)
+		setCondition("Ready", "False", "ParseFailed", "Failed to parse module: "+err.Error())
+		ctrl.Log.Error(err, "Failed to parse module", "dir", moduleDir)
+		r.emitModuleEvent(&module, "Warning", "ParseFailed", err.Error())
 		if module.ObjectMeta.DeletionTimestamp == nil {
 			_ = r.Status().Update(ctx, &module)
 		}
 		return ctrl.Result{RequeueAfter: 1 * time.Minute}, nil
 	}
-	setCondition("Ready", "False", "Parsed", "terraform-docs output parsed successfully")
-
-	// Parse terraform-docs output (JSON) and update status
-	type tfDocs struct {
-		Inputs []struct {
-			Name        string      `json:"name"`
-			Type        string      `json:"type"`
-			Description string      `json:"description"`
-			Default     interface{} `json:"default"`
-			Required    bool        `json:"required"`
-			Sensitive   bool        `json:"sensitive"`
-		} `json:"inputs"`
-		Outputs []struct {
-			Name        string `json:"name"`
-			Description string `json:"description"`
-			Sensitive   bool   `json:"sensitive"`
-			Type        string `json:"type"`
-		} `json:"outputs"`
-		Providers []struct {
-			Name    string `json:"name"`
-			Source  string `json:"source"`
-			Version string `json:"version"`
-		} `json:"providers"`
-		Requirements json.RawMessage `json:"requirements"`
-		Resources    []struct {
-			Name string `json:"name"`
-			Type string `json:"type"`
-		} `json:"resources"`
-		Modules []struct {
-			Name   string `json:"name"`
-			Source string `json:"source"`
-		} `json:"modules"`
-		Description string `json:"description"`
+	inputSchema, err := parsed.InputSchema()
+	if err != nil {
+		setCondition("Ready", "False", "ParseFailed", "Failed to build input schema: "+err.Error())
+		ctrl.Log.Error(err, "Failed to build module input schema", "dir", moduleDir)
+		if module.ObjectMeta.DeletionTimestamp == nil {
+			_ = r.Status().Update(ctx, &module)
+		}
+		return ctrl.Result{RequeueAfter: 1 * time.Minute}, nil
+	}
+	setCondition("Ready", "False", "Parsed", "Module parsed successfully")
+	applyParsedModule(&module.Status, parsed)
+	module.Status.InputSchema = &apiextensionsv1.JSON{Raw: inputSchema}
+	module.Status.LastSynced = metav1.Now()
+	setCondition("Ready", "True", "Synced", "Module successfully parsed and status updated")
+	if previousCommit != "" && previousCommit != module.Status.ResolvedCommit {
+		if changes := moduleInterfaceChanges(previousStatus, module.Status); changes != "" {
+			r.emitModuleEvent(&module, "Normal", "InterfaceChanged", fmt.Sprintf("Module interface changed at %s: %s", module.Status.ResolvedCommit, changes))
+		}
 	}
 
-	var docs tfDocs
-	if err := json.Unmarshal(docsOut, &docs); err != nil {
-		ctrl.Log.Error(err, "Failed to parse terraform-docs output")
-		return ctrl.Result{RequeueAfter: 1 * time.Minute}, nil
+	// Ensure required fields are always set
+	if module.Status.Conditions == nil {
+		module.Status.Conditions = []astrolabev1.ModuleCondition{}
+	}
+	if module.Status.Requirements.RequiredProviders == nil {
+		module.Status.Requirements.RequiredProviders = map[string]string{}
 	}
 
-	// Handle requirements as object or array
-	reqObj := struct {
-		Terraform struct {
-			RequiredVersion string `json:"required_version"`
-		} `json:"terraform"`
-		RequiredProviders map[string]string `json:"required_providers"`
-	}{}
-	if len(docs.Requirements) > 0 && docs.Requirements[0] == '{' {
-		_ = json.Unmarshal(docs.Requirements, &reqObj)
+	if module.ObjectMeta.DeletionTimestamp == nil {
+		if err := r.Status().Update(ctx, &module); err != nil {
+			// Ignore not found and precondition failed errors during deletion
+			if k8serrors.IsNotFound(err) || isPreconditionFailed(err) || (err != nil && strings.Contains(err.Error(), "Precondition failed")) {
+				ctrl.Log.Info("Ignoring status update error during deletion", "error", err)
+				return ctrl.Result{}, nil
+			}
+			ctrl.Log.Error(err, "Failed to update Module status")
+			return ctrl.Result{}, err
+		}
 	}
 
-	module.Status.Description = docs.Description
-	module.Status.Inputs = make([]astrolabev1.ModuleInput, len(docs.Inputs))
-	for i, in := range docs.Inputs {
+	return ctrl.Result{RequeueAfter: pollInterval}, nil
+}
+
+// applyParsedModule copies a parsed module interface into the Module status.
+func applyParsedModule(status *astrolabev1.ModuleStatus, parsed *tfmodule.Module) {
+	status.Inputs = make([]astrolabev1.ModuleInput, len(parsed.Variables))
+	for i, v := range parsed.Variables {
 		var def *apiextensionsv1.JSON
-		if in.Default != nil {
-			defBytes, _ := json.Marshal(in.Default)
-			def = &apiextensionsv1.JSON{Raw: defBytes}
-		}
-		module.Status.Inputs[i] = astrolabev1.ModuleInput{
-			Name:        in.Name,
-			Type:        in.Type,
-			Description: in.Description,
+		if v.Default != nil {
+			def = &apiextensionsv1.JSON{Raw: v.Default}
+		}
+		status.Inputs[i] = astrolabev1.ModuleInput{
+			Name:        v.Name,
+			Type:        v.Type,
+			Description: v.Description,
 			Default:     def,
-			Required:    in.Required,
-			Sensitive:   in.Sensitive,
+			Required:    v.Required,
+			Sensitive:   v.Sensitive,
 		}
 	}
-	module.Status.Outputs = make([]astrolabev1.ModuleOutput, len(docs.Outputs))
-	for i, out := range docs.Outputs {
-		module.Status.Outputs[i] = astrolabev1.ModuleOutput{
+	status.Outputs = make([]astrolabev1.ModuleOutput, len(parsed.Outputs))
+	for i, out := range parsed.Outputs {
+		status.Outputs[i] = astrolabev1.ModuleOutput{
 			Name:        out.Name,
-			Type:        out.Type,
 			Description: out.Description,
 			Sensitive:   out.Sensitive,
 		}
 	}
-	module.Status.Providers = make([]astrolabev1.ModuleProvider, len(docs.Providers))
-	for i, p := range docs.Providers {
-		module.Status.Providers[i] = astrolabev1.ModuleProvider{
+	status.Providers = make([]astrolabev1.ModuleProvider, len(parsed.Providers))
+	for i, p := range parsed.Providers {
+		status.Providers[i] = astrolabev1.ModuleProvider{
 			Name:    p.Name,
 			Source:  p.Source,
 			Version: p.Version,
 		}
 	}
-	module.Status.Requirements = astrolabev1.ModuleRequirements{
+	status.Requirements = astrolabev1.ModuleRequirements{
 		Terraform: astrolabev1.ModuleTerraformRequirements{
-			RequiredVersion: reqObj.Terraform.RequiredVersion,
+			RequiredVersion: parsed.RequiredVersion,
 		},
-		RequiredProviders: reqObj.RequiredProviders,
+		RequiredProviders: parsed.RequiredProviders,
 	}
-	module.Status.Resources = make([]astrolabev1.ModuleResource, len(docs.Resources))
-	for i, r := range docs.Resources {
-		module.Status.Resources[i] = astrolabev1.ModuleResource{
-			Name: r.Name,
-			Type: r.Type,
+	status.Resources = make([]astrolabev1.ModuleResource, len(parsed.Resources))
+	for i, res := range parsed.Resources {
+		typ := res.Type
+		if res.Mode == "data" {
+			// Keep data sources distinguishable from managed resources of the same type
+			typ = "data." + typ
+		}
+		status.Resources[i] = astrolabev1.ModuleResource{
+			Name: res.Name,
+			Type: typ,
 		}
 	}
-	module.Status.Submodules = make([]astrolabev1.ModuleSubmodule, len(docs.Modules))
-	for i, m := range docs.Modules {
-		module.Status.Submodules[i] = astrolabev1.ModuleSubmodule{
+	status.Submodules = make([]astrolabev1.ModuleSubmodule, len(parsed.ModuleCalls))
+	for i, m := range parsed.ModuleCalls {
+		status.Submodules[i] = astrolabev1.ModuleSubmodule{
 			Name:   m.Name,
 			Source: m.Source,
 		}
 	}
-	module.Status.LastSynced = metav1.Now()
-	setCondition("Ready", "True", "Synced", "Module successfully parsed and status updated")
+}
 
-	// Ensure required fields are always set
-	if module.Status.Conditions == nil {
-		module.Status.Conditions = []astrolabev1.ModuleCondition{}
-	}
-	if module.Status.Requirements.RequiredProviders == nil {
-		module.Status.Requirements.RequiredProviders = map[string]string{}
+// refOrHead names a git ref for messages, defaulting to HEAD.
+func refOrHead(ref string) string {
+	if ref == "" {
+		return "HEAD"
 	}
+	return ref
+}
 
-	if module.ObjectMeta.DeletionTimestamp == nil {
-		if err := r.Status().Update(ctx, &module); err != nil {
-			// Ignore not found and precondition failed errors during deletion
-			if k8serrors.IsNotFound(err) || isPreconditionFailed(err) || (err != nil && strings.Contains(err.Error(), "Precondition failed")) {
-				ctrl.Log.Info("Ignoring status update error during deletion", "error", err)
-				return ctrl.Result{}, nil
-			}
-			ctrl.Log.Error(err, "Failed to update Module status")
-			return ctrl.Result{}, err
+// removeModuleCondition drops the condition of the given type from the Module status.
+func removeModuleCondition(module *astrolabev1.Module, condType string) {
+	conds := module.Status.Conditions[:0]
+	for _, cond := range module.Status.Conditions {
+		if cond.Type != condType {
+			conds = append(conds, cond)
 		}
 	}
-
-	return ctrl.Result{}, nil
+	module.Status.Conditions = conds
 }
 
 // isPreconditionFailed checks if the error is a precondition failed error
@@ -433,7 +462,8 @@ func isPreconditionFailed(err error) bool {
 func (r *ModuleReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.Recorder = mgr.GetEventRecorderFor("module-controller")
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&astrolabev1.Module{}).
+		// Status-only updates do not bump metadata.generation and need no reconcile
+		For(&astrolabev1.Module{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
 		Complete(r)
 }
 
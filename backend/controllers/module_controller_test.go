@@ -0,0 +1,264 @@
+package controllers
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+	s := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(s))
+	require.NoError(t, astrolabev1.AddToScheme(s))
+	return s
+}
+
+func readyModule(generation, observed int64, src astrolabev1.ModuleSource) *astrolabev1.Module {
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte(src.Type+"|"+src.URL+"|"+src.Version+"|"+src.Path)))
+	return &astrolabev1.Module{
+		ObjectMeta: metav1.ObjectMeta{Name: "vpc", Namespace: "default", Generation: generation},
+		Spec:       astrolabev1.ModuleSpec{Source: src},
+		Status: astrolabev1.ModuleStatus{
+			ObservedGeneration: observed,
+			Conditions: []astrolabev1.ModuleCondition{
+				{Type: "Ready", Status: "True", Reason: "Synced"},
+				{Type: "SourceHash", Status: hash},
+			},
+		},
+	}
+}
+
+func TestModuleReconcileSkipsObservedGeneration(t *testing.T) {
+	// An unreachable URL proves no fetch is attempted
+	src := astrolabev1.ModuleSource{Type: "git", URL: "https://invalid.example/none.git", Version: "v1.0.0"}
+	mod := readyModule(3, 3, src)
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(mod).WithStatusSubresource(mod).Build()
+	r := &ModuleReconciler{Client: c}
+
+	res, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "vpc"}})
+	require.NoError(t, err)
+	assert.Equal(t, ctrl.Result{}, res)
+}
+
+func TestModuleReconcileRecordsGenerationWhenSourceUnchanged(t *testing.T) {
+	src := astrolabev1.ModuleSource{Type: "git", URL: "https://invalid.example/none.git", Version: "v1.0.0"}
+	mod := readyModule(4, 3, src)
+	// Credentials changed, but the source itself did not
+	mod.Spec.Source.SecretRef = &astrolabev1.ModuleSecretRef{Name: "rotated"}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(mod).WithStatusSubresource(mod).Build()
+	r := &ModuleReconciler{Client: c}
+
+	key := types.NamespacedName{Namespace: "default", Name: "vpc"}
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+
+	var got astrolabev1.Module
+	require.NoError(t, c.Get(context.Background(), client.ObjectKey(key), &got))
+	assert.Equal(t, int64(4), got.Status.ObservedGeneration)
+	assert.Equal(t, "True", got.Status.Conditions[0].Status)
+}
+
+func TestModuleReconcileRefetchesOnVersionBump(t *testing.T) {
+	src := astrolabev1.ModuleSource{Type: "git", URL: "https://invalid.example/none.git", Version: "v1.0.0"}
+	mod := readyModule(5, 4, src)
+	mod.Spec.Source.Version = "v2.0.0"
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(mod).WithStatusSubresource(mod).Build()
+	r := &ModuleReconciler{Client: c}
+
+	key := types.NamespacedName{Namespace: "default", Name: "vpc"}
+	res, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	assert.NotZero(t, res.RequeueAfter)
+
+	var got astrolabev1.Module
+	require.NoError(t, c.Get(context.Background(), client.ObjectKey(key), &got))
+	assert.Equal(t, int64(5), got.Status.ObservedGeneration)
+	assert.Equal(t, "False", got.Status.Conditions[0].Status)
+	assert.Equal(t, "CloneFailed", got.Status.Conditions[0].Reason)
+}
+
+func TestModuleReconcileReportsUnsafeArchive(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../../evil.tf", Typeflag: tar.TypeReg, Mode: 0644, Size: 1}))
+	_, err := tw.Write([]byte("x"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(buf.Bytes())
+	}))
+	defer srv.Close()
+
+	mod := &astrolabev1.Module{
+		ObjectMeta: metav1.ObjectMeta{Name: "vpc", Namespace: "default", Generation: 1},
+		Spec:       astrolabev1.ModuleSpec{Source: astrolabev1.ModuleSource{Type: "http", URL: srv.URL + "/vpc.tar"}},
+	}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(mod).WithStatusSubresource(mod).Build()
+	r := &ModuleReconciler{Client: c}
+
+	key := types.NamespacedName{Namespace: "default", Name: "vpc"}
+	_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+
+	var got astrolabev1.Module
+	require.NoError(t, c.Get(context.Background(), client.ObjectKey(key), &got))
+	assert.Equal(t, "False", got.Status.Conditions[0].Status)
+	assert.Equal(t, "UnsafeArchive", got.Status.Conditions[0].Reason)
+}
+
+func TestModuleReconcileReportsChecksumMismatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("tampered"))
+	}))
+	defer srv.Close()
+
+	mod := &astrolabev1.Module{
+		ObjectMeta: metav1.ObjectMeta{Name: "vpc", Namespace: "default", Generation: 1},
+		Spec: astrolabev1.ModuleSpec{Source: astrolabev1.ModuleSource{
+			Type:     "http",
+			URL:      srv.URL + "/vpc.tar.gz",
+			Checksum: fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("original"))),
+		}},
+	}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(mod).WithStatusSubresource(mod).Build()
+	r := &ModuleReconciler{Client: c}
+
+	key := types.NamespacedName{Namespace: "default", Name: "vpc"}
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+
+	var got astrolabev1.Module
+	require.NoError(t, c.Get(context.Background(), client.ObjectKey(key), &got))
+	conds := map[string]astrolabev1.ModuleCondition{}
+	for _, cond := range got.Status.Conditions {
+		conds[cond.Type] = cond
+	}
+	assert.Equal(t, "VerificationFailed", conds["Ready"].Reason)
+	assert.Equal(t, "False", conds["Verified"].Status)
+	assert.Contains(t, moduleUnverifiedReason(got), "checksum mismatch")
+}
+
+func TestModuleReconcileRejectsRegistryVerification(t *testing.T) {
+	mod := &astrolabev1.Module{
+		ObjectMeta: metav1.ObjectMeta{Name: "vpc", Namespace: "default", Generation: 1},
+		Spec: astrolabev1.ModuleSpec{Source: astrolabev1.ModuleSource{
+			Type:     "registry",
+			URL:      "terraform-aws-modules/vpc/aws",
+			Checksum: fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("original"))),
+		}},
+	}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(mod).WithStatusSubresource(mod).Build()
+	r := &ModuleReconciler{Client: c}
+
+	// Rejected before the registry is contacted
+	key := types.NamespacedName{Namespace: "default", Name: "vpc"}
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+
+	var got astrolabev1.Module
+	require.NoError(t, c.Get(context.Background(), client.ObjectKey(key), &got))
+	conds := map[string]astrolabev1.ModuleCondition{}
+	for _, cond := range got.Status.Conditions {
+		conds[cond.Type] = cond
+	}
+	assert.Equal(t, "VerificationFailed", conds["Ready"].Reason)
+	assert.Equal(t, "False", conds["Verified"].Status)
+	assert.Contains(t, moduleUnverifiedReason(got), "not supported for registry sources")
+}
+
+// serveModuleTar serves a tarball of the given files from an httptest server.
+func serveModuleTar(t *testing.T, files map[string]string) *httptest.Server {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, body := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(body))}))
+		_, err := tw.Write([]byte(body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(buf.Bytes())
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func reconcileHTTPModule(t *testing.T, url string) astrolabev1.Module {
+	t.Helper()
+	mod := &astrolabev1.Module{
+		ObjectMeta: metav1.ObjectMeta{Name: "vpc", Namespace: "default", Generation: 1},
+		Spec:       astrolabev1.ModuleSpec{Source: astrolabev1.ModuleSource{Type: "http", URL: url}},
+	}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(mod).WithStatusSubresource(mod).Build()
+	r := &ModuleReconciler{Client: c}
+	key := types.NamespacedName{Namespace: "default", Name: "vpc"}
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	var got astrolabev1.Module
+	require.NoError(t, c.Get(context.Background(), client.ObjectKey(key), &got))
+	return got
+}
+
+func TestModuleReconcileParsesModule(t *testing.T) {
+	srv := serveModuleTar(t, map[string]string{
+		"vpc/main.tf": `
+terraform {
+  required_providers {
+    aws = { source = "hashicorp/aws", version = "~> 5.0" }
+  }
+}
+resource "aws_vpc" "this" {}
+module "endpoints" { source = "./modules/endpoints" }
+`,
+		"vpc/variables.tf": `
+variable "name" { type = string }
+variable "cidr" {
+  type    = string
+  default = "10.0.0.0/16"
+}
+`,
+		"vpc/outputs.tf": `output "vpc_id" { value = aws_vpc.this.id }`,
+	})
+
+	got := reconcileHTTPModule(t, srv.URL+"/vpc.tar")
+	assert.Equal(t, "True", got.Status.Conditions[0].Status, got.Status.Conditions[0].Message)
+	require.Len(t, got.Status.Inputs, 2)
+	assert.Equal(t, "cidr", got.Status.Inputs[0].Name)
+	assert.JSONEq(t, `"10.0.0.0/16"`, string(got.Status.Inputs[0].Default.Raw))
+	assert.True(t, got.Status.Inputs[1].Required)
+	assert.Equal(t, []astrolabev1.ModuleOutput{{Name: "vpc_id"}}, got.Status.Outputs)
+	assert.Equal(t, map[string]string{"aws": "~> 5.0"}, got.Status.Requirements.RequiredProviders)
+	assert.Equal(t, []astrolabev1.ModuleResource{{Name: "this", Type: "aws_vpc"}}, got.Status.Resources)
+	assert.Equal(t, []astrolabev1.ModuleSubmodule{{Name: "endpoints", Source: "./modules/endpoints"}}, got.Status.Submodules)
+	require.NotNil(t, got.Status.InputSchema)
+	assert.Contains(t, string(got.Status.InputSchema.Raw), `"required":["name"]`)
+}
+
+func TestModuleReconcileReportsParseDiagnostics(t *testing.T) {
+	srv := serveModuleTar(t, map[string]string{
+		"vpc/main.tf": "resource \"aws_vpc\" \"this\" {\n  cidr_block = \n}\n",
+	})
+
+	got := reconcileHTTPModule(t, srv.URL+"/vpc.tar")
+	assert.Equal(t, "False", got.Status.Conditions[0].Status)
+	assert.Equal(t, "ParseFailed", got.Status.Conditions[0].Reason)
+	assert.Contains(t, got.Status.Conditions[0].Message, "main.tf:2,")
+}
@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// minPollInterval bounds spec.source.pollInterval so a typo cannot hammer upstream remotes.
+const minPollInterval = 30 * time.Second
+
+// modulePollInterval returns how often a Module's upstream should be polled, or 0 if never.
+func modulePollInterval(module *astrolabev1.Module) time.Duration {
+	src := module.Spec.Source
+	if src.Type != "git" || src.PollInterval == nil || src.PollInterval.Duration <= 0 {
+		return 0
+	}
+	if src.PollInterval.Duration < minPollInterval {
+		return minPollInterval
+	}
+	return src.PollInterval.Duration
+}
+
+// upstreamCommitChanged reports whether the commit a git Module's ref points to
+// upstream differs from status.resolvedCommit.
+func (r *ModuleReconciler) upstreamCommitChanged(ctx context.Context, module *astrolabev1.Module) (bool, error) {
+	src := module.Spec.Source
+	auth, err := loadGitAuth(ctx, r.Client, module.Namespace, src)
+	if err != nil {
+		return false, err
+	}
+	authDir := filepath.Join(os.TempDir(), "astrolabe-modules", module.Name+"-"+string(module.UID)+"-poll-auth")
+	defer os.RemoveAll(authDir)
+	env, err := gitAuthEnv([]*gitAuth{auth}, authDir)
+	if err != nil {
+		return false, err
+	}
+	commit, err := gitRemoteCommit(src.URL, src.Version, env)
+	if err != nil {
+		return false, err
+	}
+	if commit == "" {
+		// The version is not a branch or tag (e.g. a commit SHA), so it cannot move
+		return false, nil
+	}
+	if commit != module.Status.ResolvedCommit {
+		ctrl.Log.Info("Upstream commit changed", "name", module.Name, "ref", src.Version, "from", module.Status.ResolvedCommit, "to", commit)
+		return true, nil
+	}
+	return false, nil
+}
+
+// moduleInterfaceChanges describes how the inputs and outputs differ between two
+// parsed statuses, e.g. "added input foo; removed output bar". It is empty when
+// the module interface is unchanged.
+func moduleInterfaceChanges(before, after astrolabev1.ModuleStatus) string {
+	changes := []string{}
+	diff := func(kind string, old, cur map[string]string) {
+		for name, typ := range cur {
+			prev, ok := old[name]
+			switch {
+			case !ok:
+				changes = append(changes, fmt.Sprintf("added %s %s", kind, name))
+			case prev != typ:
+				changes = append(changes, fmt.Sprintf("changed %s %s", kind, name))
+			}
+		}
+		for name := range old {
+			if _, ok := cur[name]; !ok {
+				changes = append(changes, fmt.Sprintf("removed %s %s", kind, name))
+			}
+		}
+	}
+	inputs := func(s astrolabev1.ModuleStatus) map[string]string {
+		m := map[string]string{}
+		for _, in := range s.Inputs {
+			m[in.Name] = fmt.Sprintf("%s|%t", in.Type, in.Required)
+		}
+		return m
+	}
+	// Outputs declare no type for tfmodule to record, so only names count
+	outputs := func(s astrolabev1.ModuleStatus) map[string]string {
+		m := map[string]string{}
+		for _, out := range s.Outputs {
+			m[out.Name] = ""
+		}
+		return m
+	}
+	diff("input", inputs(before), inputs(after))
+	diff("output", outputs(before), outputs(after))
+	sort.Strings(changes)
+	return strings.Join(changes, "; ")
+}
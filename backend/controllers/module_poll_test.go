@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// gitCmd runs git in dir with a fixed identity, failing the test on error.
+func gitCmd(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	return strings.TrimSpace(string(out))
+}
+
+func TestGitRemoteCommit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	gitCmd(t, repo, "init", "-q", "-b", "main")
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "main.tf"), []byte(`variable "a" {}`), 0644))
+	gitCmd(t, repo, "add", ".")
+	gitCmd(t, repo, "commit", "-q", "-m", "first")
+	first := gitCmd(t, repo, "rev-parse", "HEAD")
+	gitCmd(t, repo, "tag", "-a", "v1.0.0", "-m", "release")
+
+	commit, err := gitRemoteCommit(repo, "main", nil)
+	require.NoError(t, err)
+	assert.Equal(t, first, commit)
+
+	// Annotated tags are peeled to the tagged commit
+	commit, err = gitRemoteCommit(repo, "v1.0.0", nil)
+	require.NoError(t, err)
+	assert.Equal(t, first, commit)
+
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "main.tf"), []byte(`variable "b" {}`), 0644))
+	gitCmd(t, repo, "commit", "-q", "-am", "second")
+	second := gitCmd(t, repo, "rev-parse", "HEAD")
+
+	commit, err = gitRemoteCommit(repo, "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, second, commit)
+
+	// A commit SHA is not a ref and cannot move
+	commit, err = gitRemoteCommit(repo, first, nil)
+	require.NoError(t, err)
+	assert.Empty(t, commit)
+}
+
+func TestModulePollInterval(t *testing.T) {
+	mod := &astrolabev1.Module{Spec: astrolabev1.ModuleSpec{Source: astrolabev1.ModuleSource{Type: "git"}}}
+	assert.Zero(t, modulePollInterval(mod))
+
+	mod.Spec.Source.PollInterval = &metav1.Duration{Duration: 5 * time.Minute}
+	assert.Equal(t, 5*time.Minute, modulePollInterval(mod))
+
+	mod.Spec.Source.PollInterval = &metav1.Duration{Duration: time.Second}
+	assert.Equal(t, minPollInterval, modulePollInterval(mod))
+
+	mod.Spec.Source.Type = "http"
+	assert.Zero(t, modulePollInterval(mod))
+}
+
+func TestModuleInterfaceChanges(t *testing.T) {
+	before := astrolabev1.ModuleStatus{
+		Inputs:  []astrolabev1.ModuleInput{{Name: "name", Type: "string", Required: true}, {Name: "tags", Type: "map(string)"}},
+		Outputs: []astrolabev1.ModuleOutput{{Name: "id"}},
+	}
+	assert.Empty(t, moduleInterfaceChanges(before, before))
+
+	after := astrolabev1.ModuleStatus{
+		Inputs:  []astrolabev1.ModuleInput{{Name: "name", Type: "string", Required: true}, {Name: "tags", Type: "map(any)"}, {Name: "cidr", Type: "string"}},
+		Outputs: []astrolabev1.ModuleOutput{{Name: "arn"}},
+	}
+	assert.Equal(t, "added input cidr; added output arn; changed input tags; removed output id", moduleInterfaceChanges(before, after))
+
+	retyped := *before.DeepCopy()
+	retyped.Outputs[0].Type = "string"
+	assert.Empty(t, moduleInterfaceChanges(before, retyped), "output types are not compared")
+}
@@ -0,0 +1,535 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	"github.com/junaid18183/astrolabe/internal/archive"
+	"github.com/junaid18183/astrolabe/internal/oci"
+	"github.com/junaid18183/astrolabe/internal/registry"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// sourceHTTPClient downloads http archives and their signatures; the timeout
+// keeps a stalled server from blocking the reconcile forever.
+var sourceHTTPClient = &http.Client{Timeout: 5 * time.Minute}
+
+// errModulePathNotFound is returned when spec.source.path does not resolve to a directory.
+type errModulePathNotFound struct {
+	Path   string
+	Reason string
+}
+
+func (e *errModulePathNotFound) Error() string {
+	return fmt.Sprintf("module path %q %s", e.Path, e.Reason)
+}
+
+// resolveModulePath returns the directory inside root selected by subdir.
+// subdir follows go-getter "//subdir" semantics: it is relative to the
+// repository or archive root, must stay inside it, and may use a glob
+// pattern in a single segment as long as it matches exactly one directory.
+func resolveModulePath(root, subdir string) (string, error) {
+	subdir = strings.Trim(path.Clean("/"+subdir), "/")
+	if subdir == "" {
+		return root, nil
+	}
+	target := filepath.Join(root, filepath.FromSlash(subdir))
+	if strings.ContainsAny(subdir, "*?[") {
+		patterns := 0
+		for _, segment := range strings.Split(subdir, "/") {
+			if strings.ContainsAny(segment, "*?[") {
+				patterns++
+			}
+		}
+		if patterns > 1 {
+			return "", &errModulePathNotFound{Path: subdir, Reason: "may only use a pattern in a single path segment"}
+		}
+		matches, err := filepath.Glob(target)
+		if err != nil {
+			return "", &errModulePathNotFound{Path: subdir, Reason: fmt.Sprintf("is not a valid pattern: %v", err)}
+		}
+		if len(matches) != 1 {
+			return "", &errModulePathNotFound{Path: subdir, Reason: fmt.Sprintf("matched %d directories, expected exactly one", len(matches))}
+		}
+		target = matches[0]
+	}
+	// The checks above are lexical; a symlinked directory in the source
+	// must not lead outside of it either, and callers get the directory it
+	// points at so that moving it does not leave a dangling link behind
+	resolved, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		return "", &errModulePathNotFound{Path: subdir, Reason: "does not exist in module source"}
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(realRoot, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &errModulePathNotFound{Path: subdir, Reason: "resolves outside the module source"}
+	}
+	target = filepath.Join(root, rel)
+	info, err := os.Stat(target)
+	if err != nil {
+		return "", &errModulePathNotFound{Path: subdir, Reason: "does not exist in module source"}
+	}
+	if !info.IsDir() {
+		return "", &errModulePathNotFound{Path: subdir, Reason: "is not a directory"}
+	}
+	return target, nil
+}
+
+// moduleSourceAddress composes the Terraform module "source" argument for a Module,
+// carrying spec.source.path as a "//subdir" so terraform init fetches the same
+// directory the controller parsed.
+func moduleSourceAddress(mod astrolabev1.Module) string {
+	src := mod.Spec.Source
+	subdir := strings.Trim(path.Clean("/"+src.Path), "/")
+	switch src.Type {
+	case "oci":
+		// terraform cannot fetch oci:// sources; the Stack controller pulls the
+		// digest the Module controller parsed into the workspace instead
+		return "./" + vendoredModulePath(mod)
+	case "registry":
+		// The version is rendered as a separate argument, see renderMainTf
+		if subdir == "" {
+			return src.URL
+		}
+		return src.URL + "//" + subdir
+	case "git":
+		addr := "git::" + src.URL
+		if subdir != "" {
+			addr += "//" + subdir
+		}
+		// Pin the commit the Module controller parsed so a moved branch or tag
+		// cannot hand Stacks different content
+		switch {
+		case mod.Status.ResolvedCommit != "":
+			addr += "?ref=" + mod.Status.ResolvedCommit
+		case src.Version != "":
+			addr += "?ref=" + src.Version
+		}
+		return addr
+	default:
+		// go-getter expects the subdirectory before any query string
+		base, query, _ := strings.Cut(src.URL, "?")
+		addr := base
+		if subdir != "" {
+			addr += "//" + subdir
+		}
+		// Make terraform init reject anything but the archive the Module controller verified
+		checksum := mod.Status.ResolvedChecksum
+		if checksum == "" {
+			checksum = src.Checksum
+		}
+		if checksum != "" {
+			if query != "" {
+				query += "&"
+			}
+			query += "checksum=" + checksum
+		}
+		if query != "" {
+			addr += "?" + query
+		}
+		return addr
+	}
+}
+
+// fetchGitSource clones url into workDir, checking out ref when set. env is
+// appended to the git environment, e.g. credentials from gitAuthEnv.
+func fetchGitSource(workDir, url, ref string, env []string) error {
+	ctrl.Log.Info("Cloning git repository", "url", url, "version", ref)
+	cmd := exec.Command("git", "clone", url, workDir)
+	if ref != "" {
+		cmd = exec.Command("git", "clone", "--branch", ref, url, workDir)
+	}
+	cmd.Env = append(os.Environ(), env...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git clone failed: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// gitHeadCommit returns the commit checked out in a cloned repository.
+func gitHeadCommit(repoDir string) (string, error) {
+	out, err := exec.Command("git", "-C", repoDir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse failed: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitRemoteCommit asks the remote which commit ref (or HEAD when empty) points
+// to, without cloning. Annotated tags are peeled to the commit they tag. An
+// empty result means ref is not a branch or tag, e.g. a pinned commit SHA.
+func gitRemoteCommit(url, ref string, env []string) (string, error) {
+	if ref == "" {
+		ref = "HEAD"
+	}
+	cmd := exec.Command("git", "ls-remote", url, ref, ref+"^{}")
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("git ls-remote failed: %w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("git ls-remote failed: %w", err)
+	}
+	commit := ""
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if strings.HasSuffix(fields[1], "^{}") {
+			return fields[0], nil
+		}
+		if commit == "" {
+			commit = fields[0]
+		}
+	}
+	return commit, nil
+}
+
+// fetchHTTPSource downloads an archive from url and extracts it into workDir.
+// It returns the directory containing the module, which is the single top-level
+// directory of the archive when there is one.
+func fetchHTTPSource(workDir, url string, limits archive.Limits, verifier *sourceVerifier) (string, error) {
+	ctrl.Log.Info("Downloading and extracting HTTP archive", "url", url)
+	resp, err := sourceHTTPClient.Get(url)
+	if err != nil {
+		return workDir, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return workDir, fmt.Errorf("failed to download file: %s", resp.Status)
+	}
+	os.MkdirAll(workDir, 0755)
+	tmpFile, err := ioutil.TempFile(workDir, "module-archive-*")
+	if err != nil {
+		return workDir, err
+	}
+	defer os.Remove(tmpFile.Name())
+	err = copyDownload(tmpFile, resp.Body, limits.MaxTotalSize)
+	tmpFile.Close()
+	if err != nil {
+		return workDir, err
+	}
+	// Verify the archive before anything in it is extracted or parsed
+	if err := verifier.verifyArchive(tmpFile.Name(), url); err != nil {
+		return workDir, err
+	}
+	kind, err := httpArchiveKind(url, tmpFile.Name())
+	if err != nil {
+		return workDir, err
+	}
+	return extractModuleArchive(tmpFile.Name(), kind, workDir, limits)
+}
+
+// archiveSuffixes maps file name suffixes to archive kinds, longest first.
+var archiveSuffixes = []struct {
+	suffix string
+	kind   string
+}{
+	{".tar.gz", archive.KindTarGz},
+	{".tar.bz2", archive.KindTarBz2},
+	{".tar.xz", archive.KindTarXz},
+	{".tgz", archive.KindTarGz},
+	{".tbz2", archive.KindTarBz2},
+	{".txz", archive.KindTarXz},
+	{".gz", archive.KindTarGz},
+	{".tar", archive.KindTar},
+	{".zip", archive.KindZip},
+}
+
+// httpArchiveKind determines the archive kind of a download. Like go-getter, an
+// "archive" query parameter wins, then the URL path's extension (ignoring any
+// query string or fragment), and finally the file's magic bytes.
+func httpArchiveKind(rawURL, archivePath string) (string, error) {
+	if u, err := neturl.Parse(rawURL); err == nil {
+		if forced := u.Query().Get("archive"); forced != "" {
+			for _, a := range archiveSuffixes {
+				if "."+strings.ToLower(forced) == a.suffix {
+					return a.kind, nil
+				}
+			}
+			return "", fmt.Errorf("unsupported archive type: %s", forced)
+		}
+		lower := strings.ToLower(u.Path)
+		for _, a := range archiveSuffixes {
+			if strings.HasSuffix(lower, a.suffix) {
+				return a.kind, nil
+			}
+		}
+	}
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	magic := make([]byte, 6)
+	n, _ := io.ReadFull(f, magic)
+	magic = magic[:n]
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return archive.KindTarGz, nil
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return archive.KindTarBz2, nil
+	case bytes.HasPrefix(magic, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		return archive.KindTarXz, nil
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		return archive.KindZip, nil
+	}
+	return "", fmt.Errorf("unsupported archive type: %s", path.Ext(rawURL))
+}
+
+// vendoredModulesDir holds, inside a Stack's workspace, the module sources
+// the controller pulled because terraform cannot fetch them itself.
+const vendoredModulesDir = ".modules"
+
+// ociModuleDigest returns the manifest digest an oci Module is pinned to: the
+// one the Module controller resolved, else a digest given in the spec.
+func ociModuleDigest(mod astrolabev1.Module) string {
+	if mod.Status.ResolvedDigest != "" {
+		return mod.Status.ResolvedDigest
+	}
+	ref, err := oci.ParseReference(mod.Spec.Source.URL, mod.Spec.Source.Version)
+	if err != nil {
+		return ""
+	}
+	return ref.Digest
+}
+
+// vendoredModulePath returns the workspace-relative directory of an oci
+// Module's vendored copy. It is named after the digest and path, so an
+// existing directory already holds the right content.
+func vendoredModulePath(mod astrolabev1.Module) string {
+	sum := sha256.Sum256([]byte(ociModuleDigest(mod) + "//" + mod.Spec.Source.Path))
+	return vendoredModulesDir + "/" + hex.EncodeToString(sum[:8])
+}
+
+// ociClientFor returns base, or a copy authenticating with the credentials of
+// src.SecretRef: "username" and "password" or "token", as for HTTPS git.
+func ociClientFor(ctx context.Context, c client.Reader, base *oci.Client, namespace string, src astrolabev1.ModuleSource) (*oci.Client, error) {
+	if base == nil {
+		base = oci.NewClient(nil)
+	}
+	if src.SecretRef == nil {
+		return base, nil
+	}
+	var secret corev1.Secret
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: src.SecretRef.Name}, &secret); err != nil {
+		return nil, fmt.Errorf("failed to get registry credentials secret %q: %w", src.SecretRef.Name, err)
+	}
+	username := string(secret.Data[gitSecretUsernameKey])
+	password := string(secret.Data[gitSecretPasswordKey])
+	if password == "" {
+		password = string(secret.Data[gitSecretTokenKey])
+	}
+	if username == "" || password == "" {
+		return nil, fmt.Errorf("registry credentials secret %q must contain %s and %s or %s",
+			src.SecretRef.Name, gitSecretUsernameKey, gitSecretPasswordKey, gitSecretTokenKey)
+	}
+	return base.WithCredentials(username, password), nil
+}
+
+// fetchOCISource pulls the module artifact ref into workDir and returns the
+// module directory and the manifest digest it was resolved to.
+func fetchOCISource(ctx context.Context, c *oci.Client, workDir string, ref oci.Reference, limits archive.Limits) (string, string, error) {
+	ctrl.Log.Info("Pulling OCI module artifact", "reference", ref.String())
+	manifest, digest, err := c.Resolve(ctx, ref)
+	if err != nil {
+		return workDir, "", err
+	}
+	layer, err := manifest.ModuleLayer()
+	if err != nil {
+		return workDir, "", err
+	}
+	kind := oci.ArchiveKind(layer.MediaType)
+	if kind == "" {
+		return workDir, "", fmt.Errorf("unsupported module layer media type: %s", layer.MediaType)
+	}
+	if limits.MaxTotalSize > 0 && layer.Size > limits.MaxTotalSize {
+		return workDir, "", downloadTooLarge(limits.MaxTotalSize)
+	}
+	os.MkdirAll(workDir, 0755)
+	tmpFile, err := ioutil.TempFile(workDir, "module-archive-*")
+	if err != nil {
+		return workDir, "", err
+	}
+	defer os.Remove(tmpFile.Name())
+	// The descriptor size is not authoritative; bound what is actually written
+	err = c.FetchBlob(ctx, ref, layer, &limitedWriter{w: tmpFile, limit: limits.MaxTotalSize})
+	tmpFile.Close()
+	if err != nil {
+		return workDir, "", err
+	}
+	ctrl.Log.Info("Resolved OCI module artifact", "reference", ref.String(), "digest", digest)
+	moduleDir, err := extractModuleArchive(tmpFile.Name(), kind, workDir, limits)
+	return moduleDir, digest, err
+}
+
+// copyDownload copies a downloaded archive from src to dst, failing with an
+// archive.ViolationError once it exceeds maxSize bytes. A maxSize of 0 means
+// no limit.
+func copyDownload(dst io.Writer, src io.Reader, maxSize int64) error {
+	if maxSize <= 0 {
+		_, err := io.Copy(dst, src)
+		return err
+	}
+	n, err := io.Copy(dst, io.LimitReader(src, maxSize+1))
+	if err != nil {
+		return err
+	}
+	if n > maxSize {
+		return downloadTooLarge(maxSize)
+	}
+	return nil
+}
+
+// limitedWriter fails with an archive.ViolationError once more than limit
+// bytes were written to it. A limit of 0 means no limit.
+type limitedWriter struct {
+	w       io.Writer
+	limit   int64
+	written int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.limit > 0 && l.written+int64(len(p)) > l.limit {
+		return 0, downloadTooLarge(l.limit)
+	}
+	n, err := l.w.Write(p)
+	l.written += int64(n)
+	return n, err
+}
+
+// downloadTooLarge reports a source archive larger than the extraction limit.
+func downloadTooLarge(maxSize int64) error {
+	return &archive.ViolationError{Reason: fmt.Sprintf("download is larger than %d bytes", maxSize)}
+}
+
+// extractModuleArchive safely extracts archivePath of the given archive kind
+// into workDir and returns the directory containing the module.
+func extractModuleArchive(archivePath, kind, workDir string, limits archive.Limits) (string, error) {
+	if err := archive.Extract(archivePath, kind, workDir, limits); err != nil {
+		return workDir, err
+	}
+	return detectModuleRoot(workDir), nil
+}
+
+// detectModuleRoot returns the single top-level directory of an extracted
+// archive, or workDir when there is none.
+func detectModuleRoot(workDir string) string {
+	entries, err := ioutil.ReadDir(workDir)
+	if err != nil {
+		return workDir
+	}
+	subdirs := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			subdirs = append(subdirs, entry.Name())
+		}
+	}
+	if len(subdirs) == 1 {
+		moduleDir := filepath.Join(workDir, subdirs[0])
+		ctrl.Log.Info("Detected single subdirectory after extraction", "moduleDir", moduleDir)
+		return moduleDir
+	}
+	ctrl.Log.Info("No single subdirectory detected after extraction", "dirs", subdirs)
+	return workDir
+}
+
+// archiveLimits returns the configured archive extraction limits.
+func (r *ModuleReconciler) archiveLimits() archive.Limits {
+	if r.ArchiveLimits == nil {
+		return archive.DefaultLimits
+	}
+	return *r.ArchiveLimits
+}
+
+// resolveRegistrySource resolves spec.source.version against the registry, records the
+// selected version in status and returns the download location for it.
+func (r *ModuleReconciler) resolveRegistrySource(ctx context.Context, module *astrolabev1.Module) (string, error) {
+	if r.Registry == nil {
+		r.Registry = registry.NewClient(nil)
+	}
+	addr, err := registry.ParseAddress(module.Spec.Source.URL)
+	if err != nil {
+		return "", err
+	}
+	version, err := r.Registry.ResolveVersion(ctx, addr, module.Spec.Source.Version)
+	if err != nil {
+		return "", err
+	}
+	ctrl.Log.Info("Resolved registry module version", "module", addr.String(), "constraint", module.Spec.Source.Version, "version", version)
+	location, err := r.Registry.DownloadLocation(ctx, addr, version)
+	if err != nil {
+		return "", err
+	}
+	module.Status.ResolvedVersion = version
+	return location, nil
+}
+
+// splitGetterSubdir splits a go-getter address into the address and its "//subdir" suffix.
+func splitGetterSubdir(addr string) (string, string) {
+	forced := ""
+	if i := strings.Index(addr, "::"); i >= 0 {
+		forced, addr = addr[:i+2], addr[i+2:]
+	}
+	schemeEnd := 0
+	if i := strings.Index(addr, "://"); i >= 0 {
+		schemeEnd = i + 3
+	}
+	i := strings.Index(addr[schemeEnd:], "//")
+	if i < 0 {
+		return forced + addr, ""
+	}
+	i += schemeEnd
+	subdir := addr[i+2:]
+	query := ""
+	if q := strings.Index(subdir, "?"); q >= 0 {
+		subdir, query = subdir[:q], subdir[q:]
+	}
+	return forced + addr[:i] + query, subdir
+}
+
+// parseGitGetterAddress recognises go-getter git addresses ("git::<url>?ref=<ref>" and
+// the github.com shorthand) and returns the clone URL and ref.
+func parseGitGetterAddress(addr string) (string, string, bool) {
+	switch {
+	case strings.HasPrefix(addr, "git::"):
+		addr = strings.TrimPrefix(addr, "git::")
+	case strings.HasPrefix(addr, "github.com/"):
+		addr = "https://" + addr
+	default:
+		return "", "", false
+	}
+	u, err := neturl.Parse(addr)
+	if err != nil {
+		return addr, "", true
+	}
+	ref := u.Query().Get("ref")
+	q := u.Query()
+	q.Del("ref")
+	u.RawQuery = q.Encode()
+	return u.String(), ref, true
+}
@@ -0,0 +1,326 @@
+package controllers
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	"github.com/junaid18183/astrolabe/internal/archive"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
+)
+
+func TestResolveModulePath(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "repo-1.0", "modules", "vpc"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "README.md"), []byte("readme"), 0644))
+
+	dir, err := resolveModulePath(root, "")
+	assert.NoError(t, err)
+	assert.Equal(t, root, dir)
+
+	dir, err = resolveModulePath(root, "repo-1.0/modules/vpc")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "repo-1.0", "modules", "vpc"), dir)
+
+	dir, err = resolveModulePath(root, "*/modules/vpc")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "repo-1.0", "modules", "vpc"), dir)
+
+	_, err = resolveModulePath(root, "modules/eks")
+	var notFound *errModulePathNotFound
+	assert.ErrorAs(t, err, &notFound)
+
+	_, err = resolveModulePath(root, "*/modules/*")
+	assert.ErrorAs(t, err, &notFound)
+	assert.ErrorContains(t, err, "single path segment")
+
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "repo-2.0", "modules", "vpc"), 0755))
+	_, err = resolveModulePath(root, "repo-*/modules/vpc")
+	assert.ErrorAs(t, err, &notFound)
+	assert.ErrorContains(t, err, "matched 2 directories")
+	require.NoError(t, os.RemoveAll(filepath.Join(root, "repo-2.0")))
+
+	_, err = resolveModulePath(root, "README.md")
+	assert.ErrorAs(t, err, &notFound)
+
+	// Traversal is clamped to the root rather than escaping it
+	_, err = resolveModulePath(root, "../../etc")
+	assert.ErrorAs(t, err, &notFound)
+
+	// ... and so are symlinks, which only pass while they stay inside
+	outside := t.TempDir()
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "repo-1.0", "modules", "escape")))
+	_, err = resolveModulePath(root, "repo-1.0/modules/escape")
+	assert.ErrorAs(t, err, &notFound)
+	assert.ErrorContains(t, err, "resolves outside the module source")
+	_, err = resolveModulePath(root, "*/modules/escape")
+	assert.ErrorContains(t, err, "resolves outside the module source")
+	require.NoError(t, os.Symlink("vpc", filepath.Join(root, "repo-1.0", "modules", "network")))
+	dir, err = resolveModulePath(root, "repo-1.0/modules/network")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "repo-1.0", "modules", "vpc"), dir)
+}
+
+func TestModuleSourceAddress(t *testing.T) {
+	cases := []struct {
+		name string
+		src  astrolabev1.ModuleSource
+		want string
+	}{
+		{
+			name: "git without path",
+			src:  astrolabev1.ModuleSource{Type: "git", URL: "https://example.com/mono.git", Version: "v1.0.0"},
+			want: "git::https://example.com/mono.git?ref=v1.0.0",
+		},
+		{
+			name: "git with path",
+			src:  astrolabev1.ModuleSource{Type: "git", URL: "https://example.com/mono.git", Version: "v1.0.0", Path: "modules/vpc/"},
+			want: "git::https://example.com/mono.git//modules/vpc?ref=v1.0.0",
+		},
+		{
+			name: "http with path",
+			src:  astrolabev1.ModuleSource{Type: "http", URL: "https://example.com/mono.zip", Path: "*/modules/vpc"},
+			want: "https://example.com/mono.zip//*/modules/vpc",
+		},
+		{
+			name: "registry with path",
+			src:  astrolabev1.ModuleSource{Type: "registry", URL: "terraform-aws-modules/iam/aws", Version: "~> 5.0", Path: "modules/iam-role"},
+			want: "terraform-aws-modules/iam/aws//modules/iam-role",
+		},
+		{
+			name: "http with checksum",
+			src:  astrolabev1.ModuleSource{Type: "http", URL: "https://example.com/mono.zip", Checksum: "sha256:" + strings.Repeat("a", 64)},
+			want: "https://example.com/mono.zip?checksum=sha256:" + strings.Repeat("a", 64),
+		},
+		{
+			name: "http with path, query and checksum",
+			src:  astrolabev1.ModuleSource{Type: "http", URL: "https://example.com/mono.zip?token=abc", Path: "modules/vpc", Checksum: "sha256:" + strings.Repeat("a", 64)},
+			want: "https://example.com/mono.zip//modules/vpc?token=abc&checksum=sha256:" + strings.Repeat("a", 64),
+		},
+		{
+			name: "http with path and query",
+			src:  astrolabev1.ModuleSource{Type: "http", URL: "https://example.com/mono.zip?token=abc", Path: "modules/vpc"},
+			want: "https://example.com/mono.zip//modules/vpc?token=abc",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, moduleSourceAddress(astrolabev1.Module{Spec: astrolabev1.ModuleSpec{Source: tc.src}}))
+		})
+	}
+}
+
+func TestSplitGetterSubdir(t *testing.T) {
+	addr, subdir := splitGetterSubdir("git::https://example.com/mono.git//modules/vpc?ref=v1.0.0")
+	assert.Equal(t, "git::https://example.com/mono.git?ref=v1.0.0", addr)
+	assert.Equal(t, "modules/vpc", subdir)
+
+	addr, subdir = splitGetterSubdir("https://example.com/vpc.tar.gz")
+	assert.Equal(t, "https://example.com/vpc.tar.gz", addr)
+	assert.Equal(t, "", subdir)
+}
+
+func TestParseGitGetterAddress(t *testing.T) {
+	url, ref, ok := parseGitGetterAddress("git::https://github.com/terraform-aws-modules/terraform-aws-vpc?ref=v5.1.0")
+	assert.True(t, ok)
+	assert.Equal(t, "https://github.com/terraform-aws-modules/terraform-aws-vpc", url)
+	assert.Equal(t, "v5.1.0", ref)
+
+	url, _, ok = parseGitGetterAddress("github.com/acme/vpc")
+	assert.True(t, ok)
+	assert.Equal(t, "https://github.com/acme/vpc", url)
+
+	_, _, ok = parseGitGetterAddress("https://example.com/vpc.zip")
+	assert.False(t, ok)
+}
+
+func TestModuleSourceAddressPinsOCIDigest(t *testing.T) {
+	mod := astrolabev1.Module{
+		Spec: astrolabev1.ModuleSpec{Source: astrolabev1.ModuleSource{
+			Type: "oci", URL: "registry.example.com/modules/vpc:1.2.0", Path: "modules/endpoints",
+		}},
+		Status: astrolabev1.ModuleStatus{ResolvedDigest: "sha256:abc123"},
+	}
+	// terraform cannot fetch oci:// sources, so Stacks use the vendored copy
+	addr := moduleSourceAddress(mod)
+	assert.Equal(t, "./"+vendoredModulePath(mod), addr)
+	assert.Regexp(t, `^\./\.modules/[0-9a-f]{16}$`, addr)
+
+	moved := mod.DeepCopy()
+	moved.Status.ResolvedDigest = "sha256:def456"
+	assert.NotEqual(t, addr, moduleSourceAddress(*moved), "a new digest is vendored separately")
+	other := mod.DeepCopy()
+	other.Spec.Source.Path = "modules/nat"
+	assert.NotEqual(t, addr, moduleSourceAddress(*other), "so is another path in the same artifact")
+
+	pinned := astrolabev1.Module{Spec: astrolabev1.ModuleSpec{Source: astrolabev1.ModuleSource{
+		Type: "oci", URL: "registry.example.com/modules/vpc", Version: "sha256:abc123", Path: "modules/endpoints",
+	}}}
+	assert.Equal(t, addr, moduleSourceAddress(pinned), "a digest in the spec is used until the Module resolved one")
+}
+
+func TestModuleSourceAddressPinsGitCommit(t *testing.T) {
+	mod := astrolabev1.Module{
+		Spec: astrolabev1.ModuleSpec{Source: astrolabev1.ModuleSource{
+			Type: "git", URL: "https://example.com/mono.git", Version: "main", Path: "modules/vpc",
+		}},
+		Status: astrolabev1.ModuleStatus{ResolvedCommit: "0123456789abcdef0123456789abcdef01234567"},
+	}
+	assert.Equal(t, "git::https://example.com/mono.git//modules/vpc?ref=0123456789abcdef0123456789abcdef01234567", moduleSourceAddress(mod))
+}
+
+func TestModuleSourceAddressPinsVerifiedArchive(t *testing.T) {
+	mod := astrolabev1.Module{
+		Spec: astrolabev1.ModuleSpec{Source: astrolabev1.ModuleSource{
+			Type: "http", URL: "https://example.com/vpc.tar.gz", Verification: &astrolabev1.ModuleVerification{},
+		}},
+		Status: astrolabev1.ModuleStatus{ResolvedChecksum: "sha256:abc123"},
+	}
+	assert.Equal(t, "https://example.com/vpc.tar.gz?checksum=sha256:abc123", moduleSourceAddress(mod))
+}
+
+// writeTestTar writes a module tarball with a single top-level directory, the
+// way GitHub and most release pipelines package modules.
+func writeTestTar(t *testing.T, w io.Writer) {
+	t.Helper()
+	tw := tar.NewWriter(w)
+	files := map[string]string{
+		"vpc-1.0.0/main.tf":             `resource "null_resource" "this" {}`,
+		"vpc-1.0.0/variables.tf":        `variable "name" {}`,
+		"vpc-1.0.0/modules/sub/main.tf": `variable "name" {}`,
+	}
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "pax_global_header", Typeflag: tar.TypeXGlobalHeader, PAXRecords: map[string]string{"comment": "abc"}}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "vpc-1.0.0/", Typeflag: tar.TypeDir, Mode: 0755}))
+	for name, body := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(body))}))
+		_, err := tw.Write([]byte(body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+}
+
+func TestExtractModuleArchiveTarballs(t *testing.T) {
+	compressors := map[string]func(io.Writer) io.WriteCloser{
+		"tar": func(w io.Writer) io.WriteCloser { return nopWriteCloser{w} },
+		"tar.gz": func(w io.Writer) io.WriteCloser {
+			return gzip.NewWriter(w)
+		},
+		"tar.xz": func(w io.Writer) io.WriteCloser {
+			xzw, err := xz.NewWriter(w)
+			require.NoError(t, err)
+			return xzw
+		},
+	}
+	for kind, compress := range compressors {
+		t.Run(kind, func(t *testing.T) {
+			var buf bytes.Buffer
+			cw := compress(&buf)
+			writeTestTar(t, cw)
+			require.NoError(t, cw.Close())
+
+			workDir := t.TempDir()
+			archivePath := filepath.Join(t.TempDir(), "module."+kind)
+			require.NoError(t, os.WriteFile(archivePath, buf.Bytes(), 0644))
+
+			moduleDir, err := extractModuleArchive(archivePath, kind, workDir, archive.DefaultLimits)
+			require.NoError(t, err)
+			assert.Equal(t, filepath.Join(workDir, "vpc-1.0.0"), moduleDir)
+			assert.FileExists(t, filepath.Join(moduleDir, "main.tf"))
+			assert.FileExists(t, filepath.Join(moduleDir, "variables.tf"))
+			assert.FileExists(t, filepath.Join(moduleDir, "modules", "sub", "main.tf"))
+		})
+	}
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestHTTPArchiveKind(t *testing.T) {
+	unknown := filepath.Join(t.TempDir(), "blob")
+	require.NoError(t, os.WriteFile(unknown, []byte("plain text"), 0644))
+	cases := map[string]string{
+		"https://example.com/vpc-1.0.0.tar.gz":                   "tar.gz",
+		"https://example.com/vpc-1.0.0.tgz?X-Amz-Signature=abcd": "tar.gz",
+		"https://example.com/vpc.tar.bz2#frag":                   "tar.bz2",
+		"https://example.com/vpc.tar.xz":                         "tar.xz",
+		"https://example.com/vpc.zip?ref=main":                   "zip",
+		"https://example.com/download?id=42&archive=tar.gz":      "tar.gz",
+	}
+	for url, want := range cases {
+		kind, err := httpArchiveKind(url, unknown)
+		assert.NoError(t, err, url)
+		assert.Equal(t, want, kind, url)
+	}
+	_, err := httpArchiveKind("https://example.com/download?id=42", unknown)
+	assert.Error(t, err)
+
+	// Extension-less URLs fall back to the archive's magic bytes
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	writeTestTar(t, gzw)
+	require.NoError(t, gzw.Close())
+	sniffed := filepath.Join(t.TempDir(), "blob")
+	require.NoError(t, os.WriteFile(sniffed, buf.Bytes(), 0644))
+	kind, err := httpArchiveKind("https://api.example.com/repos/acme/vpc/tarball/v1.0.0", sniffed)
+	assert.NoError(t, err)
+	assert.Equal(t, "tar.gz", kind)
+}
+
+func TestFetchHTTPSourceWithQueryString(t *testing.T) {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	writeTestTar(t, gzw)
+	require.NoError(t, gzw.Close())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("token") != "abc" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write(buf.Bytes())
+	}))
+	defer srv.Close()
+
+	workDir := t.TempDir()
+	moduleDir, err := fetchHTTPSource(workDir, srv.URL+"/vpc-1.0.0.tar.gz?token=abc", archive.DefaultLimits, nil)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(workDir, "vpc-1.0.0"), moduleDir)
+	assert.FileExists(t, filepath.Join(moduleDir, "variables.tf"))
+}
+
+func TestFetchHTTPSourceLimitsDownloadSize(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(bytes.Repeat([]byte("x"), 2048))
+	}))
+	defer srv.Close()
+
+	workDir := t.TempDir()
+	_, err := fetchHTTPSource(workDir, srv.URL+"/vpc.tar.gz", archive.Limits{MaxTotalSize: 1024}, nil)
+	var violation *archive.ViolationError
+	require.ErrorAs(t, err, &violation)
+	assert.Contains(t, violation.Reason, "larger than 1024 bytes")
+	entries, err := os.ReadDir(workDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "the partial download is removed")
+}
+
+func TestLimitedWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &limitedWriter{w: &buf, limit: 4}
+	_, err := w.Write([]byte("abc"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("de"))
+	var violation *archive.ViolationError
+	assert.ErrorAs(t, err, &violation)
+	assert.Equal(t, "abc", buf.String())
+}
@@ -0,0 +1,251 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// verificationPublicKeyKey is the Secret key holding the armored OpenPGP keyring
+// referenced by spec.source.verification.secretRef.
+const verificationPublicKeyKey = "public-key"
+
+// pgpSignatureHeader starts the signature git appends to a signed tag object.
+const pgpSignatureHeader = "-----BEGIN PGP SIGNATURE-----"
+
+// errVerificationFailed reports a source that did not match its checksum or signature.
+type errVerificationFailed struct {
+	Reason string
+}
+
+func (e *errVerificationFailed) Error() string {
+	return "source verification failed: " + e.Reason
+}
+
+// sourceVerifier checks a fetched Module source against spec.source.checksum and
+// spec.source.verification. A nil verifier accepts everything.
+type sourceVerifier struct {
+	Checksum     string
+	Keyring      openpgp.EntityList
+	SignatureURL string
+	// ArchiveChecksum is the digest of the last archive that passed
+	// verifyArchive, so Stacks can be pinned to it.
+	ArchiveChecksum string
+}
+
+// moduleVerificationEnabled reports whether a Module source must be verified.
+func moduleVerificationEnabled(src astrolabev1.ModuleSource) bool {
+	return src.Checksum != "" || src.Verification != nil
+}
+
+// moduleUnverifiedReason explains why a Module that requires verification may
+// not be deployed yet. It is empty when the Module is verified or does not
+// require verification.
+func moduleUnverifiedReason(mod astrolabev1.Module) string {
+	if !moduleVerificationEnabled(mod.Spec.Source) {
+		return ""
+	}
+	if mod.Status.ObservedGeneration != mod.Generation {
+		return fmt.Sprintf("Module %s source has not been verified yet", mod.Name)
+	}
+	for _, cond := range mod.Status.Conditions {
+		if cond.Type != "Verified" {
+			continue
+		}
+		if cond.Status == "True" {
+			return ""
+		}
+		return fmt.Sprintf("Module %s failed source verification: %s", mod.Name, cond.Message)
+	}
+	return fmt.Sprintf("Module %s source has not been verified yet", mod.Name)
+}
+
+// loadSourceVerifier builds the verifier for src, reading the public keys from
+// the referenced Secret in namespace. It returns nil when verification is off.
+func loadSourceVerifier(ctx context.Context, c client.Reader, namespace string, src astrolabev1.ModuleSource) (*sourceVerifier, error) {
+	if !moduleVerificationEnabled(src) {
+		return nil, nil
+	}
+	v := &sourceVerifier{Checksum: src.Checksum}
+	if src.Verification == nil {
+		return v, nil
+	}
+	name := src.Verification.SecretRef.Name
+	var secret corev1.Secret
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &secret); err != nil {
+		return nil, fmt.Errorf("failed to get verification secret %q: %w", name, err)
+	}
+	armored := secret.Data[verificationPublicKeyKey]
+	if len(armored) == 0 {
+		return nil, fmt.Errorf("verification secret %q has no %s", name, verificationPublicKeyKey)
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armored))
+	if err != nil {
+		return nil, fmt.Errorf("verification secret %q has an invalid %s: %w", name, verificationPublicKeyKey, err)
+	}
+	v.Keyring = keyring
+	v.SignatureURL = src.Verification.SignatureURL
+	return v, nil
+}
+
+// describe summarizes what a successful verification checked.
+func (v *sourceVerifier) describe() string {
+	switch {
+	case v.Checksum != "" && v.Keyring != nil:
+		return "Module source matched its checksum and signature"
+	case v.Checksum != "":
+		return "Module source matched its checksum"
+	default:
+		return "Module source signature is valid"
+	}
+}
+
+// verifyArchive checks a downloaded archive, fetched from archiveURL, before it is extracted.
+func (v *sourceVerifier) verifyArchive(archivePath, archiveURL string) error {
+	if v == nil {
+		return nil
+	}
+	v.ArchiveChecksum = ""
+	if v.Checksum != "" {
+		if err := verifyChecksum(archivePath, v.Checksum); err != nil {
+			return err
+		}
+	}
+	if v.Keyring != nil {
+		if err := v.verifyArchiveSignature(archivePath, archiveURL); err != nil {
+			return err
+		}
+	}
+	v.ArchiveChecksum = v.Checksum
+	if v.ArchiveChecksum == "" {
+		sum, err := fileChecksum(archivePath, "sha256")
+		if err != nil {
+			return err
+		}
+		v.ArchiveChecksum = sum
+	}
+	return nil
+}
+
+// verifyArchiveSignature checks the detached signature of the archive at
+// archivePath against the trusted keys.
+func (v *sourceVerifier) verifyArchiveSignature(archivePath, archiveURL string) error {
+	sigURL := v.SignatureURL
+	if sigURL == "" {
+		u, err := url.Parse(archiveURL)
+		if err != nil {
+			return err
+		}
+		u.Path += ".sig"
+		u.RawPath = ""
+		sigURL = u.String()
+	}
+	resp, err := sourceHTTPClient.Get(sigURL)
+	if err != nil {
+		return fmt.Errorf("failed to download signature: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &errVerificationFailed{Reason: fmt.Sprintf("failed to download signature %s: %s", sigURL, resp.Status)}
+	}
+	// Signatures are tiny; anything larger is not one
+	sig, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to download signature: %w", err)
+	}
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return v.checkSignature(f, sig)
+}
+
+// verifyGitTag checks that tag in the cloned repository is an annotated tag
+// signed by one of the trusted keys.
+func (v *sourceVerifier) verifyGitTag(repoDir, tag string) error {
+	if v == nil {
+		return nil
+	}
+	if v.Checksum != "" {
+		return &errVerificationFailed{Reason: "checksum is only supported for archive sources; use verification for git tags"}
+	}
+	if tag == "" {
+		return &errVerificationFailed{Reason: "signature verification requires version to name a signed tag"}
+	}
+	out, err := exec.Command("git", "-C", repoDir, "cat-file", "tag", "refs/tags/"+tag).Output()
+	if err != nil {
+		return &errVerificationFailed{Reason: fmt.Sprintf("%q is not an annotated tag", tag)}
+	}
+	idx := bytes.Index(out, []byte(pgpSignatureHeader))
+	if idx < 0 {
+		return &errVerificationFailed{Reason: fmt.Sprintf("tag %q is not signed", tag)}
+	}
+	// git signs the tag object up to, but excluding, the appended signature
+	return v.checkSignature(bytes.NewReader(out[:idx]), out[idx:])
+}
+
+// checkSignature verifies an armored or binary detached signature over signed.
+func (v *sourceVerifier) checkSignature(signed io.Reader, sig []byte) error {
+	var err error
+	if bytes.HasPrefix(bytes.TrimSpace(sig), []byte("-----BEGIN")) {
+		_, err = openpgp.CheckArmoredDetachedSignature(v.Keyring, signed, bytes.NewReader(sig), nil)
+	} else {
+		_, err = openpgp.CheckDetachedSignature(v.Keyring, signed, bytes.NewReader(sig), nil)
+	}
+	if err != nil {
+		return &errVerificationFailed{Reason: "invalid signature: " + err.Error()}
+	}
+	return nil
+}
+
+// verifyChecksum compares the digest of the file at path with an
+// "<algorithm>:<hex>" checksum.
+func verifyChecksum(path, checksum string) error {
+	algo, want, _ := strings.Cut(checksum, ":")
+	got, err := fileChecksum(path, algo)
+	if err != nil {
+		return err
+	}
+	if _, sum, _ := strings.Cut(got, ":"); !strings.EqualFold(sum, want) {
+		return &errVerificationFailed{Reason: fmt.Sprintf("checksum mismatch: expected %s, got %s", checksum, got)}
+	}
+	return nil
+}
+
+// fileChecksum returns the "<algorithm>:<hex>" digest of the file at path.
+func fileChecksum(path, algo string) (string, error) {
+	var h hash.Hash
+	switch algo {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return "", &errVerificationFailed{Reason: fmt.Sprintf("unsupported checksum algorithm %q", algo)}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return algo + ":" + hex.EncodeToString(h.Sum(nil)), nil
+}
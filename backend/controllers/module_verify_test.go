@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var testPGPConfig = &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA}
+
+func newTestSigner(t *testing.T) (*openpgp.Entity, []byte) {
+	t.Helper()
+	entity, err := openpgp.NewEntity("Release Bot", "", "release@example.com", testPGPConfig)
+	require.NoError(t, err)
+	var pub bytes.Buffer
+	w, err := armor.Encode(&pub, openpgp.PublicKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.Serialize(w))
+	require.NoError(t, w.Close())
+	return entity, pub.Bytes()
+}
+
+func armoredSignature(t *testing.T, signer *openpgp.Entity, data []byte) []byte {
+	t.Helper()
+	var sig bytes.Buffer
+	require.NoError(t, openpgp.ArmoredDetachSign(&sig, signer, bytes.NewReader(data), testPGPConfig))
+	return append(sig.Bytes(), '\n')
+}
+
+func assertVerificationFailed(t *testing.T, err error, reason string) {
+	t.Helper()
+	var unverified *errVerificationFailed
+	require.True(t, errors.As(err, &unverified), "expected a verification failure, got %v", err)
+	assert.Contains(t, unverified.Reason, reason)
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "module.tar.gz")
+	data := []byte("module archive")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	sum256 := sha256.Sum256(data)
+	sum512 := sha512.Sum512(data)
+
+	assert.NoError(t, verifyChecksum(path, "sha256:"+hex.EncodeToString(sum256[:])))
+	assert.NoError(t, verifyChecksum(path, "sha512:"+strings.ToUpper(hex.EncodeToString(sum512[:]))))
+	assertVerificationFailed(t, verifyChecksum(path, "sha256:"+strings.Repeat("0", 64)), "checksum mismatch")
+	assertVerificationFailed(t, verifyChecksum(path, "md5:abc"), "unsupported")
+}
+
+func TestVerifyArchiveSignature(t *testing.T) {
+	signer, _ := newTestSigner(t)
+	other, _ := newTestSigner(t)
+	data := []byte("module archive")
+	sigs := map[string][]byte{
+		"/good.tar.gz.sig":   armoredSignature(t, signer, data),
+		"/forged.tar.gz.sig": armoredSignature(t, other, data),
+		"/custom.sig":        armoredSignature(t, signer, data),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sig, ok := sigs[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(sig)
+	}))
+	defer srv.Close()
+	archivePath := filepath.Join(t.TempDir(), "archive")
+	require.NoError(t, os.WriteFile(archivePath, data, 0644))
+
+	v := &sourceVerifier{Keyring: openpgp.EntityList{signer}}
+	assert.NoError(t, v.verifyArchive(archivePath, srv.URL+"/good.tar.gz?token=abc"))
+	sum := sha256.Sum256(data)
+	assert.Equal(t, "sha256:"+hex.EncodeToString(sum[:]), v.ArchiveChecksum, "the verified archive is recorded for Stacks")
+	assertVerificationFailed(t, v.verifyArchive(archivePath, srv.URL+"/forged.tar.gz"), "invalid signature")
+	assert.Empty(t, v.ArchiveChecksum)
+	assertVerificationFailed(t, v.verifyArchive(archivePath, srv.URL+"/unsigned.tar.gz"), "404")
+
+	v.SignatureURL = srv.URL + "/custom.sig"
+	assert.NoError(t, v.verifyArchive(archivePath, srv.URL+"/unsigned.tar.gz"))
+
+	var nilVerifier *sourceVerifier
+	assert.NoError(t, nilVerifier.verifyArchive(archivePath, srv.URL+"/unsigned.tar.gz"))
+}
+
+func TestVerifyGitTag(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	signer, _ := newTestSigner(t)
+	other, _ := newTestSigner(t)
+	repo := t.TempDir()
+	gitCmd(t, repo, "init", "-q", "-b", "main")
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "main.tf"), []byte(`variable "a" {}`), 0644))
+	gitCmd(t, repo, "add", ".")
+	gitCmd(t, repo, "commit", "-q", "-m", "first")
+	commit := gitCmd(t, repo, "rev-parse", "HEAD")
+	gitCmd(t, repo, "tag", "-a", "unsigned", "-m", "release")
+
+	// Build signed tag objects the way "git tag -s" does, without needing gpg
+	signTag := func(name string, key *openpgp.Entity) {
+		payload := "object " + commit + "\ntype commit\ntag " + name + "\ntagger test <test@example.com> 0 +0000\n\nrelease\n"
+		tagObj := payload + string(armoredSignature(t, key, []byte(payload)))
+		cmd := exec.Command("git", "mktag")
+		cmd.Dir = repo
+		cmd.Stdin = strings.NewReader(tagObj)
+		out, err := cmd.Output()
+		require.NoError(t, err)
+		gitCmd(t, repo, "update-ref", "refs/tags/"+name, strings.TrimSpace(string(out)))
+	}
+	signTag("v1.0.0", signer)
+	signTag("v1.0.1", other)
+
+	v := &sourceVerifier{Keyring: openpgp.EntityList{signer}}
+	assert.NoError(t, v.verifyGitTag(repo, "v1.0.0"))
+	assertVerificationFailed(t, v.verifyGitTag(repo, "v1.0.1"), "invalid signature")
+	assertVerificationFailed(t, v.verifyGitTag(repo, "unsigned"), "not signed")
+	assertVerificationFailed(t, v.verifyGitTag(repo, "main"), "not an annotated tag")
+	assertVerificationFailed(t, v.verifyGitTag(repo, ""), "signed tag")
+
+	v.Checksum = "sha256:" + strings.Repeat("0", 64)
+	assertVerificationFailed(t, v.verifyGitTag(repo, "v1.0.0"), "archive sources")
+}
+
+func TestLoadSourceVerifier(t *testing.T) {
+	_, pub := newTestSigner(t)
+	c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "release-keys", Namespace: "default"},
+			Data:       map[string][]byte{"public-key": pub},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "garbage", Namespace: "default"},
+			Data:       map[string][]byte{"public-key": []byte("not a key")},
+		},
+	).Build()
+	ctx := context.Background()
+	src := astrolabev1.ModuleSource{Type: "http", URL: "https://example.com/vpc.tar.gz"}
+
+	v, err := loadSourceVerifier(ctx, c, "default", src)
+	require.NoError(t, err)
+	assert.Nil(t, v)
+
+	src.Verification = &astrolabev1.ModuleVerification{SecretRef: astrolabev1.ModuleSecretRef{Name: "release-keys"}}
+	v, err = loadSourceVerifier(ctx, c, "default", src)
+	require.NoError(t, err)
+	assert.Len(t, v.Keyring, 1)
+
+	src.Verification.SecretRef.Name = "garbage"
+	_, err = loadSourceVerifier(ctx, c, "default", src)
+	assert.ErrorContains(t, err, "invalid public-key")
+
+	src.Verification.SecretRef.Name = "missing"
+	_, err = loadSourceVerifier(ctx, c, "default", src)
+	assert.Error(t, err)
+}
+
+func TestModuleUnverifiedReason(t *testing.T) {
+	mod := astrolabev1.Module{ObjectMeta: metav1.ObjectMeta{Name: "vpc", Generation: 2}}
+	assert.Empty(t, moduleUnverifiedReason(mod))
+
+	mod.Spec.Source.Checksum = "sha256:" + strings.Repeat("0", 64)
+	mod.Status.ObservedGeneration = 2
+	assert.Contains(t, moduleUnverifiedReason(mod), "not been verified")
+
+	mod.Status.Conditions = []astrolabev1.ModuleCondition{{Type: "Verified", Status: "False", Message: "checksum mismatch"}}
+	assert.Contains(t, moduleUnverifiedReason(mod), "checksum mismatch")
+
+	mod.Status.Conditions[0].Status = "True"
+	assert.Empty(t, moduleUnverifiedReason(mod))
+
+	// A spec change since the last verification must be re-verified first
+	mod.Generation = 3
+	assert.Contains(t, moduleUnverifiedReason(mod), "not been verified")
+}
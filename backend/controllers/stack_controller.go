@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"math"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
@@ -16,13 +15,20 @@ import (
 	"k8s.io/client-go/tools/record"
 
 	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	"github.com/junaid18183/astrolabe/internal/archive"
+	"github.com/junaid18183/astrolabe/internal/oci"
+	"github.com/junaid18183/astrolabe/internal/workspace"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 )
 
 // StackReconciler reconciles a Stack object
@@ -30,13 +36,34 @@ type StackReconciler struct {
 	client.Client
 	Scheme   *runtime.Scheme
 	Recorder record.EventRecorder
+	// RenderFormat is the syntax used for Stacks that do not set
+	// spec.renderFormat; astrolabev1.RenderFormatHCL when empty
+	RenderFormat string
+	// Runner runs terraform; a LocalRunner when nil
+	Runner TerraformRunner
+	// Workspaces holds the working directories of Stacks; below
+	// defaultWorkspaceRoot when nil
+	Workspaces *workspace.Store
+	// APIReader reads objects bypassing the cache; the Client when nil
+	APIReader client.Reader
+	// OCI pulls oci Modules into Stack workspaces; a default client is used when nil
+	OCI *oci.Client
+	// ArchiveLimits bounds the extraction of oci Modules; archive.DefaultLimits is used when nil
+	ArchiveLimits *archive.Limits
 }
 
+// defaultWorkspaceRoot does not survive manager restarts; see --workspace-dir.
+const defaultWorkspaceRoot = "/tmp/astrolabe"
+
 // +kubebuilder:rbac:groups=astrolabe.io,resources=stacks,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=astrolabe.io,resources=stacks/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=astrolabe.io,resources=stacks/finalizers,verbs=update
 // +kubebuilder:rbac:groups=astrolabe.io,resources=backendconfigs;credentials;modules,verbs=get;list;watch
-// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list
+// +kubebuilder:rbac:groups=core,resources=pods/log,verbs=get
+// +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;create;update;delete
 
 func (r *StackReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	ctrl.Log.Info("Reconciling Stack", "name", req.NamespacedName)
@@ -58,10 +85,15 @@ func (r *StackReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 		return r.handleDelete(ctx, &stack)
 	}
 
-	// Early return if Stack is already in terminal state
-	if stack.Status.Phase == "Ready" || stack.Status.Status == "Success" {
-		ctrl.Log.Info("Stack is already in terminal state, skipping reconciliation", "name", stack.Name, "phase", stack.Status.Phase, "status", stack.Status.Status)
-		return ctrl.Result{}, nil
+	// Early return if Stack is already in terminal state, unless an upstream Stack output it consumes changed
+	// or a run is still in flight
+	if (stack.Status.Phase == "Ready" || stack.Status.Status == "Success") && currentStackRun(&stack) == nil {
+		if !r.stackInputsChanged(ctx, &stack) {
+			ctrl.Log.Info("Stack is already in terminal state, skipping reconciliation", "name", stack.Name, "phase", stack.Status.Phase, "status", stack.Status.Status)
+			return ctrl.Result{}, nil
+		}
+		ctrl.Log.Info("Upstream stack outputs changed, planning again", "name", stack.Name)
+		r.emitStackEvent(&stack, corev1.EventTypeNormal, "UpstreamOutputsChanged", "Outputs of an upstream Stack changed")
 	}
 
 	// Add finalizer if not present
@@ -91,9 +123,6 @@ func (r *StackReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 		moduleRefs[i] = m.Name
 	}
 
-	// Use backend config directly from StackSpec
-	backend := stack.Spec.BackendConfig
-
 	var credSecret corev1.Secret
 	if credentialRef != "" {
 		if err := r.Get(ctx, client.ObjectKey{Namespace: stack.Namespace, Name: credentialRef}, &credSecret); err != nil {
@@ -111,37 +140,95 @@ func (r *StackReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 			r.setStackError(ctx, &stack, "MissingModule", err.Error())
 			return ctrl.Result{Requeue: true}, nil
 		}
+		if reason := moduleUnverifiedReason(mod); reason != "" {
+			ctrl.Log.Info("Module source not verified", "module", ref, "reason", reason)
+			r.setStackError(ctx, &stack, "ModuleUnverified", reason)
+			return ctrl.Result{Requeue: true}, nil
+		}
 		if mod.Status.Inputs == nil {
 			ctrl.Log.Info("Module status.inputs missing", "module", ref)
 			r.setStackError(ctx, &stack, "ModuleUnpopulated", "Module status.inputs missing")
 			return ctrl.Result{Requeue: true}, nil
 		}
-		stackMod := stack.Spec.Modules[i]
-		missingVars := []string{}
-		var variables map[string]interface{}
-		if stackMod.Variables.Raw != nil {
-			_ = json.Unmarshal(stackMod.Variables.Raw, &variables)
-		} else {
-			variables = map[string]interface{}{}
-		}
-		for _, input := range mod.Status.Inputs {
-			if _, ok := variables[input.Name]; !ok && input.Required {
-				missingVars = append(missingVars, input.Name)
-			}
-		}
-		if len(missingVars) > 0 {
-			ctrl.Log.Info("Missing required variables for module", "module", mod.Name, "missing", missingVars)
-			r.setStackError(ctx, &stack, "MissingVariables", fmt.Sprintf("Module %s missing variables: %v", mod.Name, missingVars))
-			return ctrl.Result{Requeue: true}, nil
-		}
 		modules[i] = mod
 	}
 
-	workDir := filepath.Join("/tmp", "astrolabe", stack.Namespace, stack.Name)
-	os.MkdirAll(workDir, 0700)
-	writeFile(filepath.Join(workDir, "backend.tf"), renderBackendTf(backend, stack.Name))
-	writeFile(filepath.Join(workDir, "main.tf"), renderMainTf(stack, modules))
-	writeFile(filepath.Join(workDir, "outputs.tf"), renderOutputsTf(modules))
+	// Wait for the upstream Stacks whose outputs feed stackInputs
+	upstreamValues, upstreamHash, err := r.resolveStackInputs(ctx, &stack)
+	if err != nil {
+		reason := "UpstreamOutputsUnresolved"
+		var refErr *errStackReference
+		if errors.As(err, &refErr) {
+			reason = refErr.Reason
+		}
+		ctrl.Log.Info("Upstream stack outputs unresolved", "name", stack.Name, "reason", reason, "error", err)
+		apimeta.SetStatusCondition(&stack.Status.Conditions, metav1.Condition{
+			Type:               "UpstreamOutputsResolved",
+			Status:             metav1.ConditionFalse,
+			Reason:             reason,
+			Message:            err.Error(),
+			ObservedGeneration: stack.Generation,
+		})
+		r.setStackError(ctx, &stack, reason, err.Error())
+		// Upstream changes requeue the Stack too; this covers Stacks created later
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	if hasStackInputs(&stack) {
+		apimeta.SetStatusCondition(&stack.Status.Conditions, metav1.Condition{
+			Type:               "UpstreamOutputsResolved",
+			Status:             metav1.ConditionTrue,
+			Reason:             "Resolved",
+			Message:            "All upstream Stack outputs are available",
+			ObservedGeneration: stack.Generation,
+		})
+	}
+	// effective carries the upstream values in the module variables; it is only rendered, never stored
+	effective := stack.DeepCopy()
+	errs := injectStackInputs(effective, upstreamValues)
+
+	// Report every variable that is unknown, missing or of the wrong type at once
+	if errs = append(errs, validateStackVariables(effective, modules)...); len(errs) > 0 {
+		msg := errs.ToAggregate().Error()
+		ctrl.Log.Info("Invalid stack variables", "name", stack.Name, "errors", msg)
+		apimeta.SetStatusCondition(&stack.Status.Conditions, metav1.Condition{
+			Type:               "VariablesValid",
+			Status:             metav1.ConditionFalse,
+			Reason:             "VariablesInvalid",
+			Message:            msg,
+			ObservedGeneration: stack.Generation,
+		})
+		r.setStackError(ctx, &stack, "VariablesInvalid", msg)
+		return ctrl.Result{Requeue: true}, nil
+	}
+	apimeta.SetStatusCondition(&stack.Status.Conditions, metav1.Condition{
+		Type:               "VariablesValid",
+		Status:             metav1.ConditionTrue,
+		Reason:             "VariablesValid",
+		Message:            "All variables match their module input types",
+		ObservedGeneration: stack.Generation,
+	})
+
+	// Only one operation may use the Stack's workspace at a time
+	ws, err := r.workspaces().Acquire(stack.Namespace, stack.Name, string(stack.UID), "reconcile")
+	if err != nil {
+		return r.workspaceUnavailable(ctx, &stack, err)
+	}
+	defer ws.Release()
+	workDir := ws.Dir
+	format := stack.Spec.RenderFormat
+	if format == "" {
+		format = r.RenderFormat
+	}
+	if err := r.vendorOCIModules(ctx, workDir, modules); err != nil {
+		ctrl.Log.Info("Failed to pull oci modules", "name", stack.Name, "error", err)
+		r.setStackError(ctx, &stack, "ModuleFetchFailed", err.Error())
+		return ctrl.Result{Requeue: true}, nil
+	}
+	if err := writeStackConfig(workDir, format, *effective, modules); err != nil {
+		ctrl.Log.Info("Failed to render terraform configuration", "name", stack.Name, "format", format, "error", err)
+		r.setStackError(ctx, &stack, "RenderFailed", err.Error())
+		return ctrl.Result{Requeue: true}, nil
+	}
 
 	envVars := []string{}
 	if credentialRef != "" {
@@ -150,23 +237,134 @@ func (r *StackReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 		}
 	}
 
-	steps := []string{"init", "plan", "apply"}
-	for _, step := range steps {
-		phase := strings.Title(step)
-		ctrl.Log.Info("Running terraform step", "step", step, "workDir", workDir)
-		r.setStackPhase(ctx, &stack, phase)
-		out, err := runTerraformStep(workDir, step, envVars)
-		r.appendStackLog(ctx, &stack, step, out)
+	// terraform init re-fetches git modules, so it needs the same credentials the Module controller used
+	gitAuths := []*gitAuth{}
+	for _, mod := range modules {
+		if mod.Spec.Source.Type != "git" {
+			continue
+		}
+		auth, err := loadGitAuth(ctx, r.Client, mod.Namespace, mod.Spec.Source)
 		if err != nil {
-			ctrl.Log.Info("Terraform step failed", "step", step, "error", err)
-			r.setStackError(ctx, &stack, "Terraform"+phase+"Error", err.Error())
+			ctrl.Log.Info("Invalid module git credentials", "module", mod.Name, "error", err)
+			r.setStackError(ctx, &stack, "ModuleCredentialsInvalid", err.Error())
 			return ctrl.Result{Requeue: true}, nil
 		}
+		gitAuths = append(gitAuths, auth)
+	}
+	gitAuthDir := gitAuthDirFor(workDir)
+	defer os.RemoveAll(gitAuthDir)
+	gitEnv, err := gitAuthEnv(gitAuths, gitAuthDir)
+	if err != nil {
+		r.setStackError(ctx, &stack, "ModuleCredentialsInvalid", err.Error())
+		return ctrl.Result{Requeue: true}, nil
 	}
+	envVars = append(envVars, gitEnv...)
+	envVars = append(envVars, r.managedBackendEnv(&stack)...)
+	redactor := newLogRedactor(stackSecretValues(credSecret.Data, gitAuths, effective, modules))
 
-	outputs, resources, err := parseTerraformState(workDir)
+	configHash, err := stackConfigHash(workDir, format)
+	if err != nil {
+		r.setStackError(ctx, &stack, "RenderFailed", err.Error())
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// With manual approval the saved plan is kept while the configuration is
+	// unchanged, so the approval names exactly the plan that is applied
+	manual := stack.Spec.Approval == astrolabev1.ApprovalManual
+	planFile := filepath.Join(workDir, planFileName)
+	_, statErr := os.Stat(planFile)
+	savedPlan := manual && statErr == nil && stack.Status.Plan != nil && stack.Status.Plan.ConfigHash == configHash
+	if savedPlan {
+		if approved, reason, msg := planApproval(&stack); !approved {
+			return r.awaitPlanApproval(ctx, &stack, reason, msg)
+		}
+	}
+
+	runID, wait := r.beginStackRun(ctx, &stack, false, runTrigger(&stack, upstreamHash))
+	if wait {
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+	if savedPlan {
+		recordRunPlan(&stack)
+	}
+	if err := r.runStackStep(ctx, &stack, runID, workDir, "init", envVars, redactor); err != nil {
+		return stepResult(err), nil
+	}
+	if !savedPlan {
+		if err := r.runStackStep(ctx, &stack, runID, workDir, "plan", envVars, redactor); err != nil {
+			return stepResult(err), nil
+		}
+		plan, err := readStackPlan(ctx, r.runner(), &stack, runID, workDir, envVars)
+		if err != nil {
+			err = errors.New(redactor.Redact(err.Error()))
+			ctrl.Log.Info("Failed to read terraform plan", "name", stack.Name, "error", err)
+			finishStackRun(&stack, runFailed, "plan", err.Error())
+			r.setStackError(ctx, &stack, "TerraformPlanError", err.Error())
+			return ctrl.Result{Requeue: true}, nil
+		}
+		plan.ConfigHash = configHash
+		stack.Status.Plan = plan
+		recordRunPlan(&stack)
+	}
+
+	if !manual {
+		apimeta.RemoveStatusCondition(&stack.Status.Conditions, "PlanApproved")
+	} else {
+		approved, reason, msg := planApproval(&stack)
+		if !approved {
+			return r.awaitPlanApproval(ctx, &stack, reason, msg)
+		}
+		// Refuse to apply a plan file that no longer holds the approved changes
+		saved, err := readStackPlan(ctx, r.runner(), &stack, runID, workDir, envVars)
+		if err != nil || saved.Hash != stack.Status.Plan.Hash {
+			os.Remove(planFile)
+			msg := fmt.Sprintf("Saved plan no longer matches approved plan %s, planning again", stack.Status.Plan.Hash)
+			if err != nil {
+				msg = fmt.Sprintf("Failed to read saved plan %s: %v", stack.Status.Plan.Hash, err)
+			}
+			apimeta.SetStatusCondition(&stack.Status.Conditions, metav1.Condition{
+				Type:               "PlanApproved",
+				Status:             metav1.ConditionFalse,
+				Reason:             "PlanChanged",
+				Message:            msg,
+				ObservedGeneration: stack.Generation,
+			})
+			finishStackRun(&stack, runFailed, "apply", msg)
+			r.setStackError(ctx, &stack, "PlanChanged", msg)
+			return ctrl.Result{Requeue: true}, nil
+		}
+		apimeta.SetStatusCondition(&stack.Status.Conditions, metav1.Condition{
+			Type:               "PlanApproved",
+			Status:             metav1.ConditionTrue,
+			Reason:             reason,
+			Message:            msg,
+			ObservedGeneration: stack.Generation,
+		})
+	}
+
+	applyErr := r.runStackStep(ctx, &stack, runID, workDir, "apply", envVars, redactor)
+	if errors.Is(applyErr, errStepPending) {
+		return stepResult(applyErr), nil
+	}
+	// A saved plan is applied at most once; Terraform refuses it once the state moved on
+	os.Remove(planFile)
+	// So is its approval: a later plan that happens to hash the same needs a new one
+	if err := r.clearPlanApproval(ctx, &stack); err != nil {
+		ctrl.Log.Info("Failed to clear plan approval", "name", stack.Name, "error", err)
+	}
+	if applyErr != nil {
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	var outputs map[string]interface{}
+	var resources []string
+	state, err := r.readStackState(ctx, &stack, workDir)
+	if err == nil {
+		outputs, resources, err = parseTerraformState(state)
+	}
 	if err != nil {
 		ctrl.Log.Info("Failed to parse terraform state", "error", err)
+		finishStackRun(&stack, runFailed, "outputs", err.Error())
 		r.setStackError(ctx, &stack, "TerraformStateParseError", err.Error())
 		return ctrl.Result{Requeue: true}, nil
 	}
@@ -205,8 +403,13 @@ func (r *StackReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 		}
 	}
 
+	// Record which upstream outputs this apply consumed
+	upstreamChanged := stack.Status.UpstreamOutputsHash != upstreamHash
+	stack.Status.UpstreamOutputsHash = upstreamHash
+	finishStackRun(&stack, runSucceeded, "", "Stack successfully applied and outputs/resources updated.")
+
 	// Set phase to 'Applied' and mark Ready true only if not already
-	if stack.Status.Phase != "Applied" || stack.Status.Status != "Success" || !stack.Status.Ready || stack.Status.Summary != "Stack successfully applied and outputs/resources updated." {
+	if upstreamChanged || stack.Status.Phase != "Applied" || stack.Status.Status != "Success" || !stack.Status.Ready || stack.Status.Summary != "Stack successfully applied and outputs/resources updated." {
 		r.setStackPhase(ctx, &stack, "Applied")
 		stack.Status.Phase = "Applied"
 		stack.Status.Status = "Success"
@@ -261,12 +464,7 @@ func (r *StackReconciler) updateStatusWithRetry(ctx context.Context, stack *astr
 	}
 }
 
-func parseTerraformState(workDir string) (map[string]interface{}, []string, error) {
-	statePath := filepath.Join(workDir, "terraform.tfstate")
-	data, err := os.ReadFile(statePath)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read state: %w", err)
-	}
+func parseTerraformState(data []byte) (map[string]interface{}, []string, error) {
 	var state struct {
 		Outputs   map[string]struct{ Value interface{} } `json:"outputs"`
 		Resources []struct {
@@ -293,31 +491,66 @@ func parseTerraformState(workDir string) (map[string]interface{}, []string, erro
 
 func (r *StackReconciler) handleDelete(ctx context.Context, stack *astrolabev1.Stack) (ctrl.Result, error) {
 	finalizerName := "stack.finalizers.astrolabe.io"
-	workDir := filepath.Join("/tmp", "astrolabe", stack.Namespace, stack.Name)
+	ws, err := r.workspaces().Acquire(stack.Namespace, stack.Name, string(stack.UID), "destroy")
+	if err != nil {
+		return r.workspaceUnavailable(ctx, stack, err)
+	}
+	defer ws.Release()
+	workDir := ws.Dir
 	ctrl.Log.Info("handleDelete called", "name", stack.Name, "deletionTimestamp", stack.ObjectMeta.DeletionTimestamp, "finalizers", stack.ObjectMeta.Finalizers)
-	r.setStackPhase(ctx, stack, "Destroying")
-	r.emitStackEvent(stack, corev1.EventTypeNormal, "DestroyStarted", "Starting terraform destroy")
+	runID, wait := r.beginStackRun(ctx, stack, true, "Deleted")
+	if wait {
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+	if stack.Status.Phase != "Destroying" {
+		r.setStackPhase(ctx, stack, "Destroying")
+		r.emitStackEvent(stack, corev1.EventTypeNormal, "DestroyStarted", "Starting terraform destroy")
+	}
 	// Attempt terraform destroy
 	envVars := []string{}
+	var credSecret corev1.Secret
 	if stack.Spec.CredentialRef != nil {
-		var credSecret corev1.Secret
 		if err := r.Get(ctx, client.ObjectKey{Namespace: stack.Namespace, Name: stack.Spec.CredentialRef.Name}, &credSecret); err == nil {
 			for k, v := range credSecret.Data {
 				envVars = append(envVars, fmt.Sprintf("%s=%s", k, string(v)))
 			}
 		}
 	}
-	ctrl.Log.Info("Running terraform destroy", "workDir", workDir, "envVars", envVars)
-	out, err := runTerraformStep(workDir, "destroy", envVars)
+	envVars = append(envVars, r.managedBackendEnv(stack)...)
+	redactor := newLogRedactor(stackSecretValues(credSecret.Data, nil, stack, nil))
+	ctrl.Log.Info("Running terraform destroy", "workDir", workDir, "credentials", len(credSecret.Data))
+	out, err := r.runner().Run(ctx, stack, runID, workDir, "destroy", envVars)
+	if errors.Is(err, errStepPending) {
+		ctrl.Log.Info("Waiting for terraform destroy", "name", stack.Name, "run", runID)
+		if err := r.Status().Update(ctx, stack); err != nil {
+			ctrl.Log.Info("Failed to update stack status while waiting", "name", stack.Name, "error", err)
+		}
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+	out = redactor.Redact(out)
+	if err != nil {
+		err = errors.New(redactor.Redact(err.Error()))
+	}
 	ctrl.Log.Info("Terraform destroy output", "output", out, "error", err)
 	r.appendStackLog(ctx, stack, "destroy", out)
 	if err != nil {
 		ctrl.Log.Info("Terraform destroy failed, not removing finalizer", "error", err)
+		finishStackRun(stack, runFailed, "destroy", err.Error())
 		r.setStackError(ctx, stack, "TerraformDestroyError", err.Error())
 		r.emitStackEvent(stack, corev1.EventTypeWarning, "DestroyFailed", err.Error())
 		// Do not remove finalizer, so deletion is retried
 		return ctrl.Result{RequeueAfter: time.Minute * 1}, nil
 	}
+	// Nothing is left to manage: drop the state and the workspace
+	if err := r.deleteManagedState(ctx, stack); err != nil {
+		ctrl.Log.Info("Failed to delete managed terraform state, not removing finalizer", "name", stack.Name, "error", err)
+		finishStackRun(stack, runFailed, "destroy", err.Error())
+		r.setStackError(ctx, stack, "StateCleanupFailed", err.Error())
+		return ctrl.Result{RequeueAfter: time.Minute * 1}, nil
+	}
+	if err := ws.Remove(); err != nil {
+		ctrl.Log.Info("Failed to remove stack workspace", "name", stack.Name, "workDir", workDir, "error", err)
+	}
 	// Success: remove finalizer
 	ctrl.Log.Info("Terraform destroy succeeded, removing finalizer", "name", stack.Name)
 	controllerutil.RemoveFinalizer(stack, finalizerName)
@@ -398,9 +631,12 @@ func (r *StackReconciler) setStackPhase(ctx context.Context, stack *astrolabev1.
 	}
 }
 
-// appendStackLog is now a no-op. Logs are not stored in status; see controller logs for details.
+// appendStackLog stores the output of a step with the logs of the current
+// run; logStr must already be redacted.
 func (r *StackReconciler) appendStackLog(ctx context.Context, stack *astrolabev1.Stack, step, logStr string) {
-	// No-op: do not store logs in status. Use controller logs for details.
+	if err := r.storeStepLog(ctx, stack, step, logStr); err != nil {
+		ctrl.Log.Info("Failed to store terraform step log", "name", stack.Name, "step", step, "error", err)
+	}
 }
 
 // setStackSuccess sets status fields and appends a success event
@@ -426,169 +662,96 @@ func (r *StackReconciler) setStackSuccess(ctx context.Context, stack *astrolabev
 	}
 }
 
-func runTerraformStep(workDir, step string, env []string) (string, error) {
-	// Run terraform init or plan as a subprocess in workDir, with error handling
-	var cmd *exec.Cmd
-	if step == "init" {
-		cmd = exec.Command("terraform", "init", "-input=false")
-	} else if step == "plan" {
-		cmd = exec.Command("terraform", "plan", "-input=false", "-no-color")
-	} else if step == "apply" {
-		cmd = exec.Command("terraform", "apply", "-auto-approve", "-input=false", "-no-color")
-	} else if step == "destroy" {
-		cmd = exec.Command("terraform", "destroy", "-auto-approve", "-input=false", "-no-color")
-	} else {
-		return "", fmt.Errorf("unsupported terraform step: %s", step)
-	}
-	cmd.Dir = workDir
-	cmd.Env = append(os.Environ(), env...)
-	var outBuf, errBuf bytes.Buffer
-	cmd.Stdout = &outBuf
-	cmd.Stderr = &errBuf
-	err := cmd.Run()
-	output := outBuf.String() + errBuf.String()
-	if err != nil {
-		var exitErr *exec.ExitError
-		if errors.As(err, &exitErr) {
-			return output, fmt.Errorf("terraform %s failed with exit code %d: %s", step, exitErr.ExitCode(), output)
+// runStackStep runs a terraform step for the Stack, recording its phase and
+// redacted log, and reports a failure in the Stack status.
+func (r *StackReconciler) runStackStep(ctx context.Context, stack *astrolabev1.Stack, runID, workDir, step string, env []string, redactor *logRedactor) error {
+	phase := strings.Title(step)
+	ctrl.Log.Info("Running terraform step", "step", step, "workDir", workDir)
+	// A resumed run repeats the steps its Jobs finished; only the step still
+	// running sets the phase, so resuming does not flip it back and forth
+	_, async := r.runner().(*JobRunner)
+	if !async {
+		r.setStackPhase(ctx, stack, phase)
+	}
+	out, err := r.runner().Run(ctx, stack, runID, workDir, step, env)
+	if errors.Is(err, errStepPending) {
+		ctrl.Log.Info("Waiting for terraform step", "name", stack.Name, "run", runID, "step", step)
+		if stack.Status.Phase != phase {
+			r.setStackPhase(ctx, stack, phase)
+		} else if err := r.Status().Update(ctx, stack); err != nil {
+			// Keeps the run record, which the next reconcile resumes
+			ctrl.Log.Info("Failed to update stack status while waiting", "name", stack.Name, "error", err)
 		}
-		return output, fmt.Errorf("terraform %s failed: %w\nOutput: %s", step, err, output)
+		return err
+	}
+	r.appendStackLog(ctx, stack, step, redactor.Redact(out))
+	if err != nil {
+		// The error carries the output too
+		err = errors.New(redactor.Redact(err.Error()))
+		ctrl.Log.Info("Terraform step failed", "step", step, "error", err)
+		finishStackRun(stack, runFailed, step, err.Error())
+		r.setStackError(ctx, stack, "Terraform"+phase+"Error", err.Error())
 	}
-	return output, nil
+	return err
 }
 
-// renderOutputsTf generates the outputs.tf content for all modules, using their output names.
-func renderOutputsTf(modules []astrolabev1.Module) string {
-	var sb strings.Builder
-	for _, mod := range modules {
-		modName := mod.Name
-		// If module outputs are defined in Status.Outputs, use them; else, skip
-		if mod.Status.Outputs != nil {
-			for _, output := range mod.Status.Outputs {
-				sb.WriteString(fmt.Sprintf("output \"%s\" {\n  value = module.%s.%s\n}\n\n", output.Name, modName, output.Name))
-			}
-		}
+// stepResult requeues after a failed step, or checks on a step that is
+// still running; its Job finishing requeues the Stack earlier.
+func stepResult(err error) ctrl.Result {
+	if errors.Is(err, errStepPending) {
+		return ctrl.Result{RequeueAfter: time.Minute}
 	}
-	return sb.String()
+	return ctrl.Result{Requeue: true}
 }
 
-func renderBackendTf(backend astrolabev1.BackendConfigSpec, stackName string) string {
-	backendType := backend.Type
-	settings := backend.Settings
-	var sb strings.Builder
-	sb.WriteString(fmt.Sprintf("terraform {\n  backend \"%s\" {\n", backendType))
-	var settingsMap map[string]interface{}
-	if settings.Raw != nil {
-		_ = json.Unmarshal(settings.Raw, &settingsMap)
-	} else {
-		settingsMap = map[string]interface{}{}
-	}
-	// For s3 and azurerm backends, ensure 'key' is set to 'astrolabe/<stackName>.tfstate' if not present
-	if backendType == "s3" || backendType == "azurerm" {
-		if _, ok := settingsMap["key"]; !ok {
-			settingsMap["key"] = fmt.Sprintf("astrolabe/%s.tfstate", stackName)
-		}
+// workspaces returns the workspace store of the reconciler.
+func (r *StackReconciler) workspaces() *workspace.Store {
+	if r.Workspaces == nil {
+		return workspace.New(defaultWorkspaceRoot)
 	}
-	for k, v := range settingsMap {
-		// Render string values with quotes, bool/numeric as is
-		switch val := v.(type) {
-		case string:
-			sb.WriteString(fmt.Sprintf("    %s = \"%s\"\n", k, val))
-		case bool, int, int32, int64, float32, float64:
-			sb.WriteString(fmt.Sprintf("    %s = %v\n", k, val))
-		default:
-			sb.WriteString(fmt.Sprintf("    %s = \"%v\"\n", k, val))
-		}
+	return r.Workspaces
+}
+
+// workspaceUnavailable reports a Stack whose workspace cannot be acquired,
+// usually because another operation holds it, and checks again later.
+func (r *StackReconciler) workspaceUnavailable(ctx context.Context, stack *astrolabev1.Stack, err error) (ctrl.Result, error) {
+	reason := "WorkspaceUnavailable"
+	if errors.Is(err, workspace.ErrLocked) {
+		reason = "WorkspaceLocked"
 	}
-	sb.WriteString("  }\n}\n")
-	return sb.String()
+	ctrl.Log.Info("Stack workspace unavailable", "name", stack.Name, "reason", reason, "error", err)
+	r.setStackError(ctx, stack, reason, err.Error())
+	return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
 }
 
-func renderMainTf(stack astrolabev1.Stack, modules []astrolabev1.Module) string {
-	var sb strings.Builder
-	for i, mod := range modules {
-		stackMod := stack.Spec.Modules[i]
-		sb.WriteString(fmt.Sprintf("module \"%s\" {\n", stackMod.Name))
-		// Render source and version from mod.Spec.Source
-		// Compose the correct source string based on type
-		var sourceStr string
-		switch mod.Spec.Source.Type {
-		case "git":
-			if mod.Spec.Source.Version != "" {
-				sourceStr = fmt.Sprintf("git::%s?ref=%s", mod.Spec.Source.URL, mod.Spec.Source.Version)
-			} else {
-				sourceStr = fmt.Sprintf("git::%s", mod.Spec.Source.URL)
-			}
-		case "http":
-			sourceStr = mod.Spec.Source.URL
-		default:
-			sourceStr = mod.Spec.Source.URL
-		}
-		sb.WriteString(fmt.Sprintf("  source = \"%s\"\n", sourceStr))
-		// version is only needed if module is coming from terraform registry. We added version so that we can download it.
-		// if mod.Spec.Source.Version != "" {
-		// 	sb.WriteString(fmt.Sprintf("  version = \"%s\"\n", mod.Spec.Source.Version))
-		// }
-		// Unmarshal variables JSON
-		var variables map[string]interface{}
-		if stackMod.Variables.Raw != nil {
-			_ = json.Unmarshal(stackMod.Variables.Raw, &variables)
-		} else {
-			variables = map[string]interface{}{}
-		}
-		for k, v := range variables {
-			switch val := v.(type) {
-			case string:
-				sb.WriteString(fmt.Sprintf("  %s = \"%s\"\n", k, val))
-			case []interface{}:
-				sb.WriteString(fmt.Sprintf("  %s = [", k))
-				for j, item := range val {
-					if j > 0 {
-						sb.WriteString(", ")
-					}
-					sb.WriteString(fmt.Sprintf("\"%v\"", item))
-				}
-				sb.WriteString("]\n")
-			case map[string]interface{}:
-				sb.WriteString(fmt.Sprintf("  %s = {", k))
-				first := true
-				for mk, mv := range val {
-					if !first {
-						sb.WriteString(", ")
-					}
-					sb.WriteString(fmt.Sprintf("%q = \"%v\"", mk, mv))
-					first = false
-				}
-				sb.WriteString("}\n")
-			default:
-				sb.WriteString(fmt.Sprintf("  %s = %v\n", k, v))
-			}
-		}
-		if len(stackMod.DependsOn) > 0 {
-			sb.WriteString("  depends_on = [")
-			for j, dep := range stackMod.DependsOn {
-				if j > 0 {
-					sb.WriteString(", ")
-				}
-				sb.WriteString(fmt.Sprintf("module.%s", dep))
-			}
-			sb.WriteString("]\n")
-		}
-		sb.WriteString("}\n\n")
+// runner returns the TerraformRunner of the reconciler.
+func (r *StackReconciler) runner() TerraformRunner {
+	if r.Runner == nil {
+		return LocalRunner{}
 	}
-	return sb.String()
+	return r.Runner
 }
 
-func renderVariablesTf(module astrolabev1.Module, stackMod astrolabev1.StackModuleRef) string {
-	var sb strings.Builder
-	for _, input := range module.Status.Inputs {
-		sb.WriteString(fmt.Sprintf("variable \"%s\" {\n  type = %s", input.Name, input.Type))
-		if input.Default != nil {
-			sb.WriteString(fmt.Sprintf("\n  default = %v", input.Default))
+// beginStackRun starts a run of the Stack, or resumes the one in flight
+// when terraform runs in Jobs, which outlive reconciles. A run is resumed
+// even if the Stack changed meanwhile, so two Jobs never change the same
+// state; the change gets the next run. Destroying resumes only the run
+// started for it. wait asks to requeue while Jobs of another run finish.
+func (r *StackReconciler) beginStackRun(ctx context.Context, stack *astrolabev1.Stack, deleting bool, trigger string) (runID string, wait bool) {
+	if _, async := r.runner().(*JobRunner); async {
+		if run := currentStackRun(stack); run != nil && (run.Trigger == "Deleted") == deleting {
+			r.pruneStackRunJobs(ctx, stack, run.ID)
+			return run.ID, false
+		}
+		if r.pruneStackRunJobs(ctx, stack, "") {
+			ctrl.Log.Info("Waiting for terraform jobs of a previous run", "name", stack.Name)
+			return "", true
 		}
-		sb.WriteString("\n}\n\n")
 	}
-	return sb.String()
+	runID = startStackRun(stack, trigger)
+	ctrl.Log.Info("Starting stack run", "name", stack.Name, "run", runID, "trigger", trigger)
+	r.pruneStackRunLogs(ctx, stack)
+	return runID, false
 }
 
 func writeFile(path, content string) error {
@@ -597,8 +760,15 @@ func writeFile(path, content string) error {
 
 func (r *StackReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.Recorder = mgr.GetEventRecorderFor("stack-controller")
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &astrolabev1.Stack{}, upstreamStackIndex, indexUpstreamStacks); err != nil {
+		return err
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&astrolabev1.Stack{}).
 		Owns(&corev1.Secret{}).
+		// Requeue Stacks when the Job running one of their steps finishes
+		Owns(&batchv1.Job{}).
+		// Requeue Stacks consuming the outputs of a Stack when it changes
+		Watches(&astrolabev1.Stack{}, handler.EnqueueRequestsFromMapFunc(r.downstreamStacks)).
 		Complete(r)
 }
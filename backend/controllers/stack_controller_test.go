@@ -1,10 +1,18 @@
 package controllers
 
 import (
+	"context"
+	"testing"
+
 	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"testing"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 func TestFinalizerAddedOnCreation(t *testing.T) {
@@ -80,6 +88,98 @@ func TestStatusAndEventsUpdatedOnDelete(t *testing.T) {
 	assert.Equal(t, "Destroy started", stack.Status.Summary)
 }
 
+func moduleWithInputs(inputs ...astrolabev1.ModuleInput) astrolabev1.Module {
+	return astrolabev1.Module{
+		ObjectMeta: metav1.ObjectMeta{Name: "vpc", Namespace: "default"},
+		Status:     astrolabev1.ModuleStatus{Inputs: inputs},
+	}
+}
+
+func TestValidateStackVariables(t *testing.T) {
+	mod := moduleWithInputs(
+		astrolabev1.ModuleInput{Name: "name", Type: "string", Required: true},
+		astrolabev1.ModuleInput{Name: "cidrs", Type: "list(string)"},
+		astrolabev1.ModuleInput{Name: "password", Type: "number", Sensitive: true},
+	)
+	stack := &astrolabev1.Stack{Spec: astrolabev1.StackSpec{Modules: []astrolabev1.StackModuleRef{
+		{Name: "vpc", Variables: apiextensionsv1.JSON{Raw: []byte(`{"name":"demo","cidrs":["10.0.0.0/16"],"password":"42"}`)}},
+	}}}
+	assert.Empty(t, validateStackVariables(stack, []astrolabev1.Module{mod}))
+
+	stack.Spec.Modules[0].Variables.Raw = []byte(`{"cidrs":["10.0.0.0/16",{"a":1}],"password":"hunter2","regin":"us-east-1"}`)
+	errs := validateStackVariables(stack, []astrolabev1.Module{mod})
+	require.Len(t, errs, 4)
+	assert.Equal(t, "spec.modules[0].variables.name", errs[0].Field)
+	assert.Equal(t, "spec.modules[0].variables.cidrs[1]", errs[1].Field)
+	assert.Equal(t, "spec.modules[0].variables.password", errs[2].Field)
+	assert.NotContains(t, errs[2].Error(), "hunter2")
+	assert.Equal(t, "spec.modules[0].variables.regin", errs[3].Field)
+	assert.Contains(t, errs[3].Error(), `module vpc has no input variable "regin"`)
+}
+
+func TestValidateStackLinkedInputs(t *testing.T) {
+	vpc := moduleWithInputs()
+	vpc.Status.Outputs = []astrolabev1.ModuleOutput{{Name: "private_subnets"}}
+	eks := moduleWithInputs(
+		astrolabev1.ModuleInput{Name: "subnet_ids", Type: "list(string)", Required: true},
+		astrolabev1.ModuleInput{Name: "vpc_id", Type: "string"},
+	)
+	eks.Name = "eks"
+	stack := &astrolabev1.Stack{Spec: astrolabev1.StackSpec{Modules: []astrolabev1.StackModuleRef{
+		{Name: "vpc"},
+		{Name: "eks", LinkedInputs: []astrolabev1.LinkedInput{
+			{FromModuleID: "vpc", FromOutput: "private_subnets", ToVariable: "subnet_ids"},
+		}},
+	}}}
+	modules := []astrolabev1.Module{vpc, eks}
+	assert.Empty(t, validateStackVariables(stack, modules), "a linked input satisfies a required variable")
+
+	stack.Spec.Modules[1].Variables.Raw = []byte(`{"subnet_ids": ["subnet-1"]}`)
+	stack.Spec.Modules[1].LinkedInputs = append(stack.Spec.Modules[1].LinkedInputs,
+		astrolabev1.LinkedInput{FromModuleID: "vpc", FromOutput: "vpc_id", ToVariable: "vpc_id"},
+		astrolabev1.LinkedInput{FromModuleID: "rds", FromOutput: "endpoint", ToVariable: "db"},
+	)
+	errs := validateStackVariables(stack, modules)
+	require.Len(t, errs, 4)
+	assert.Equal(t, "spec.modules[1].linkedInputs[1].fromOutput", errs[0].Field)
+	assert.Contains(t, errs[0].Error(), `module vpc has no output "vpc_id"`)
+	assert.Equal(t, "spec.modules[1].linkedInputs[2].toVariable", errs[1].Field)
+	assert.Equal(t, "spec.modules[1].linkedInputs[2].fromModuleId", errs[2].Field)
+	assert.Equal(t, "spec.modules[1].variables.subnet_ids", errs[3].Field)
+	assert.Contains(t, errs[3].Error(), "is also set by a linked input")
+}
+
+func TestStackReconcileReportsInvalidVariables(t *testing.T) {
+	mod := moduleWithInputs(
+		astrolabev1.ModuleInput{Name: "name", Type: "string", Required: true},
+		astrolabev1.ModuleInput{Name: "cidrs", Type: "list(string)"},
+	)
+	stack := &astrolabev1.Stack{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default", Generation: 2},
+		Spec: astrolabev1.StackSpec{Modules: []astrolabev1.StackModuleRef{
+			{Name: "vpc", Variables: apiextensionsv1.JSON{Raw: []byte(`{"name":"demo","cidrs":"10.0.0.0/16","extra":true}`)}},
+		}},
+	}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(stack, &mod).WithStatusSubresource(stack, &mod).Build()
+	r := &StackReconciler{Client: c}
+
+	res, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "demo", Namespace: "default"}})
+	require.NoError(t, err)
+	assert.True(t, res.Requeue)
+
+	var got astrolabev1.Stack
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "demo", Namespace: "default"}, &got))
+	assert.Equal(t, "Error", got.Status.Phase)
+	assert.Equal(t, "VariablesInvalid", got.Status.Status)
+	cond := apimeta.FindStatusCondition(got.Status.Conditions, "VariablesValid")
+	require.NotNil(t, cond)
+	assert.Equal(t, metav1.ConditionFalse, cond.Status)
+	assert.Equal(t, "VariablesInvalid", cond.Reason)
+	assert.Equal(t, int64(2), cond.ObservedGeneration)
+	assert.Contains(t, cond.Message, "spec.modules[0].variables.cidrs")
+	assert.Contains(t, cond.Message, "spec.modules[0].variables.extra")
+}
+
 func containsString(slice []string, s string) bool {
 	for _, item := range slice {
 		if item == s {
@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// The output of every terraform step is kept per run in a Secret named
+// <stack>-run-<run ID>, labelled with the Stack and run, under the key
+// <step>.log. The Secrets of runs dropped from status.runs are deleted, and
+// the Stack owns them all so they go when it does.
+const (
+	// stackLabel holds stackLabelValue of the Stack's name; the full name is
+	// kept in the stackNameAnnotation.
+	stackLabel          = "astrolabe.io/stack"
+	stackNameAnnotation = "astrolabe.io/stack-name"
+	runLabel            = "astrolabe.io/run"
+
+	// maxStepLogBytes keeps the run's Secret under the 1MiB object limit;
+	// longer output keeps its end, where errors are.
+	maxStepLogBytes = 200 * 1024
+
+	// minRedactLen avoids redacting short values that would blank out
+	// unrelated text.
+	minRedactLen = 4
+	redacted     = "***"
+)
+
+// stackRunLogName returns the name of the Secret holding the logs of a run.
+func stackRunLogName(stackName, runID string) string {
+	return fmt.Sprintf("%s-run-%s", stackName, runID)
+}
+
+// stackLabelValue returns the stackLabel value of a Stack: its name, or a
+// truncated name with a hash for names longer than the 63 characters a label
+// value allows.
+func stackLabelValue(stackName string) string {
+	if len(stackName) <= validation.LabelValueMaxLength {
+		return stackName
+	}
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte(stackName)))[:8]
+	return strings.TrimRight(stackName[:54], "-.") + "-" + sum
+}
+
+// ownedByStack reports whether an object selected by stackLabel belongs to
+// the Stack, telling apart long names that share a label value.
+func ownedByStack(obj metav1.Object, stackName string) bool {
+	name, ok := obj.GetAnnotations()[stackNameAnnotation]
+	return !ok || name == stackName
+}
+
+// logRedactor removes secret values from terraform output before it is
+// logged or stored.
+type logRedactor struct {
+	replacer *strings.Replacer
+}
+
+// newLogRedactor redacts each of secrets, and each line of a multi-line one.
+func newLogRedactor(secrets []string) *logRedactor {
+	seen := map[string]bool{}
+	var values []string
+	add := func(v string) {
+		v = strings.TrimSpace(v)
+		if len(v) >= minRedactLen && !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+	for _, s := range secrets {
+		add(s)
+		if strings.Contains(s, "\n") {
+			for _, line := range strings.Split(s, "\n") {
+				add(line)
+			}
+		}
+	}
+	// strings.Replacer tries the old strings in order; longer values go first
+	// so a secret containing another is redacted whole
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	pairs := make([]string, 0, 2*len(values))
+	for _, v := range values {
+		pairs = append(pairs, v, redacted)
+	}
+	return &logRedactor{replacer: strings.NewReplacer(pairs...)}
+}
+
+func (l *logRedactor) Redact(s string) string {
+	if l == nil {
+		return s
+	}
+	return l.replacer.Replace(s)
+}
+
+// stackSecretValues lists the values that must not appear in stored logs:
+// the Stack's credentials, module git credentials and the values of
+// variables whose module input is sensitive. modules[i] is the Module for
+// stack.Spec.Modules[i].
+func stackSecretValues(credentials map[string][]byte, gitAuths []*gitAuth, stack *astrolabev1.Stack, modules []astrolabev1.Module) []string {
+	var values []string
+	for _, v := range credentials {
+		values = append(values, string(v))
+	}
+	for _, auth := range gitAuths {
+		if auth == nil || auth.Password == "" {
+			continue
+		}
+		values = append(values, auth.Password, base64.StdEncoding.EncodeToString([]byte(auth.Username+":"+auth.Password)))
+	}
+	for i, mod := range modules {
+		if i >= len(stack.Spec.Modules) {
+			break
+		}
+		variables := map[string]json.RawMessage{}
+		if err := json.Unmarshal(stack.Spec.Modules[i].Variables.Raw, &variables); err != nil {
+			continue
+		}
+		for _, in := range mod.Status.Inputs {
+			raw, ok := variables[in.Name]
+			if !in.Sensitive || !ok {
+				continue
+			}
+			var s string
+			if err := json.Unmarshal(raw, &s); err == nil {
+				values = append(values, s)
+			} else {
+				values = append(values, string(raw))
+			}
+		}
+	}
+	return values
+}
+
+// truncateStepLog keeps the last maxStepLogBytes of out.
+func truncateStepLog(out string) string {
+	if len(out) <= maxStepLogBytes {
+		return out
+	}
+	dropped := len(out) - maxStepLogBytes
+	return fmt.Sprintf("[%d bytes truncated]\n", dropped) + out[dropped:]
+}
+
+// storeStepLog saves the redacted output of a step in the Secret of the
+// Stack's current run.
+func (r *StackReconciler) storeStepLog(ctx context.Context, stack *astrolabev1.Stack, step, out string) error {
+	run := currentStackRun(stack)
+	if run == nil {
+		return nil
+	}
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:      stackRunLogName(stack.Name, run.ID),
+		Namespace: stack.Namespace,
+	}}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		if secret.Labels == nil {
+			secret.Labels = map[string]string{}
+		}
+		secret.Labels[stackLabel] = stackLabelValue(stack.Name)
+		secret.Labels[runLabel] = run.ID
+		if secret.Annotations == nil {
+			secret.Annotations = map[string]string{}
+		}
+		secret.Annotations[stackNameAnnotation] = stack.Name
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		secret.Data[step+".log"] = []byte(truncateStepLog(out))
+		// Not a controller reference: writing logs must not requeue the Stack
+		return controllerutil.SetOwnerReference(stack, secret, r.Client.Scheme())
+	})
+	return err
+}
+
+// pruneStackRunLogs deletes the log Secrets of runs no longer in the
+// Stack's run history.
+func (r *StackReconciler) pruneStackRunLogs(ctx context.Context, stack *astrolabev1.Stack) {
+	var secrets corev1.SecretList
+	if err := r.List(ctx, &secrets, client.InNamespace(stack.Namespace), client.MatchingLabels{stackLabel: stackLabelValue(stack.Name)}); err != nil {
+		ctrl.Log.Info("Failed to list stack run logs", "name", stack.Name, "error", err)
+		return
+	}
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if !ownedByStack(secret, stack.Name) || hasStackRun(stack, secret.Labels[runLabel]) {
+			continue
+		}
+		if err := r.Delete(ctx, secret); err != nil && !k8serrors.IsNotFound(err) {
+			ctrl.Log.Info("Failed to delete stack run logs", "name", stack.Name, "secret", secret.Name, "error", err)
+		}
+	}
+}
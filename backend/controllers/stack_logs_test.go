@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestLogRedactor(t *testing.T) {
+	redactor := newLogRedactor([]string{"AKIASECRET", "AKIASECRET-LONGER", "abc", "-----BEGIN KEY-----\nline-of-key-data\n-----END KEY-----"})
+	out := redactor.Redact("key=AKIASECRET-LONGER id=AKIASECRET abc\nline-of-key-data")
+	assert.Equal(t, "key=*** id=*** abc\n***", out, "short values are kept, multi-line values are redacted by line")
+
+	var none *logRedactor
+	assert.Equal(t, "as is", none.Redact("as is"))
+}
+
+func TestStackSecretValues(t *testing.T) {
+	stack := &astrolabev1.Stack{Spec: astrolabev1.StackSpec{Modules: []astrolabev1.StackModuleRef{
+		{Name: "vpc", Variables: apiextensionsv1.JSON{Raw: []byte(`{"name":"demo","password":"hunter2","token":{"a":1}}`)}},
+	}}}
+	mod := moduleWithInputs(
+		astrolabev1.ModuleInput{Name: "name", Type: "string"},
+		astrolabev1.ModuleInput{Name: "password", Type: "string", Sensitive: true},
+		astrolabev1.ModuleInput{Name: "token", Type: "any", Sensitive: true},
+	)
+	values := stackSecretValues(
+		map[string][]byte{"AWS_SECRET_ACCESS_KEY": []byte("s3cr3t")},
+		[]*gitAuth{nil, {Username: "git", Password: "pat"}},
+		stack, []astrolabev1.Module{mod})
+	assert.ElementsMatch(t, []string{"s3cr3t", "pat", "Z2l0OnBhdA==", "hunter2", `{"a":1}`}, values)
+}
+
+func TestStackLabelValue(t *testing.T) {
+	assert.Equal(t, "demo", stackLabelValue("demo"))
+
+	long := strings.Repeat("a", 70)
+	value := stackLabelValue(long)
+	assert.Len(t, value, 63)
+	assert.NotEqual(t, value, stackLabelValue(long+"b"), "names sharing a prefix get different values")
+}
+
+func TestStoreStepLogLongStackName(t *testing.T) {
+	ctx := context.Background()
+	name := strings.Repeat("a", 70)
+	stack := &astrolabev1.Stack{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: "uid-1"}}
+	// Another Stack's Secret carrying the same label value
+	twin := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name: "twin", Namespace: "default",
+		Labels:      map[string]string{stackLabel: stackLabelValue(name)},
+		Annotations: map[string]string{stackNameAnnotation: name + "b"},
+	}}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(stack, twin).Build()
+	r := &StackReconciler{Client: c}
+
+	id := startStackRun(stack, "Created")
+	require.NoError(t, r.storeStepLog(ctx, stack, "init", "Initializing"))
+	var secret corev1.Secret
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "default", Name: stackRunLogName(name, id)}, &secret))
+	assert.Equal(t, stackLabelValue(name), secret.Labels[stackLabel])
+	assert.Equal(t, name, secret.Annotations[stackNameAnnotation])
+
+	stack.Status.Runs = nil
+	r.pruneStackRunLogs(ctx, stack)
+	var secrets corev1.SecretList
+	require.NoError(t, c.List(ctx, &secrets))
+	require.Len(t, secrets.Items, 1, "only the Stack's own logs are pruned")
+	assert.Equal(t, "twin", secrets.Items[0].Name)
+}
+
+func TestStoreStepLog(t *testing.T) {
+	stack := &astrolabev1.Stack{ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default", UID: "uid-1"}}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(stack).Build()
+	r := &StackReconciler{Client: c}
+	ctx := context.Background()
+
+	// Without a running run there is nowhere to store the log
+	require.NoError(t, r.storeStepLog(ctx, stack, "init", "ignored"))
+
+	old := startStackRun(stack, "Created")
+	require.NoError(t, r.storeStepLog(ctx, stack, "init", "Initializing"))
+	require.NoError(t, r.storeStepLog(ctx, stack, "plan", strings.Repeat("x", maxStepLogBytes)+"Error: boom"))
+
+	var secret corev1.Secret
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "demo-run-" + old}, &secret))
+	assert.Equal(t, map[string]string{stackLabel: "demo", runLabel: old}, secret.Labels)
+	assert.Equal(t, "demo", secret.Annotations[stackNameAnnotation])
+	assert.Equal(t, "Initializing", string(secret.Data["init.log"]))
+	plan := string(secret.Data["plan.log"])
+	assert.True(t, strings.HasPrefix(plan, "[11 bytes truncated]\n"))
+	assert.True(t, strings.HasSuffix(plan, "Error: boom"))
+	require.Len(t, secret.OwnerReferences, 1)
+	assert.Equal(t, "demo", secret.OwnerReferences[0].Name)
+	assert.Nil(t, secret.OwnerReferences[0].Controller, "log Secrets must not requeue the Stack")
+
+	// Logs of runs dropped from the history are deleted
+	other := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default", Labels: map[string]string{stackLabel: "other-stack"}}}
+	require.NoError(t, c.Create(ctx, other))
+	finishStackRun(stack, runSucceeded, "", "")
+	current := startStackRun(stack, "Resync")
+	require.NoError(t, r.storeStepLog(ctx, stack, "init", "again"))
+	stack.Status.Runs = stack.Status.Runs[1:]
+	r.pruneStackRunLogs(ctx, stack)
+
+	var secrets corev1.SecretList
+	require.NoError(t, c.List(ctx, &secrets))
+	var names []string
+	for _, s := range secrets.Items {
+		names = append(names, s.Name)
+	}
+	assert.ElementsMatch(t, []string{"demo-run-" + current, "other"}, names)
+}
@@ -0,0 +1,406 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// planFileName is the plan saved by `terraform plan -out` in a Stack's
+// working directory. apply applies this file, never a fresh plan.
+const planFileName = "tfplan"
+
+// terraformPlanJSON is the part of `terraform show -json <plan>` the
+// controller reads.
+type terraformPlanJSON struct {
+	ResourceChanges json.RawMessage `json:"resource_changes"`
+	OutputChanges   json.RawMessage `json:"output_changes"`
+}
+
+// terraformChange is a change representation; resource changes wrap it in
+// "change", output changes are one themselves.
+type terraformChange struct {
+	Actions []string `json:"actions"`
+}
+
+type terraformResourceChange struct {
+	Address       string `json:"address"`
+	ModuleAddress string `json:"module_address"`
+	Type          string `json:"type"`
+	Name          string `json:"name"`
+	ProviderName  string `json:"provider_name"`
+	Change        struct {
+		terraformChange
+		Before          json.RawMessage `json:"before"`
+		After           json.RawMessage `json:"after"`
+		AfterUnknown    interface{}     `json:"after_unknown"`
+		BeforeSensitive interface{}     `json:"before_sensitive"`
+		AfterSensitive  interface{}     `json:"after_sensitive"`
+	} `json:"change"`
+}
+
+// Placeholders for values a plan does not show.
+const (
+	sensitiveValue  = "(sensitive value)"
+	knownAfterApply = "(known after apply)"
+)
+
+// summarizePlan describes the plan in the JSON output of `terraform show`.
+// The hash covers only the planned resource and output changes, so planning
+// an unchanged Stack again yields the same hash and keeps an approval valid.
+func summarizePlan(raw []byte) (*astrolabev1.StackPlan, error) {
+	var plan terraformPlanJSON
+	if err := json.Unmarshal(raw, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse plan: %w", err)
+	}
+	var resources []terraformResourceChange
+	if len(plan.ResourceChanges) > 0 {
+		if err := json.Unmarshal(plan.ResourceChanges, &resources); err != nil {
+			return nil, fmt.Errorf("failed to parse plan resource changes: %w", err)
+		}
+	}
+	outputs := map[string]terraformChange{}
+	if len(plan.OutputChanges) > 0 {
+		if err := json.Unmarshal(plan.OutputChanges, &outputs); err != nil {
+			return nil, fmt.Errorf("failed to parse plan output changes: %w", err)
+		}
+	}
+
+	summary := &astrolabev1.StackPlan{CreatedAt: metav1.Now()}
+	for _, rc := range resources {
+		action := planAction(rc.Change.Actions)
+		switch action {
+		case "no-op":
+			continue
+		case "create":
+			summary.Add++
+		case "update":
+			summary.Change++
+		case "delete":
+			summary.Destroy++
+		case "replace":
+			summary.Add++
+			summary.Destroy++
+			summary.Replace++
+		}
+		if len(summary.Changes) >= astrolabev1.MaxPlanChanges {
+			continue
+		}
+		change, err := planChange(rc, action)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse plan change of %s: %w", rc.Address, err)
+		}
+		summary.Changes = append(summary.Changes, change)
+	}
+	outputsChanged := false
+	for _, oc := range outputs {
+		if len(oc.Actions) != 1 || oc.Actions[0] != "no-op" {
+			outputsChanged = true
+		}
+	}
+	if summary.Add+summary.Change+summary.Destroy == 0 && !outputsChanged {
+		summary.Summary = "No changes."
+	} else {
+		summary.Summary = fmt.Sprintf("Plan: %d to add, %d to change, %d to destroy.", summary.Add, summary.Change, summary.Destroy)
+	}
+
+	h := sha256.New()
+	for _, part := range []json.RawMessage{plan.ResourceChanges, plan.OutputChanges} {
+		var buf bytes.Buffer
+		if len(part) > 0 {
+			if err := json.Compact(&buf, part); err != nil {
+				return nil, err
+			}
+		}
+		h.Write(buf.Bytes())
+		h.Write([]byte{'\n'})
+	}
+	summary.Hash = fmt.Sprintf("%x", h.Sum(nil))
+	return summary, nil
+}
+
+// planAction names the action of a change as the API does, folding the
+// delete and create pair of a replacement into "replace".
+func planAction(actions []string) string {
+	switch {
+	case len(actions) == 0:
+		return "no-op"
+	case len(actions) == 2 && actions[0] != actions[1] &&
+		(actions[0] == "delete" || actions[0] == "create") && (actions[1] == "delete" || actions[1] == "create"):
+		return "replace"
+	default:
+		return strings.Join(actions, "-")
+	}
+}
+
+// planChange describes one resource change, redacting sensitive values and
+// marking values only known after apply.
+func planChange(rc terraformResourceChange, action string) (astrolabev1.StackPlanChange, error) {
+	var before, after interface{}
+	for _, v := range []struct {
+		raw  json.RawMessage
+		into *interface{}
+	}{{rc.Change.Before, &before}, {rc.Change.After, &after}} {
+		if len(v.raw) == 0 {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(v.raw))
+		dec.UseNumber()
+		if err := dec.Decode(v.into); err != nil {
+			return astrolabev1.StackPlanChange{}, err
+		}
+	}
+
+	resource := map[string]interface{}{
+		"address":  rc.Address,
+		"type":     rc.Type,
+		"name":     rc.Name,
+		"provider": rc.ProviderName,
+	}
+	if rc.ModuleAddress != "" {
+		resource["module"] = rc.ModuleAddress
+	}
+	shownBefore := redactPlanValue(before, rc.Change.BeforeSensitive)
+	shownAfter := redactPlanValue(markUnknownPlanValue(after, rc.Change.AfterUnknown), rc.Change.AfterSensitive)
+	switch action {
+	case "create", "read":
+		resource["after"] = shownAfter
+	case "delete":
+		resource["before"] = shownBefore
+	default:
+		// Compare the real values so a changed sensitive value is listed too
+		changedBefore, changedAfter := map[string]interface{}{}, map[string]interface{}{}
+		beforeAttrs, _ := before.(map[string]interface{})
+		afterAttrs, _ := after.(map[string]interface{})
+		unknownAttrs, _ := rc.Change.AfterUnknown.(map[string]interface{})
+		shownBeforeAttrs, _ := shownBefore.(map[string]interface{})
+		shownAfterAttrs, _ := shownAfter.(map[string]interface{})
+		for _, attrs := range []map[string]interface{}{beforeAttrs, afterAttrs, unknownAttrs} {
+			for name := range attrs {
+				if reflect.DeepEqual(beforeAttrs[name], afterAttrs[name]) && !hasUnknown(unknownAttrs[name]) {
+					continue
+				}
+				changedBefore[name] = shownBeforeAttrs[name]
+				changedAfter[name] = shownAfterAttrs[name]
+			}
+		}
+		resource["before"] = changedBefore
+		resource["after"] = changedAfter
+	}
+	raw, err := json.Marshal(resource)
+	if err != nil {
+		return astrolabev1.StackPlanChange{}, err
+	}
+	return astrolabev1.StackPlanChange{Resource: apiextensionsv1.JSON{Raw: raw}, Action: action}, nil
+}
+
+// redactPlanValue replaces the parts of v that sensitive, a before_sensitive
+// or after_sensitive structure of the plan, marks as sensitive.
+func redactPlanValue(v, sensitive interface{}) interface{} {
+	switch s := sensitive.(type) {
+	case bool:
+		if s {
+			return sensitiveValue
+		}
+	case map[string]interface{}:
+		if obj, ok := v.(map[string]interface{}); ok {
+			out := make(map[string]interface{}, len(obj))
+			for name, val := range obj {
+				out[name] = redactPlanValue(val, s[name])
+			}
+			return out
+		}
+	case []interface{}:
+		if list, ok := v.([]interface{}); ok {
+			out := make([]interface{}, len(list))
+			for i, val := range list {
+				if i < len(s) {
+					val = redactPlanValue(val, s[i])
+				}
+				out[i] = val
+			}
+			return out
+		}
+	}
+	return v
+}
+
+// markUnknownPlanValue fills in the parts of v that unknown, the
+// after_unknown structure of the plan, marks as computed during apply.
+func markUnknownPlanValue(v, unknown interface{}) interface{} {
+	switch u := unknown.(type) {
+	case bool:
+		if u {
+			return knownAfterApply
+		}
+	case map[string]interface{}:
+		obj, _ := v.(map[string]interface{})
+		out := make(map[string]interface{}, len(obj))
+		for name, val := range obj {
+			out[name] = val
+		}
+		for name, nested := range u {
+			if hasUnknown(nested) {
+				out[name] = markUnknownPlanValue(out[name], nested)
+			}
+		}
+		return out
+	case []interface{}:
+		list, _ := v.([]interface{})
+		out := make([]interface{}, len(list))
+		copy(out, list)
+		for i, nested := range u {
+			if i < len(out) {
+				out[i] = markUnknownPlanValue(out[i], nested)
+			}
+		}
+		return out
+	}
+	return v
+}
+
+// hasUnknown reports whether an after_unknown structure marks any value.
+func hasUnknown(unknown interface{}) bool {
+	switch u := unknown.(type) {
+	case bool:
+		return u
+	case map[string]interface{}:
+		for _, nested := range u {
+			if hasUnknown(nested) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, nested := range u {
+			if hasUnknown(nested) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// showTerraformPlan returns the saved plan in workDir as JSON.
+func showTerraformPlan(workDir string, env []string) ([]byte, error) {
+	args, _ := terraformArgs("show")
+	cmd := exec.Command("terraform", args...)
+	cmd.Dir = workDir
+	cmd.Env = append(os.Environ(), env...)
+	var outBuf, errBuf bytes.Buffer
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &errBuf
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("terraform show failed with exit code %d: %s", exitErr.ExitCode(), errBuf.String())
+		}
+		return nil, fmt.Errorf("terraform show failed: %w", err)
+	}
+	return outBuf.Bytes(), nil
+}
+
+// readStackPlan summarizes the saved plan in workDir.
+func readStackPlan(ctx context.Context, runner TerraformRunner, stack *astrolabev1.Stack, runID, workDir string, env []string) (*astrolabev1.StackPlan, error) {
+	raw, err := runner.Run(ctx, stack, runID, workDir, "show", env)
+	if err != nil {
+		return nil, err
+	}
+	return summarizePlan([]byte(raw))
+}
+
+// stackConfigHash hashes the root configuration rendered into workDir, so a
+// saved plan is only reused for the configuration it was made from.
+func stackConfigHash(workDir, format string) (string, error) {
+	if format == "" {
+		format = astrolabev1.RenderFormatHCL
+	}
+	h := sha256.New()
+	for _, f := range stackConfigFiles[format] {
+		content, err := os.ReadFile(filepath.Join(workDir, f.name))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\n%d\n", f.name, len(content))
+		h.Write(content)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// planApproval reports whether the saved plan of a Stack with manual
+// approval may be applied, and otherwise the condition reason and message
+// explaining what is missing.
+func planApproval(stack *astrolabev1.Stack) (bool, string, string) {
+	plan := stack.Status.Plan
+	if plan == nil {
+		return false, "PlanMissing", "No saved plan to approve"
+	}
+	approved := stack.Annotations[astrolabev1.ApprovedPlanAnnotation]
+	switch approved {
+	case "":
+		return false, "AwaitingApproval", fmt.Sprintf("%s Approve by setting annotation %s=%s", plan.Summary, astrolabev1.ApprovedPlanAnnotation, plan.Hash)
+	case plan.Hash:
+		return true, "Approved", fmt.Sprintf("Plan %s was approved", plan.Hash)
+	default:
+		return false, "PlanChanged", fmt.Sprintf("Approved plan %s does not match the saved plan %s; %s Approve by setting annotation %s=%s",
+			approved, plan.Hash, plan.Summary, astrolabev1.ApprovedPlanAnnotation, plan.Hash)
+	}
+}
+
+// clearPlanApproval removes the approval annotation once the approved plan
+// was applied. Only the metadata is patched, so the caller's pending status
+// changes are kept.
+func (r *StackReconciler) clearPlanApproval(ctx context.Context, stack *astrolabev1.Stack) error {
+	if _, ok := stack.Annotations[astrolabev1.ApprovedPlanAnnotation]; !ok {
+		return nil
+	}
+	obj := stack.DeepCopy()
+	base := obj.DeepCopy()
+	delete(obj.Annotations, astrolabev1.ApprovedPlanAnnotation)
+	if err := r.Patch(ctx, obj, client.MergeFrom(base)); err != nil {
+		return err
+	}
+	delete(stack.Annotations, astrolabev1.ApprovedPlanAnnotation)
+	stack.ResourceVersion = obj.ResourceVersion
+	return nil
+}
+
+// awaitPlanApproval records that the saved plan of the Stack waits for
+// approval. Setting the approval annotation triggers the next reconcile.
+func (r *StackReconciler) awaitPlanApproval(ctx context.Context, stack *astrolabev1.Stack, reason, msg string) (ctrl.Result, error) {
+	ctrl.Log.Info("Stack plan awaits approval", "name", stack.Name, "plan", stack.Status.Plan.Hash, "reason", reason)
+	finishStackRun(stack, runAwaitingApproval, "", msg)
+	if stack.Status.Status != reason || stack.Status.Summary != msg {
+		r.emitStackEvent(stack, corev1.EventTypeNormal, reason, msg)
+	}
+	apimeta.SetStatusCondition(&stack.Status.Conditions, metav1.Condition{
+		Type:               "PlanApproved",
+		Status:             metav1.ConditionFalse,
+		Reason:             reason,
+		Message:            msg,
+		ObservedGeneration: stack.Generation,
+	})
+	stack.Status.Phase = "AwaitingApproval"
+	stack.Status.Status = reason
+	stack.Status.Summary = msg
+	stack.Status.Ready = false
+	if err := r.Status().Update(ctx, stack); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
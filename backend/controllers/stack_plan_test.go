@@ -0,0 +1,266 @@
+package controllers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	"github.com/junaid18183/astrolabe/internal/workspace"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const testPlanJSON = `{
+  "format_version": "1.2",
+  "terraform_version": "1.9.5",
+  "timestamp": "2026-01-01T00:00:00Z",
+  "resource_changes": [
+    {"address": "module.vpc.aws_vpc.this", "change": {"actions": ["create"]}},
+    {"address": "module.vpc.aws_subnet.a", "change": {"actions": ["update"]}},
+    {"address": "module.vpc.aws_subnet.b", "change": {"actions": ["delete", "create"]}},
+    {"address": "module.vpc.aws_eip.nat", "change": {"actions": ["delete"]}},
+    {"address": "module.vpc.aws_route.r", "change": {"actions": ["no-op"]}}
+  ],
+  "output_changes": {"vpc_id": {"actions": ["create"]}}
+}`
+
+func TestSummarizePlan(t *testing.T) {
+	plan, err := summarizePlan([]byte(testPlanJSON))
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), plan.Add)
+	assert.Equal(t, int32(1), plan.Change)
+	assert.Equal(t, int32(2), plan.Destroy)
+	assert.Equal(t, "Plan: 2 to add, 1 to change, 2 to destroy.", plan.Summary)
+	assert.Len(t, plan.Hash, 64)
+
+	// Planning again at another time or with another Terraform keeps the hash
+	again, err := summarizePlan([]byte(`{"timestamp": "2026-02-02T00:00:00Z", "terraform_version": "1.10.0",
+		"resource_changes": [
+			{"address": "module.vpc.aws_vpc.this", "change": {"actions": ["create"]}},
+			{"address": "module.vpc.aws_subnet.a", "change": {"actions": ["update"]}},
+			{"address": "module.vpc.aws_subnet.b", "change": {"actions": ["delete", "create"]}},
+			{"address": "module.vpc.aws_eip.nat", "change": {"actions": ["delete"]}},
+			{"address": "module.vpc.aws_route.r", "change": {"actions": ["no-op"]}}
+		],
+		"output_changes": {"vpc_id": {"actions": ["create"]}}}`))
+	require.NoError(t, err)
+	assert.Equal(t, plan.Hash, again.Hash)
+
+	noop, err := summarizePlan([]byte(`{"resource_changes": [{"address": "a.b", "change": {"actions": ["no-op"]}}],
+		"output_changes": {"vpc_id": {"actions": ["no-op"]}}}`))
+	require.NoError(t, err)
+	assert.Equal(t, "No changes.", noop.Summary)
+	assert.NotEqual(t, plan.Hash, noop.Hash)
+
+	_, err = summarizePlan([]byte(`{"resource_changes": {}}`))
+	assert.ErrorContains(t, err, "failed to parse plan resource changes")
+}
+
+func TestSummarizePlanChanges(t *testing.T) {
+	plan, err := summarizePlan([]byte(`{"resource_changes": [
+		{"address": "module.db.aws_db_instance.this", "module_address": "module.db", "type": "aws_db_instance", "name": "this",
+		 "provider_name": "registry.terraform.io/hashicorp/aws",
+		 "change": {"actions": ["update"],
+		  "before": {"id": "db-1", "password": "old-secret", "size": 20, "tags": {"team": "a"}, "arn": "arn:1"},
+		  "after": {"id": "db-1", "password": "new-secret", "size": 12345678901234567890, "tags": {"team": "a"}, "arn": null},
+		  "after_unknown": {"arn": true, "tags": {}},
+		  "before_sensitive": {"password": true}, "after_sensitive": {"password": true}}},
+		{"address": "aws_vpc.this", "type": "aws_vpc", "name": "this", "provider_name": "registry.terraform.io/hashicorp/aws",
+		 "change": {"actions": ["create"], "before": null,
+		  "after": {"cidr_block": "10.0.0.0/16", "id": null, "tags": {"token": "t", "team": "a"}, "subnets": [{"id": null}]},
+		  "after_unknown": {"id": true, "subnets": [{"id": true}], "tags": {}},
+		  "before_sensitive": false, "after_sensitive": {"tags": {"token": true}}}},
+		{"address": "aws_eip.nat", "type": "aws_eip", "name": "nat", "provider_name": "registry.terraform.io/hashicorp/aws",
+		 "change": {"actions": ["create", "delete"], "before": {"id": "eip-1", "domain": "vpc"}, "after": {"id": null, "domain": "vpc"},
+		  "after_unknown": {"id": true}, "before_sensitive": {}, "after_sensitive": {}}},
+		{"address": "aws_key_pair.old", "type": "aws_key_pair", "name": "old", "provider_name": "registry.terraform.io/hashicorp/aws",
+		 "change": {"actions": ["delete"], "before": {"id": "key-1", "private": "pem"}, "after": null,
+		  "before_sensitive": {"private": true}, "after_sensitive": false}},
+		{"address": "aws_route.r", "type": "aws_route", "name": "r", "change": {"actions": ["no-op"]}}
+	]}`))
+	require.NoError(t, err)
+	assert.Equal(t, []int32{2, 1, 2, 1}, []int32{plan.Add, plan.Change, plan.Destroy, plan.Replace})
+	require.Len(t, plan.Changes, 4)
+
+	actions := make([]string, len(plan.Changes))
+	for i, c := range plan.Changes {
+		actions[i] = c.Action
+	}
+	assert.Equal(t, []string{"update", "create", "replace", "delete"}, actions)
+
+	assert.JSONEq(t, `{"address": "module.db.aws_db_instance.this", "module": "module.db", "type": "aws_db_instance", "name": "this",
+		"provider": "registry.terraform.io/hashicorp/aws",
+		"before": {"password": "(sensitive value)", "size": 20, "arn": "arn:1"},
+		"after": {"password": "(sensitive value)", "size": 12345678901234567890, "arn": "(known after apply)"}}`,
+		string(plan.Changes[0].Resource.Raw))
+	assert.JSONEq(t, `{"address": "aws_vpc.this", "type": "aws_vpc", "name": "this", "provider": "registry.terraform.io/hashicorp/aws",
+		"after": {"cidr_block": "10.0.0.0/16", "id": "(known after apply)", "tags": {"token": "(sensitive value)", "team": "a"},
+		"subnets": [{"id": "(known after apply)"}]}}`, string(plan.Changes[1].Resource.Raw))
+	assert.JSONEq(t, `{"address": "aws_eip.nat", "type": "aws_eip", "name": "nat", "provider": "registry.terraform.io/hashicorp/aws",
+		"before": {"id": "eip-1"}, "after": {"id": "(known after apply)"}}`, string(plan.Changes[2].Resource.Raw))
+	assert.JSONEq(t, `{"address": "aws_key_pair.old", "type": "aws_key_pair", "name": "old", "provider": "registry.terraform.io/hashicorp/aws",
+		"before": {"id": "key-1", "private": "(sensitive value)"}}`, string(plan.Changes[3].Resource.Raw))
+	for _, c := range plan.Changes {
+		assert.NotContains(t, string(c.Resource.Raw), "secret")
+		assert.NotContains(t, string(c.Resource.Raw), "pem")
+	}
+}
+
+func TestSummarizePlanLimitsChanges(t *testing.T) {
+	var changes []string
+	for i := 0; i < astrolabev1.MaxPlanChanges+5; i++ {
+		changes = append(changes, `{"address": "null_resource.r", "change": {"actions": ["create"], "after": {}}}`)
+	}
+	plan, err := summarizePlan([]byte(`{"resource_changes": [` + strings.Join(changes, ",") + `]}`))
+	require.NoError(t, err)
+	assert.Len(t, plan.Changes, astrolabev1.MaxPlanChanges)
+	assert.Equal(t, int32(astrolabev1.MaxPlanChanges+5), plan.Add)
+}
+
+func TestPlanApproval(t *testing.T) {
+	stack := &astrolabev1.Stack{Status: astrolabev1.StackStatus{Plan: &astrolabev1.StackPlan{
+		Hash: "abc", Summary: "Plan: 1 to add, 0 to change, 0 to destroy.",
+	}}}
+	approved, reason, msg := planApproval(stack)
+	assert.False(t, approved)
+	assert.Equal(t, "AwaitingApproval", reason)
+	assert.Contains(t, msg, "astrolabe.io/approved-plan=abc")
+
+	stack.Annotations = map[string]string{astrolabev1.ApprovedPlanAnnotation: "old"}
+	approved, reason, msg = planApproval(stack)
+	assert.False(t, approved)
+	assert.Equal(t, "PlanChanged", reason)
+	assert.Contains(t, msg, "Approved plan old does not match the saved plan abc")
+
+	stack.Annotations[astrolabev1.ApprovedPlanAnnotation] = "abc"
+	approved, reason, _ = planApproval(stack)
+	assert.True(t, approved)
+	assert.Equal(t, "Approved", reason)
+
+	stack.Status.Plan = nil
+	approved, reason, _ = planApproval(stack)
+	assert.False(t, approved)
+	assert.Equal(t, "PlanMissing", reason)
+}
+
+func TestClearPlanApproval(t *testing.T) {
+	ctx := context.Background()
+	stack := &astrolabev1.Stack{ObjectMeta: metav1.ObjectMeta{
+		Name: "demo", Namespace: "default",
+		Annotations: map[string]string{astrolabev1.ApprovedPlanAnnotation: "abc", "team": "platform"},
+	}}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(stack).WithStatusSubresource(stack).Build()
+	r := &StackReconciler{Client: c}
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(stack), stack))
+
+	stack.Status.Phase = "Applying"
+	require.NoError(t, r.clearPlanApproval(ctx, stack))
+	assert.Equal(t, map[string]string{"team": "platform"}, stack.Annotations)
+	assert.Equal(t, "Applying", stack.Status.Phase, "pending status changes are kept")
+	require.NoError(t, c.Status().Update(ctx, stack), "the resource version is current")
+
+	var got astrolabev1.Stack
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(stack), &got))
+	assert.NotContains(t, got.Annotations, astrolabev1.ApprovedPlanAnnotation)
+	approved, reason, _ := planApproval(&astrolabev1.Stack{ObjectMeta: got.ObjectMeta, Status: astrolabev1.StackStatus{Plan: &astrolabev1.StackPlan{Hash: "abc"}}})
+	assert.False(t, approved, "an identical later plan needs a new approval")
+	assert.Equal(t, "AwaitingApproval", reason)
+
+	require.NoError(t, r.clearPlanApproval(ctx, stack), "nothing to clear")
+}
+
+func TestStackConfigHash(t *testing.T) {
+	stack := astrolabev1.Stack{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo"},
+		Spec: astrolabev1.StackSpec{
+			BackendConfig: astrolabev1.BackendConfigSpec{Type: "local"},
+			Modules:       []astrolabev1.StackModuleRef{{Name: "vpc", Variables: apiextensionsv1.JSON{Raw: []byte(`{"name":"a"}`)}}},
+		},
+	}
+	modules := []astrolabev1.Module{moduleWithInputs()}
+	dir := t.TempDir()
+	require.NoError(t, writeStackConfig(dir, "", stack, modules))
+	first, err := stackConfigHash(dir, "")
+	require.NoError(t, err)
+
+	stack.Spec.Modules[0].Variables.Raw = []byte(`{"name":"b"}`)
+	require.NoError(t, writeStackConfig(dir, "", stack, modules))
+	second, err := stackConfigHash(dir, astrolabev1.RenderFormatHCL)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second)
+
+	_, err = stackConfigHash(dir, astrolabev1.RenderFormatJSON)
+	assert.Error(t, err, "the JSON files were never rendered")
+}
+
+func TestStackReconcileWaitsForPlanApproval(t *testing.T) {
+	mod := moduleWithInputs(astrolabev1.ModuleInput{Name: "name", Type: "string"})
+	stack := &astrolabev1.Stack{
+		ObjectMeta: metav1.ObjectMeta{Name: "approval-test", Namespace: "default", Generation: 3},
+		Spec: astrolabev1.StackSpec{
+			BackendConfig: astrolabev1.BackendConfigSpec{Type: "local"},
+			Modules:       []astrolabev1.StackModuleRef{{Name: "vpc", Variables: apiextensionsv1.JSON{Raw: []byte(`{"name":"demo"}`)}}},
+			Approval:      astrolabev1.ApprovalManual,
+		},
+	}
+
+	// A plan saved by an earlier reconcile for the same configuration
+	workspaces := workspace.New(t.TempDir())
+	workDir := workspaces.Dir(stack.Namespace, stack.Name, "")
+	require.NoError(t, os.MkdirAll(workDir, 0700))
+	require.NoError(t, writeStackConfig(workDir, "", *stack, []astrolabev1.Module{mod}))
+	configHash, err := stackConfigHash(workDir, "")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, planFileName), []byte("plan"), 0600))
+	stack.Status.Plan = &astrolabev1.StackPlan{Hash: "abc", ConfigHash: configHash, Summary: "Plan: 1 to add, 0 to change, 0 to destroy."}
+
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).
+		WithIndex(&astrolabev1.Stack{}, upstreamStackIndex, indexUpstreamStacks).
+		WithObjects(stack, &mod).WithStatusSubresource(stack, &mod).Build()
+	r := &StackReconciler{Client: c, Workspaces: workspaces}
+
+	key := types.NamespacedName{Name: stack.Name, Namespace: stack.Namespace}
+	res, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	assert.Equal(t, ctrl.Result{}, res)
+
+	var got astrolabev1.Stack
+	require.NoError(t, c.Get(context.Background(), key, &got))
+	assert.Equal(t, "AwaitingApproval", got.Status.Phase)
+	assert.Equal(t, "AwaitingApproval", got.Status.Status)
+	assert.False(t, got.Status.Ready)
+	cond := apimeta.FindStatusCondition(got.Status.Conditions, "PlanApproved")
+	require.NotNil(t, cond)
+	assert.Equal(t, metav1.ConditionFalse, cond.Status)
+	assert.Contains(t, cond.Message, "astrolabe.io/approved-plan=abc")
+	assert.FileExists(t, filepath.Join(workDir, planFileName), "the saved plan is kept for the approval")
+
+	// An approval for another plan is refused without running terraform
+	got.Annotations = map[string]string{astrolabev1.ApprovedPlanAnnotation: "def"}
+	require.NoError(t, c.Update(context.Background(), &got))
+	_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	require.NoError(t, c.Get(context.Background(), key, &got))
+	assert.Equal(t, "PlanChanged", got.Status.Status)
+
+	// Another operation holding the workspace is waited for
+	held, err := workspaces.Acquire(stack.Namespace, stack.Name, "", "destroy")
+	require.NoError(t, err)
+	defer held.Release()
+	res, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	assert.NotZero(t, res.RequeueAfter)
+	require.NoError(t, c.Get(context.Background(), key, &got))
+	assert.Equal(t, "WorkspaceLocked", got.Status.Status)
+	assert.Contains(t, got.Status.Summary, "destroy")
+}
@@ -0,0 +1,244 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// upstreamStackIndex indexes Stacks by the "namespace/name" of every Stack
+// whose outputs they consume, so a change upstream can requeue them.
+const upstreamStackIndex = "spec.modules.stackInputs.stackRef"
+
+// errStackReference explains why the outputs of other Stacks could not be
+// resolved. Reason is used as condition reason and Stack status.
+type errStackReference struct {
+	Reason  string
+	Message string
+}
+
+func (e *errStackReference) Error() string {
+	return e.Message
+}
+
+// stackInputValues holds, per index in spec.modules, the values of the
+// variables set from other Stacks' outputs.
+type stackInputValues map[int]map[string]json.RawMessage
+
+// upstreamStackKey returns the key of the Stack ref points to.
+func upstreamStackKey(stack *astrolabev1.Stack, ref astrolabev1.StackReference) types.NamespacedName {
+	ns := ref.Namespace
+	if ns == "" {
+		ns = stack.Namespace
+	}
+	return types.NamespacedName{Namespace: ns, Name: ref.Name}
+}
+
+// indexUpstreamStacks is the indexer function for upstreamStackIndex.
+func indexUpstreamStacks(obj client.Object) []string {
+	stack, ok := obj.(*astrolabev1.Stack)
+	if !ok {
+		return nil
+	}
+	keys := []string{}
+	seen := map[string]bool{}
+	for _, mod := range stack.Spec.Modules {
+		for _, in := range mod.StackInputs {
+			key := upstreamStackKey(stack, in.StackRef).String()
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+func hasStackInputs(stack *astrolabev1.Stack) bool {
+	for _, mod := range stack.Spec.Modules {
+		if len(mod.StackInputs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// downstreamStacks maps a Stack to the Stacks that consume its outputs.
+func (r *StackReconciler) downstreamStacks(ctx context.Context, obj client.Object) []reconcile.Request {
+	var stacks astrolabev1.StackList
+	key := types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}.String()
+	if err := r.List(ctx, &stacks, client.MatchingFields{upstreamStackIndex: key}); err != nil {
+		ctrl.Log.Info("Failed to list downstream stacks", "stack", key, "error", err)
+		return nil
+	}
+	requests := make([]reconcile.Request, len(stacks.Items))
+	for i, s := range stacks.Items {
+		requests[i] = reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&s)}
+	}
+	return requests
+}
+
+// resolveStackInputs fetches the upstream outputs the Stack's modules
+// reference. It fails while an upstream Stack is missing, not Ready, lacks
+// the output or does not share its outputs with the Stack's namespace, and
+// when the references form a cycle. The hash covers every resolved value.
+func (r *StackReconciler) resolveStackInputs(ctx context.Context, stack *astrolabev1.Stack) (stackInputValues, string, error) {
+	if err := r.checkStackReferenceCycle(ctx, stack); err != nil {
+		return nil, "", err
+	}
+	values := stackInputValues{}
+	upstreams := map[types.NamespacedName]map[string]json.RawMessage{}
+	for i, mod := range stack.Spec.Modules {
+		for _, in := range mod.StackInputs {
+			key := upstreamStackKey(stack, in.StackRef)
+			outputs, ok := upstreams[key]
+			if !ok {
+				var err error
+				if outputs, err = r.upstreamOutputs(ctx, stack, key); err != nil {
+					return nil, "", err
+				}
+				upstreams[key] = outputs
+			}
+			value, ok := outputs[in.Output]
+			if !ok {
+				return nil, "", &errStackReference{Reason: "UpstreamOutputMissing", Message: fmt.Sprintf("Stack %s has no output %q", key, in.Output)}
+			}
+			if values[i] == nil {
+				values[i] = map[string]json.RawMessage{}
+			}
+			values[i][in.ToVariable] = value
+		}
+	}
+	// encoding/json sorts map keys, so equal values always hash the same
+	raw, err := json.Marshal(values)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(values) == 0 {
+		return values, "", nil
+	}
+	return values, fmt.Sprintf("%x", sha256.Sum256(raw)), nil
+}
+
+func (r *StackReconciler) upstreamOutputs(ctx context.Context, stack *astrolabev1.Stack, key types.NamespacedName) (map[string]json.RawMessage, error) {
+	var upstream astrolabev1.Stack
+	if err := r.Get(ctx, key, &upstream); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, &errStackReference{Reason: "UpstreamNotFound", Message: fmt.Sprintf("Stack %s not found", key)}
+		}
+		return nil, err
+	}
+	if upstream.Namespace != stack.Namespace && !sharesOutputsWith(&upstream, stack.Namespace) {
+		return nil, &errStackReference{Reason: "ReferenceNotAllowed", Message: fmt.Sprintf("Stack %s does not share its outputs with namespace %s", key, stack.Namespace)}
+	}
+	if !upstream.Status.Ready || upstream.Status.Phase != "Applied" || upstream.Status.Status != "Success" {
+		return nil, &errStackReference{Reason: "UpstreamNotReady", Message: fmt.Sprintf("Waiting for Stack %s to be applied", key)}
+	}
+	outputs := map[string]json.RawMessage{}
+	if len(upstream.Status.Outputs.Raw) > 0 {
+		if err := json.Unmarshal(upstream.Status.Outputs.Raw, &outputs); err != nil {
+			return nil, fmt.Errorf("invalid outputs of Stack %s: %w", key, err)
+		}
+	}
+	return outputs, nil
+}
+
+func sharesOutputsWith(stack *astrolabev1.Stack, namespace string) bool {
+	for _, ns := range stack.Spec.ShareOutputsWith {
+		if ns == "*" || ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// checkStackReferenceCycle follows stackInputs upstream from stack and fails
+// if they lead back to it. Stacks that cannot be fetched end the walk; they
+// are reported by resolveStackInputs.
+func (r *StackReconciler) checkStackReferenceCycle(ctx context.Context, stack *astrolabev1.Stack) error {
+	self := client.ObjectKeyFromObject(stack)
+	visited := map[types.NamespacedName]bool{}
+	var walk func(s *astrolabev1.Stack, path []string) error
+	walk = func(s *astrolabev1.Stack, path []string) error {
+		for _, key := range indexUpstreamStacks(s) {
+			ns, name, _ := strings.Cut(key, "/")
+			next := types.NamespacedName{Namespace: ns, Name: name}
+			if next == self {
+				return &errStackReference{Reason: "ReferenceCycle", Message: "Stack reference cycle: " + strings.Join(append(path, self.String()), " -> ")}
+			}
+			if visited[next] {
+				continue
+			}
+			visited[next] = true
+			var upstream astrolabev1.Stack
+			if err := r.Get(ctx, next, &upstream); err != nil {
+				continue
+			}
+			if err := walk(&upstream, append(path, next.String())); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(stack, []string{self.String()})
+}
+
+// injectStackInputs adds the resolved upstream values to a copy of the
+// Stack's module variables. A variable may not be set both ways.
+func injectStackInputs(stack *astrolabev1.Stack, values stackInputValues) field.ErrorList {
+	var errs field.ErrorList
+	modulesPath := field.NewPath("spec", "modules")
+	for i := range stack.Spec.Modules {
+		injected, ok := values[i]
+		if !ok {
+			continue
+		}
+		stackMod := &stack.Spec.Modules[i]
+		variables := map[string]json.RawMessage{}
+		if len(stackMod.Variables.Raw) > 0 {
+			if err := json.Unmarshal(stackMod.Variables.Raw, &variables); err != nil {
+				// Reported by validateModuleVariables
+				continue
+			}
+		}
+		for j, in := range stackMod.StackInputs {
+			if _, ok := variables[in.ToVariable]; ok {
+				errs = append(errs, field.Invalid(modulesPath.Index(i).Child("stackInputs").Index(j).Child("toVariable"), in.ToVariable,
+					"is also set in variables or by another stack input"))
+				continue
+			}
+			variables[in.ToVariable] = injected[in.ToVariable]
+		}
+		raw, err := json.Marshal(variables)
+		if err != nil {
+			errs = append(errs, field.InternalError(modulesPath.Index(i).Child("variables"), err))
+			continue
+		}
+		stackMod.Variables.Raw = raw
+	}
+	return errs
+}
+
+// stackInputsChanged reports whether the upstream outputs of a Stack in a
+// terminal state differ from those of its last apply.
+func (r *StackReconciler) stackInputsChanged(ctx context.Context, stack *astrolabev1.Stack) bool {
+	if !hasStackInputs(stack) {
+		return false
+	}
+	_, hash, err := r.resolveStackInputs(ctx, stack)
+	// Wait for the upstream Stack to settle; its next change requeues this one
+	if err != nil {
+		return false
+	}
+	return hash != stack.Status.UpstreamOutputsHash
+}
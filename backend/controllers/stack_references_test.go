@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func stackReferencing(name, namespace string, refs ...astrolabev1.StackOutputReference) *astrolabev1.Stack {
+	return &astrolabev1.Stack{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: astrolabev1.StackSpec{Modules: []astrolabev1.StackModuleRef{
+			{Name: "app", Variables: apiextensionsv1.JSON{Raw: []byte(`{"name":"demo"}`)}, StackInputs: refs},
+		}},
+	}
+}
+
+func appliedStack(name, namespace, outputs string) *astrolabev1.Stack {
+	stack := stackReferencing(name, namespace)
+	stack.Status = astrolabev1.StackStatus{
+		Phase: "Applied", Status: "Success", Ready: true,
+		Outputs: apiextensionsv1.JSON{Raw: []byte(outputs)},
+	}
+	return stack
+}
+
+func newReferenceReconciler(t *testing.T, objs ...client.Object) *StackReconciler {
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).
+		WithIndex(&astrolabev1.Stack{}, upstreamStackIndex, indexUpstreamStacks).
+		WithObjects(objs...).Build()
+	return &StackReconciler{Client: c, Scheme: c.Scheme()}
+}
+
+func stackReferenceReason(t *testing.T, err error) string {
+	t.Helper()
+	var refErr *errStackReference
+	require.True(t, errors.As(err, &refErr), "got %v", err)
+	return refErr.Reason
+}
+
+func TestResolveStackInputs(t *testing.T) {
+	ref := astrolabev1.StackOutputReference{StackRef: astrolabev1.StackReference{Name: "network"}, Output: "vpc_id", ToVariable: "vpc_id"}
+	downstream := stackReferencing("apps", "default", ref)
+
+	r := newReferenceReconciler(t)
+	_, _, err := r.resolveStackInputs(context.Background(), downstream)
+	assert.Equal(t, "UpstreamNotFound", stackReferenceReason(t, err))
+
+	pending := stackReferencing("network", "default")
+	pending.Status.Phase = "Planning"
+	r = newReferenceReconciler(t, pending)
+	_, _, err = r.resolveStackInputs(context.Background(), downstream)
+	assert.Equal(t, "UpstreamNotReady", stackReferenceReason(t, err))
+
+	r = newReferenceReconciler(t, appliedStack("network", "default", `{"cidr":"10.0.0.0/16"}`))
+	_, _, err = r.resolveStackInputs(context.Background(), downstream)
+	assert.Equal(t, "UpstreamOutputMissing", stackReferenceReason(t, err))
+
+	r = newReferenceReconciler(t, appliedStack("network", "default", `{"vpc_id":"vpc-123"}`))
+	values, hash, err := r.resolveStackInputs(context.Background(), downstream)
+	require.NoError(t, err)
+	assert.Equal(t, `"vpc-123"`, string(values[0]["vpc_id"]))
+	assert.NotEmpty(t, hash)
+
+	r = newReferenceReconciler(t, appliedStack("network", "default", `{"vpc_id":"vpc-456"}`))
+	_, changed, err := r.resolveStackInputs(context.Background(), downstream)
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, changed, "a new upstream value changes the hash")
+}
+
+func TestResolveStackInputsAcrossNamespaces(t *testing.T) {
+	ref := astrolabev1.StackOutputReference{StackRef: astrolabev1.StackReference{Name: "network", Namespace: "infra"}, Output: "vpc_id", ToVariable: "vpc_id"}
+	downstream := stackReferencing("apps", "default", ref)
+	upstream := appliedStack("network", "infra", `{"vpc_id":"vpc-123"}`)
+
+	r := newReferenceReconciler(t, upstream)
+	_, _, err := r.resolveStackInputs(context.Background(), downstream)
+	assert.Equal(t, "ReferenceNotAllowed", stackReferenceReason(t, err))
+
+	upstream.Spec.ShareOutputsWith = []string{"default"}
+	r = newReferenceReconciler(t, upstream)
+	_, _, err = r.resolveStackInputs(context.Background(), downstream)
+	assert.NoError(t, err)
+}
+
+func TestResolveStackInputsDetectsCycles(t *testing.T) {
+	a := stackReferencing("a", "default", astrolabev1.StackOutputReference{StackRef: astrolabev1.StackReference{Name: "b"}, Output: "x", ToVariable: "x"})
+	b := stackReferencing("b", "default", astrolabev1.StackOutputReference{StackRef: astrolabev1.StackReference{Name: "c"}, Output: "x", ToVariable: "x"})
+	c := stackReferencing("c", "default", astrolabev1.StackOutputReference{StackRef: astrolabev1.StackReference{Name: "a"}, Output: "x", ToVariable: "x"})
+	r := newReferenceReconciler(t, a, b, c)
+
+	_, _, err := r.resolveStackInputs(context.Background(), a)
+	assert.Equal(t, "ReferenceCycle", stackReferenceReason(t, err))
+	assert.EqualError(t, err, "Stack reference cycle: default/a -> default/b -> default/c -> default/a")
+}
+
+func TestInjectStackInputs(t *testing.T) {
+	stack := stackReferencing("apps", "default",
+		astrolabev1.StackOutputReference{StackRef: astrolabev1.StackReference{Name: "network"}, Output: "vpc_id", ToVariable: "vpc_id"})
+	values := stackInputValues{0: {"vpc_id": []byte(`"vpc-123"`)}}
+
+	effective := stack.DeepCopy()
+	assert.Empty(t, injectStackInputs(effective, values))
+	assert.JSONEq(t, `{"name":"demo","vpc_id":"vpc-123"}`, string(effective.Spec.Modules[0].Variables.Raw))
+	assert.JSONEq(t, `{"name":"demo"}`, string(stack.Spec.Modules[0].Variables.Raw), "the stored Stack is left alone")
+
+	stack.Spec.Modules[0].StackInputs[0].ToVariable = "name"
+	errs := injectStackInputs(stack.DeepCopy(), stackInputValues{0: {"name": []byte(`"other"`)}})
+	require.Len(t, errs, 1)
+	assert.Equal(t, "spec.modules[0].stackInputs[0].toVariable", errs[0].Field)
+}
+
+func TestDownstreamStacks(t *testing.T) {
+	upstream := appliedStack("network", "infra", `{"vpc_id":"vpc-123"}`)
+	ref := astrolabev1.StackOutputReference{StackRef: astrolabev1.StackReference{Name: "network", Namespace: "infra"}, Output: "vpc_id", ToVariable: "vpc_id"}
+	r := newReferenceReconciler(t, upstream,
+		stackReferencing("apps", "default", ref),
+		stackReferencing("jobs", "batch", ref),
+		stackReferencing("unrelated", "default"),
+	)
+
+	requests := r.downstreamStacks(context.Background(), upstream)
+	var keys []types.NamespacedName
+	for _, req := range requests {
+		keys = append(keys, req.NamespacedName)
+	}
+	assert.ElementsMatch(t, []types.NamespacedName{{Namespace: "default", Name: "apps"}, {Namespace: "batch", Name: "jobs"}}, keys)
+}
+
+func TestStackInputsChanged(t *testing.T) {
+	downstream := stackReferencing("apps", "default",
+		astrolabev1.StackOutputReference{StackRef: astrolabev1.StackReference{Name: "network"}, Output: "vpc_id", ToVariable: "vpc_id"})
+	r := newReferenceReconciler(t, appliedStack("network", "default", `{"vpc_id":"vpc-123"}`))
+	_, hash, err := r.resolveStackInputs(context.Background(), downstream)
+	require.NoError(t, err)
+
+	downstream.Status.UpstreamOutputsHash = hash
+	assert.False(t, r.stackInputsChanged(context.Background(), downstream))
+	downstream.Status.UpstreamOutputsHash = "stale"
+	assert.True(t, r.stackInputsChanged(context.Background(), downstream))
+	assert.False(t, r.stackInputsChanged(context.Background(), stackReferencing("plain", "default")))
+}
+
+func TestStackReconcileWaitsForUpstream(t *testing.T) {
+	mod := moduleWithInputs(astrolabev1.ModuleInput{Name: "vpc_id", Type: "string", Required: true})
+	downstream := stackReferencing("apps", "default",
+		astrolabev1.StackOutputReference{StackRef: astrolabev1.StackReference{Name: "network"}, Output: "vpc_id", ToVariable: "vpc_id"})
+	downstream.Spec.Modules[0].Name = "vpc"
+	downstream.Spec.Modules[0].Variables.Raw = nil
+	upstream := stackReferencing("network", "default")
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).
+		WithIndex(&astrolabev1.Stack{}, upstreamStackIndex, indexUpstreamStacks).
+		WithObjects(downstream, upstream, &mod).WithStatusSubresource(downstream, upstream, &mod).Build()
+	r := &StackReconciler{Client: c}
+
+	res, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "apps", Namespace: "default"}})
+	require.NoError(t, err)
+	assert.NotZero(t, res.RequeueAfter)
+
+	var got astrolabev1.Stack
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "apps", Namespace: "default"}, &got))
+	assert.Equal(t, "UpstreamNotReady", got.Status.Status)
+	cond := apimeta.FindStatusCondition(got.Status.Conditions, "UpstreamOutputsResolved")
+	require.NotNil(t, cond)
+	assert.Equal(t, metav1.ConditionFalse, cond.Status)
+	assert.Equal(t, "UpstreamNotReady", cond.Reason)
+	assert.Nil(t, apimeta.FindStatusCondition(got.Status.Conditions, "VariablesValid"), "variables are validated once upstream outputs are known")
+}
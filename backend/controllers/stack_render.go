@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+// The render functions generate the root configuration of a Stack, in native
+// syntax with hclwrite or in JSON syntax, so values are escaped and typed
+// correctly and attributes are written in sorted order: the same Stack
+// always renders byte-identical files.
+
+// stackConfigFiles lists, per render format, the files of the root
+// configuration and how each is rendered.
+var stackConfigFiles = map[string][]struct {
+	name   string
+	render func(stack astrolabev1.Stack, modules []astrolabev1.Module) (string, error)
+}{
+	astrolabev1.RenderFormatHCL: {
+		{"backend.tf", func(stack astrolabev1.Stack, _ []astrolabev1.Module) (string, error) {
+			backend, err := stateBackend(stack)
+			if err != nil {
+				return "", err
+			}
+			return renderBackendTf(backend, stack.Name)
+		}},
+		{"main.tf", renderMainTf},
+		{"outputs.tf", func(_ astrolabev1.Stack, modules []astrolabev1.Module) (string, error) {
+			return renderOutputsTf(modules)
+		}},
+	},
+	astrolabev1.RenderFormatJSON: {
+		{"backend.tf.json", func(stack astrolabev1.Stack, _ []astrolabev1.Module) (string, error) {
+			backend, err := stateBackend(stack)
+			if err != nil {
+				return "", err
+			}
+			return renderBackendTfJSON(backend, stack.Name)
+		}},
+		{"main.tf.json", renderMainTfJSON},
+		{"outputs.tf.json", func(_ astrolabev1.Stack, modules []astrolabev1.Module) (string, error) {
+			return renderOutputsTfJSON(modules)
+		}},
+	},
+}
+
+// writeStackConfig renders the root configuration of a Stack into workDir in
+// the given format, removing files another format left behind so Terraform
+// never sees a block declared twice.
+func writeStackConfig(workDir, format string, stack astrolabev1.Stack, modules []astrolabev1.Module) error {
+	if format == "" {
+		format = astrolabev1.RenderFormatHCL
+	}
+	files, ok := stackConfigFiles[format]
+	if !ok {
+		return fmt.Errorf("unsupported render format %q", format)
+	}
+	for other, otherFiles := range stackConfigFiles {
+		if other == format {
+			continue
+		}
+		for _, f := range otherFiles {
+			if err := os.Remove(filepath.Join(workDir, f.name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	for _, f := range files {
+		content, err := f.render(stack, modules)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.name, err)
+		}
+		if err := writeFile(filepath.Join(workDir, f.name), content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderBackendTf renders the terraform block configuring the state backend.
+func renderBackendTf(backend astrolabev1.BackendConfigSpec, stackName string) (string, error) {
+	settings, err := jsonObjectToCty(backend.Settings.Raw)
+	if err != nil {
+		return "", fmt.Errorf("backend settings: %w", err)
+	}
+	// For s3 and azurerm backends, ensure 'key' is set to 'astrolabe/<stackName>.tfstate' if not present
+	if backend.Type == "s3" || backend.Type == "azurerm" {
+		if _, ok := settings["key"]; !ok {
+			settings["key"] = cty.StringVal(fmt.Sprintf("astrolabe/%s.tfstate", stackName))
+		}
+	}
+	f := hclwrite.NewEmptyFile()
+	body := f.Body().AppendNewBlock("terraform", nil).Body().AppendNewBlock("backend", []string{backend.Type}).Body()
+	if err := setAttributes(body, settings); err != nil {
+		return "", fmt.Errorf("backend settings: %w", err)
+	}
+	return string(hclwrite.Format(f.Bytes())), nil
+}
+
+// renderMainTf renders a module block per Stack module, passing its variables.
+// modules[i] is the Module for stack.Spec.Modules[i].
+func renderMainTf(stack astrolabev1.Stack, modules []astrolabev1.Module) (string, error) {
+	f := hclwrite.NewEmptyFile()
+	for i, mod := range modules {
+		stackMod := stack.Spec.Modules[i]
+		if i > 0 {
+			f.Body().AppendNewline()
+		}
+		body := f.Body().AppendNewBlock("module", []string{stackMod.Name}).Body()
+		// Render source from mod.Spec.Source, including any "//subdir" path
+		body.SetAttributeValue("source", cty.StringVal(moduleSourceAddress(mod)))
+		// version is only valid for registry sources; pin the version the Module controller parsed
+		if mod.Spec.Source.Type == "registry" {
+			version := mod.Status.ResolvedVersion
+			if version == "" {
+				version = mod.Spec.Source.Version
+			}
+			if version != "" {
+				body.SetAttributeValue("version", cty.StringVal(version))
+			}
+		}
+		variables, err := jsonObjectToCty(stackMod.Variables.Raw)
+		if err != nil {
+			return "", fmt.Errorf("variables of module %s: %w", stackMod.Name, err)
+		}
+		if err := setAttributes(body, variables); err != nil {
+			return "", fmt.Errorf("variables of module %s: %w", stackMod.Name, err)
+		}
+		links, err := sortedLinkedInputs(stackMod)
+		if err != nil {
+			return "", err
+		}
+		for _, link := range links {
+			body.SetAttributeTraversal(link.ToVariable, hcl.Traversal{
+				hcl.TraverseRoot{Name: "module"},
+				hcl.TraverseAttr{Name: link.FromModuleID},
+				hcl.TraverseAttr{Name: link.FromOutput},
+			})
+		}
+		if len(stackMod.DependsOn) > 0 {
+			deps := make([]hclwrite.Tokens, len(stackMod.DependsOn))
+			for j, dep := range stackMod.DependsOn {
+				deps[j] = hclwrite.TokensForTraversal(hcl.Traversal{hcl.TraverseRoot{Name: "module"}, hcl.TraverseAttr{Name: dep}})
+			}
+			body.SetAttributeRaw("depends_on", hclwrite.TokensForTuple(deps))
+		}
+	}
+	return string(hclwrite.Format(f.Bytes())), nil
+}
+
+// renderOutputsTf re-exports every module output from the root module.
+func renderOutputsTf(modules []astrolabev1.Module) (string, error) {
+	f := hclwrite.NewEmptyFile()
+	first := true
+	seen := map[string]bool{}
+	for _, mod := range modules {
+		for _, output := range mod.Status.Outputs {
+			if seen[output.Name] {
+				return "", fmt.Errorf("output %q is declared by more than one module", output.Name)
+			}
+			seen[output.Name] = true
+			if !first {
+				f.Body().AppendNewline()
+			}
+			first = false
+			body := f.Body().AppendNewBlock("output", []string{output.Name}).Body()
+			body.SetAttributeTraversal("value", hcl.Traversal{
+				hcl.TraverseRoot{Name: "module"},
+				hcl.TraverseAttr{Name: mod.Name},
+				hcl.TraverseAttr{Name: output.Name},
+			})
+			// Terraform refuses to expose a sensitive module output from an unmarked root output
+			if output.Sensitive {
+				body.SetAttributeValue("sensitive", cty.True)
+			}
+		}
+	}
+	return string(hclwrite.Format(f.Bytes())), nil
+}
+
+// jsonObjectToCty converts a JSON object to cty values keyed by attribute
+// name. Nested objects and arrays become HCL object and tuple values, so
+// mixed element types and numbers of any precision survive unchanged.
+func jsonObjectToCty(raw []byte) (map[string]cty.Value, error) {
+	attrs := map[string]cty.Value{}
+	if len(raw) == 0 {
+		return attrs, nil
+	}
+	ty, err := ctyjson.ImpliedType(raw)
+	if err != nil {
+		return nil, err
+	}
+	if !ty.IsObjectType() {
+		return nil, fmt.Errorf("must be a JSON object, not %s", ty.FriendlyName())
+	}
+	val, err := ctyjson.Unmarshal(raw, ty)
+	if err != nil {
+		return nil, err
+	}
+	for name, v := range val.AsValueMap() {
+		attrs[name] = v
+	}
+	return attrs, nil
+}
+
+// sortedLinkedInputs returns the linked inputs of a Stack module ordered by
+// variable name, checking that every name can be written as an attribute.
+// Terraform orders the modules itself from the module.<name>.<output> references.
+func sortedLinkedInputs(stackMod astrolabev1.StackModuleRef) ([]astrolabev1.LinkedInput, error) {
+	links := append([]astrolabev1.LinkedInput(nil), stackMod.LinkedInputs...)
+	for _, link := range links {
+		for _, name := range []string{link.ToVariable, link.FromModuleID, link.FromOutput} {
+			if !hclsyntax.ValidIdentifier(name) {
+				return nil, fmt.Errorf("linked inputs of module %s: %q is not a valid HCL identifier", stackMod.Name, name)
+			}
+		}
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].ToVariable < links[j].ToVariable })
+	return links, nil
+}
+
+// setAttributes writes attrs into body in sorted order.
+func setAttributes(body *hclwrite.Body, attrs map[string]cty.Value) error {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		if !hclsyntax.ValidIdentifier(name) {
+			return fmt.Errorf("%q is not a valid HCL attribute name", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		body.SetAttributeValue(name, attrs[name])
+	}
+	return nil
+}
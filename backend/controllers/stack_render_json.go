@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+)
+
+// renderBackendTfJSON is renderBackendTf in Terraform's JSON syntax. Backend
+// blocks are not evaluated as expressions, so settings are written verbatim.
+func renderBackendTfJSON(backend astrolabev1.BackendConfigSpec, stackName string) (string, error) {
+	settings, err := decodeJSONObject(backend.Settings.Raw)
+	if err != nil {
+		return "", fmt.Errorf("backend settings: %w", err)
+	}
+	if backend.Type == "s3" || backend.Type == "azurerm" {
+		if _, ok := settings["key"]; !ok {
+			settings["key"] = fmt.Sprintf("astrolabe/%s.tfstate", stackName)
+		}
+	}
+	return marshalTfJSON(map[string]interface{}{
+		"terraform": map[string]interface{}{
+			"backend": map[string]interface{}{backend.Type: settings},
+		},
+	})
+}
+
+// renderMainTfJSON is renderMainTf in Terraform's JSON syntax.
+func renderMainTfJSON(stack astrolabev1.Stack, modules []astrolabev1.Module) (string, error) {
+	blocks := map[string]interface{}{}
+	for i, mod := range modules {
+		stackMod := stack.Spec.Modules[i]
+		block, err := decodeJSONObject(stackMod.Variables.Raw)
+		if err != nil {
+			return "", fmt.Errorf("variables of module %s: %w", stackMod.Name, err)
+		}
+		for name, val := range block {
+			if !hclsyntax.ValidIdentifier(name) {
+				return "", fmt.Errorf("variables of module %s: %q is not a valid HCL attribute name", stackMod.Name, name)
+			}
+			// Top-level names are argument names, not templates
+			block[name] = escapeTemplates(val)
+		}
+		links, err := sortedLinkedInputs(stackMod)
+		if err != nil {
+			return "", err
+		}
+		for _, link := range links {
+			block[link.ToVariable] = "${module." + link.FromModuleID + "." + link.FromOutput + "}"
+		}
+		block["source"] = moduleSourceAddress(mod)
+		if mod.Spec.Source.Type == "registry" {
+			version := mod.Status.ResolvedVersion
+			if version == "" {
+				version = mod.Spec.Source.Version
+			}
+			if version != "" {
+				block["version"] = version
+			}
+		}
+		if len(stackMod.DependsOn) > 0 {
+			deps := make([]string, len(stackMod.DependsOn))
+			for j, dep := range stackMod.DependsOn {
+				deps[j] = "module." + dep
+			}
+			block["depends_on"] = deps
+		}
+		blocks[stackMod.Name] = block
+	}
+	if len(blocks) == 0 {
+		return marshalTfJSON(map[string]interface{}{})
+	}
+	return marshalTfJSON(map[string]interface{}{"module": blocks})
+}
+
+// renderOutputsTfJSON is renderOutputsTf in Terraform's JSON syntax.
+func renderOutputsTfJSON(modules []astrolabev1.Module) (string, error) {
+	outputs := map[string]interface{}{}
+	for _, mod := range modules {
+		for _, output := range mod.Status.Outputs {
+			// A JSON object cannot hold the duplicate Terraform would reject
+			if _, dup := outputs[output.Name]; dup {
+				return "", fmt.Errorf("output %q is declared by more than one module", output.Name)
+			}
+			block := map[string]interface{}{"value": "${module." + mod.Name + "." + output.Name + "}"}
+			if output.Sensitive {
+				block["sensitive"] = true
+			}
+			outputs[output.Name] = block
+		}
+	}
+	if len(outputs) == 0 {
+		return marshalTfJSON(map[string]interface{}{})
+	}
+	return marshalTfJSON(map[string]interface{}{"output": outputs})
+}
+
+// decodeJSONObject decodes a JSON object, keeping numbers exact.
+func decodeJSONObject(raw []byte) (map[string]interface{}, error) {
+	if len(raw) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("must be a JSON object, not %s", jsonTypeName(v))
+	}
+	return obj, nil
+}
+
+// escapeTemplates escapes every string and key below v as a literal:
+// Terraform reads the strings of JSON syntax as templates wherever it
+// evaluates expressions, so "${" and "%{" would otherwise be interpolated.
+
+func escapeTemplates(v interface{}) interface{} {
+	switch val := v.(type) {
+	case string:
+		return escapeTemplate(val)
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = escapeTemplates(item)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			out[escapeTemplate(k)] = escapeTemplates(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+func escapeTemplate(s string) string {
+	return strings.NewReplacer("${", "$${", "%{", "%%{").Replace(s)
+}
+
+func jsonTypeName(v interface{}) string {
+	switch v.(type) {
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case bool:
+		return "bool"
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// marshalTfJSON encodes v with sorted keys and two-space indentation.
+func marshalTfJSON(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
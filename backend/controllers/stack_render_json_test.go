@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func renderFixture() (astrolabev1.Stack, []astrolabev1.Module) {
+	stack := astrolabev1.Stack{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default"},
+		Spec: astrolabev1.StackSpec{
+			BackendConfig: astrolabev1.BackendConfigSpec{
+				Type:     "s3",
+				Settings: apiextensionsv1.JSON{Raw: []byte(`{"bucket": "state", "encrypt": true}`)},
+			},
+			Modules: []astrolabev1.StackModuleRef{
+				{Name: "vpc", Variables: apiextensionsv1.JSON{Raw: []byte(`{
+					"name": "demo ${not.interpolated} %{ if x }",
+					"azs": ["us-east-1a", "us-east-1b"],
+					"counts": [1, 2.5, true],
+					"big": 12345678901234567890,
+					"tags": {"Team": "<platform>", "${key}": "v"}
+				}`)}},
+				{Name: "eks", DependsOn: []string{"vpc"}, LinkedInputs: []astrolabev1.LinkedInput{
+					{FromModuleID: "vpc", FromOutput: "private_subnets", ToVariable: "subnet_ids"},
+				}},
+			},
+		},
+	}
+	modules := []astrolabev1.Module{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "vpc"},
+			Spec: astrolabev1.ModuleSpec{Source: astrolabev1.ModuleSource{
+				Type: "registry", URL: "terraform-aws-modules/vpc/aws", Version: "~> 5.0",
+			}},
+			Status: astrolabev1.ModuleStatus{
+				ResolvedVersion: "5.1.2",
+				Outputs:         []astrolabev1.ModuleOutput{{Name: "vpc_id"}, {Name: "private_subnets"}},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "eks"},
+			Spec: astrolabev1.ModuleSpec{Source: astrolabev1.ModuleSource{
+				Type: "git", URL: "https://example.com/eks.git", Version: "v1.0.0",
+			}},
+			Status: astrolabev1.ModuleStatus{Outputs: []astrolabev1.ModuleOutput{{Name: "kubeconfig", Sensitive: true}}},
+		},
+	}
+	return stack, modules
+}
+
+func TestWriteStackConfigJSONGolden(t *testing.T) {
+	stack, modules := renderFixture()
+	dir := t.TempDir()
+	require.NoError(t, writeStackConfig(dir, astrolabev1.RenderFormatJSON, stack, modules))
+
+	for _, name := range []string{"backend.tf.json", "main.tf.json", "outputs.tf.json"} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		golden := filepath.Join("testdata", "render-json", name)
+		if *updateGolden {
+			require.NoError(t, os.MkdirAll(filepath.Dir(golden), 0755))
+			require.NoError(t, os.WriteFile(golden, got, 0644))
+		}
+		want, err := os.ReadFile(golden)
+		require.NoError(t, err)
+		assert.Equal(t, string(want), string(got), name)
+	}
+}
+
+func TestWriteStackConfigSwitchesFormat(t *testing.T) {
+	stack, modules := renderFixture()
+	dir := t.TempDir()
+	require.NoError(t, writeStackConfig(dir, astrolabev1.RenderFormatJSON, stack, modules))
+	require.NoError(t, writeStackConfig(dir, "", stack, modules))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.ElementsMatch(t, []string{"backend.tf", "main.tf", "outputs.tf"}, names)
+
+	assert.ErrorContains(t, writeStackConfig(dir, "yaml", stack, modules), `unsupported render format "yaml"`)
+}
+
+func TestRenderOutputsTfJSONRejectsDuplicates(t *testing.T) {
+	out := []astrolabev1.ModuleOutput{{Name: "id"}}
+	_, err := renderOutputsTfJSON([]astrolabev1.Module{
+		{ObjectMeta: metav1.ObjectMeta{Name: "a"}, Status: astrolabev1.ModuleStatus{Outputs: out}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "b"}, Status: astrolabev1.ModuleStatus{Outputs: out}},
+	})
+	assert.ErrorContains(t, err, `output "id" is declared by more than one module`)
+}
+
+func TestRenderBackendTfJSONKeepsSettingsVerbatim(t *testing.T) {
+	out, err := renderBackendTfJSON(astrolabev1.BackendConfigSpec{
+		Type:     "http",
+		Settings: apiextensionsv1.JSON{Raw: []byte(`{"address": "https://state.example.com/${stack}", "headers": {"%{x}": "y"}}`)},
+	}, "demo")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"terraform": {"backend": {"http": {
+		"address": "https://state.example.com/${stack}",
+		"headers": {"%{x}": "y"}
+	}}}}`, out)
+}
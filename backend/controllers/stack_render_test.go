@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"testing"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRenderMainTf(t *testing.T) {
+	stack := astrolabev1.Stack{Spec: astrolabev1.StackSpec{Modules: []astrolabev1.StackModuleRef{
+		{Name: "vpc", Variables: apiextensionsv1.JSON{Raw: []byte(`{
+			"name": "say \"hi\" ${var.x}",
+			"azs": ["us-east-1a", "us-east-1b"],
+			"counts": [1, 2.5, true],
+			"enable_nat": false,
+			"big": 12345678901234567890,
+			"tags": {"Team": "platform", "app.kubernetes.io/name": "vpc", "nested": {"list": [], "map": {}}},
+			"nothing": null
+		}`)}},
+		{Name: "eks", DependsOn: []string{"vpc"}, LinkedInputs: []astrolabev1.LinkedInput{
+			{FromModuleID: "vpc", FromOutput: "vpc_id", ToVariable: "vpc_id"},
+			{FromModuleID: "vpc", FromOutput: "private_subnets", ToVariable: "subnet_ids"},
+		}},
+	}}}
+	modules := []astrolabev1.Module{
+		{ObjectMeta: metav1.ObjectMeta{Name: "vpc"}, Spec: astrolabev1.ModuleSpec{Source: astrolabev1.ModuleSource{
+			Type: "registry", URL: "terraform-aws-modules/vpc/aws", Version: "~> 5.0",
+		}}, Status: astrolabev1.ModuleStatus{ResolvedVersion: "5.1.2"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "eks"}, Spec: astrolabev1.ModuleSpec{Source: astrolabev1.ModuleSource{
+			Type: "git", URL: "https://example.com/eks.git", Version: "v1.0.0",
+		}}},
+	}
+
+	out, err := renderMainTf(stack, modules)
+	require.NoError(t, err)
+	assert.Equal(t, `module "vpc" {
+  source     = "terraform-aws-modules/vpc/aws"
+  version    = "5.1.2"
+  azs        = ["us-east-1a", "us-east-1b"]
+  big        = 12345678901234567890
+  counts     = [1, 2.5, true]
+  enable_nat = false
+  name       = "say \"hi\" $${var.x}"
+  nothing    = null
+  tags = {
+    Team                     = "platform"
+    "app.kubernetes.io/name" = "vpc"
+    nested = {
+      list = []
+      map  = {}
+    }
+  }
+}
+
+module "eks" {
+  source     = "git::https://example.com/eks.git?ref=v1.0.0"
+  subnet_ids = module.vpc.private_subnets
+  vpc_id     = module.vpc.vpc_id
+  depends_on = [module.vpc]
+}
+`, out)
+
+	again, err := renderMainTf(stack, modules)
+	require.NoError(t, err)
+	assert.Equal(t, out, again, "rendering is deterministic")
+
+	stack.Spec.Modules[1].Variables.Raw = []byte(`{"bad name": 1}`)
+	_, err = renderMainTf(stack, modules)
+	assert.ErrorContains(t, err, `"bad name" is not a valid HCL attribute name`)
+	stack.Spec.Modules[1].Variables.Raw = []byte(`["a"]`)
+	_, err = renderMainTf(stack, modules)
+	assert.ErrorContains(t, err, "must be a JSON object")
+}
+
+func TestRenderBackendTf(t *testing.T) {
+	out, err := renderBackendTf(astrolabev1.BackendConfigSpec{
+		Type:     "s3",
+		Settings: apiextensionsv1.JSON{Raw: []byte(`{"bucket": "state", "encrypt": true, "assume_role": {"role_arn": "arn:aws:iam::1:role/x"}}`)},
+	}, "demo")
+	require.NoError(t, err)
+	assert.Equal(t, `terraform {
+  backend "s3" {
+    assume_role = {
+      role_arn = "arn:aws:iam::1:role/x"
+    }
+    bucket  = "state"
+    encrypt = true
+    key     = "astrolabe/demo.tfstate"
+  }
+}
+`, out)
+
+	out, err = renderBackendTf(astrolabev1.BackendConfigSpec{Type: "local"}, "demo")
+	require.NoError(t, err)
+	assert.Equal(t, "terraform {\n  backend \"local\" {\n  }\n}\n", out)
+}
+
+func TestRenderOutputsTf(t *testing.T) {
+	out, err := renderOutputsTf([]astrolabev1.Module{{
+		ObjectMeta: metav1.ObjectMeta{Name: "vpc"},
+		Status: astrolabev1.ModuleStatus{Outputs: []astrolabev1.ModuleOutput{
+			{Name: "vpc_id"},
+			{Name: "secret", Sensitive: true},
+		}},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, `output "vpc_id" {
+  value = module.vpc.vpc_id
+}
+
+output "secret" {
+  value     = module.vpc.secret
+  sensitive = true
+}
+`, out)
+}
+
+func TestRenderOutputsTfRejectsDuplicates(t *testing.T) {
+	out := []astrolabev1.ModuleOutput{{Name: "id"}}
+	_, err := renderOutputsTf([]astrolabev1.Module{
+		{ObjectMeta: metav1.ObjectMeta{Name: "a"}, Status: astrolabev1.ModuleStatus{Outputs: out}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "b"}, Status: astrolabev1.ModuleStatus{Outputs: out}},
+	})
+	assert.ErrorContains(t, err, `output "id" is declared by more than one module`)
+}
@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+)
+
+// Runner modes, selected with the manager's --runner flag.
+const (
+	RunnerLocal = "local"
+	RunnerJob   = "job"
+)
+
+// errStepPending is returned by a TerraformRunner while a step runs outside
+// the manager; the Stack is reconciled again once it finished.
+var errStepPending = errors.New("terraform step is still running")
+
+// TerraformRunner runs the terraform steps of a Stack run in its working
+// directory, which holds the rendered configuration, the saved plan and, for
+// the local backend, the state.
+type TerraformRunner interface {
+	// Run runs step ("init", "plan", "apply", "destroy" or "show", which
+	// returns the saved plan as JSON) and returns its output. Running a step
+	// again for the same run must be safe.
+	Run(ctx context.Context, stack *astrolabev1.Stack, runID, workDir, step string, env []string) (string, error)
+}
+
+// LocalRunner runs terraform as a child process of the manager.
+type LocalRunner struct{}
+
+func (LocalRunner) Run(_ context.Context, _ *astrolabev1.Stack, _, workDir, step string, env []string) (string, error) {
+	if step == "show" {
+		raw, err := showTerraformPlan(workDir, env)
+		return string(raw), err
+	}
+	return runTerraformStep(workDir, step, env)
+}
+
+// terraformArgs returns the terraform arguments of a step.
+func terraformArgs(step string) ([]string, error) {
+	switch step {
+	case "init":
+		return []string{"init", "-input=false"}, nil
+	case "plan":
+		return []string{"plan", "-input=false", "-no-color", "-out=" + planFileName}, nil
+	case "apply":
+		// Applying the saved plan needs no approval prompt and never plans anew
+		return []string{"apply", "-input=false", "-no-color", planFileName}, nil
+	case "destroy":
+		return []string{"destroy", "-auto-approve", "-input=false", "-no-color"}, nil
+	case "show":
+		return []string{"show", "-json", "-no-color", planFileName}, nil
+	}
+	return nil, fmt.Errorf("unsupported terraform step: %s", step)
+}
+
+func runTerraformStep(workDir, step string, env []string) (string, error) {
+	// Run a terraform step as a subprocess in workDir, with error handling
+	args, err := terraformArgs(step)
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command("terraform", args...)
+	cmd.Dir = workDir
+	cmd.Env = append(os.Environ(), env...)
+	var outBuf, errBuf bytes.Buffer
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &errBuf
+	err = cmd.Run()
+	output := outBuf.String() + errBuf.String()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return output, fmt.Errorf("terraform %s failed with exit code %d: %s", step, exitErr.ExitCode(), output)
+		}
+		return output, fmt.Errorf("terraform %s failed: %w\nOutput: %s", step, err, output)
+	}
+	return output, nil
+}
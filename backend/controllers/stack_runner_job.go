@@ -0,0 +1,497 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	stepLabel = "astrolabe.io/step"
+
+	// jobContainerName is the init container running terraform in a runner
+	// Job; jobResultsContainerName then stores the files it produced.
+	jobContainerName        = "terraform"
+	jobResultsContainerName = "results"
+	// jobInputDir holds the input Secret; jobWorkspaceDir is the writable
+	// copy of the working directory terraform runs in, and jobResultsDir
+	// receives the files of jobStepFiles.
+	jobInputDir     = "/astrolabe/input"
+	jobWorkspaceDir = "/workspace"
+	jobResultsDir   = "/astrolabe/results"
+
+	// JobResultsCommand is the manager command the results container runs,
+	// see StoreJobResults.
+	JobResultsCommand = "job-results"
+	// jobResultKeyPrefix marks the files of a Job in its input Secret.
+	jobResultKeyPrefix = "out."
+
+	// planJSONFileName is `terraform show -json` of the saved plan, written
+	// by the plan Job since the manager may not have terraform.
+	planJSONFileName = "tfplan.json"
+
+	// maxJobInputBytes keeps the input Secret under the 1MiB object limit.
+	maxJobInputBytes = 900 * 1024
+	// jobTTLSeconds leaves finished Jobs around for a day for debugging.
+	jobTTLSeconds = 24 * 60 * 60
+)
+
+// jobStepFiles are the files each step's Job copies back to the working
+// directory when it succeeds. The saved plan embeds state and sensitive
+// values, so they return through the input Secret, never the pod log.
+var jobStepFiles = map[string][]string{
+	"plan":    {planFileName, planJSONFileName, ".terraform.lock.hcl"},
+	"apply":   {".terraform.lock.hcl"},
+	"destroy": {".terraform.lock.hcl"},
+}
+
+// jobRequiredFiles are the files of jobStepFiles without which a step's Job
+// did not succeed, whatever its exit code.
+var jobRequiredFiles = map[string][]string{
+	"plan": {planFileName, planJSONFileName},
+}
+
+// jobFileNamePattern matches the working directory files copied into Jobs;
+// the names are used unquoted in the Job's script.
+var jobFileNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// JobRunner runs each terraform step of a Stack run in its own Kubernetes
+// Job, so a long apply does not block the manager and survives its restart.
+// The manager's working directory stays the source of truth: a Job gets the
+// directory's files, the environment and the git keys in a Secret, runs
+// terraform init and the step in an emptyDir, and its results container
+// swaps that Secret's data for the files the step produced. Run creates the
+// Job and reports errStepPending until it finished, then copies those files
+// back. Stacks need the managed backend, whose state the manager reads
+// directly.
+type JobRunner struct {
+	Client client.Client
+	// APIReader reads the results of Jobs bypassing the cache; the Client when nil
+	APIReader client.Reader
+	// JobLogs returns the log of the terraform container of a Job's pod.
+	JobLogs func(ctx context.Context, job *batchv1.Job) (string, error)
+	// ResultsImage is the manager's image; its JobResultsCommand stores the
+	// files of a Job in the Job's input Secret.
+	ResultsImage string
+	// Image, ServiceAccountName and Resources are the defaults for Stacks
+	// that do not set spec.runner.
+	Image              string
+	ServiceAccountName string
+	Resources          corev1.ResourceRequirements
+}
+
+func (j *JobRunner) Run(ctx context.Context, stack *astrolabev1.Stack, runID, workDir, step string, env []string) (string, error) {
+	switch step {
+	case "init":
+		// Every Job initializes its own copy of the working directory
+		return "terraform init runs at the start of every runner Job\n", nil
+	case "show":
+		raw, err := os.ReadFile(filepath.Join(workDir, planJSONFileName))
+		if err != nil {
+			return "", fmt.Errorf("failed to read saved plan: %w", err)
+		}
+		return string(raw), nil
+	}
+	if _, ok := jobStepFiles[step]; !ok {
+		return "", fmt.Errorf("unsupported terraform step: %s", step)
+	}
+	// The manager reads outputs and resources from the managed backend's
+	// Secret; other state never reaches it from a Job. Destroying is still
+	// allowed so a Stack applied before can go away
+	if step != "destroy" && stack.Spec.BackendConfig.Type != astrolabev1.BackendTypeManaged {
+		return "", fmt.Errorf("stack %s uses the %q backend, but runner jobs need the %s backend",
+			stack.Name, stack.Spec.BackendConfig.Type, astrolabev1.BackendTypeManaged)
+	}
+
+	name := stackJobName(stack.Name, runID, step)
+	var job batchv1.Job
+	err := j.Client.Get(ctx, client.ObjectKey{Namespace: stack.Namespace, Name: name}, &job)
+	if k8serrors.IsNotFound(err) {
+		if err := j.createJob(ctx, stack, runID, name, workDir, step, env); err != nil {
+			return "", err
+		}
+		ctrl.Log.Info("Started terraform job", "name", stack.Name, "job", name, "step", step)
+		return "", errStepPending
+	}
+	if err != nil {
+		return "", err
+	}
+	finished, failed, msg := jobFinished(&job)
+	if !finished {
+		return "", errStepPending
+	}
+	// The results container replaced the input with the step's files; the
+	// Secret is not needed once they are read
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: stack.Namespace}}
+	var secretErr error
+	if !failed {
+		secretErr = j.apiReader().Get(ctx, client.ObjectKeyFromObject(secret), secret)
+	}
+	if err := j.Client.Delete(ctx, secret); err != nil && !k8serrors.IsNotFound(err) {
+		ctrl.Log.Info("Failed to delete terraform job input", "name", stack.Name, "job", name, "error", err)
+	}
+
+	out, logErr := j.JobLogs(ctx, &job)
+	if failed {
+		if logErr != nil {
+			return out, fmt.Errorf("terraform %s job %s failed: %s (logs unavailable: %v)", step, name, msg, logErr)
+		}
+		return out, fmt.Errorf("terraform %s job %s failed: %s", step, name, out)
+	}
+	if logErr != nil {
+		return "", fmt.Errorf("failed to read logs of terraform %s job %s: %w", step, name, logErr)
+	}
+	if secretErr != nil {
+		return out, fmt.Errorf("failed to read results of terraform %s job %s: %w", step, name, secretErr)
+	}
+	for _, f := range jobRequiredFiles[step] {
+		if _, ok := secret.Data[jobResultKeyPrefix+f]; !ok {
+			return out, fmt.Errorf("terraform %s job %s did not return %s", step, name, f)
+		}
+	}
+	for _, f := range jobStepFiles[step] {
+		if content, ok := secret.Data[jobResultKeyPrefix+f]; ok {
+			if err := os.WriteFile(filepath.Join(workDir, f), content, 0600); err != nil {
+				return out, err
+			}
+		}
+	}
+	return out, nil
+}
+
+func (j *JobRunner) apiReader() client.Reader {
+	if j.APIReader == nil {
+		return j.Client
+	}
+	return j.APIReader
+}
+
+// StoreJobResults replaces the data of a Job's input Secret with the files in
+// dir, so the credentials it carried are gone and the results never pass
+// through the pod log. The results container runs it as JobResultsCommand.
+func StoreJobResults(ctx context.Context, c client.Client, namespace, name, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	data := map[string][]byte{}
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return err
+		}
+		data[jobResultKeyPrefix+e.Name()] = content
+	}
+	var secret corev1.Secret
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &secret); err != nil {
+		return fmt.Errorf("failed to get job input %s: %w", name, err)
+	}
+	secret.Data = data
+	if err := c.Update(ctx, &secret); err != nil {
+		return fmt.Errorf("failed to store job results in %s: %w", name, err)
+	}
+	return nil
+}
+
+// stackJobName names the Job and input Secret of a run's step, hashing long
+// names into the 63 characters allowed in the job-name label of its pods.
+func stackJobName(stackName, runID, step string) string {
+	name := fmt.Sprintf("%s-%s-%s", stackName, strings.ReplaceAll(runID, ".", "-"), step)
+	if len(name) <= 63 {
+		return name
+	}
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte(name)))[:8]
+	return strings.TrimRight(name[:54], "-.") + "-" + sum
+}
+
+// jobFinished reports whether the Job completed or failed, with the reason
+// of a failure.
+func jobFinished(job *batchv1.Job) (finished, failed bool, msg string) {
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			return true, false, ""
+		case batchv1.JobFailed:
+			return true, true, c.Message
+		}
+	}
+	return false, false, ""
+}
+
+// createJob creates the input Secret and the Job of a step.
+func (j *JobRunner) createJob(ctx context.Context, stack *astrolabev1.Stack, runID, name, workDir, step string, env []string) error {
+	labels := map[string]string{stackLabel: stackLabelValue(stack.Name), runLabel: runID, stepLabel: step}
+	annotations := map[string]string{stackNameAnnotation: stack.Name}
+	data := map[string][]byte{}
+	size := 0
+
+	var workspace []corev1.KeyToPath
+	entries, err := os.ReadDir(workDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !jobFileNamePattern.MatchString(e.Name()) {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(workDir, e.Name()))
+		if err != nil {
+			return err
+		}
+		data["ws."+e.Name()] = content
+		size += len(content)
+		workspace = append(workspace, corev1.KeyToPath{Key: "ws." + e.Name(), Path: e.Name()})
+	}
+	// Vendored modules keep their layout; Secret keys cannot hold the path
+	modRoot := filepath.Join(workDir, vendoredModulesDir)
+	if _, err := os.Stat(modRoot); err == nil {
+		err := filepath.WalkDir(modRoot, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+			rel, err := filepath.Rel(workDir, p)
+			if err != nil {
+				return err
+			}
+			content, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			key := fmt.Sprintf("mod.%d", len(workspace))
+			data[key] = content
+			size += len(content)
+			workspace = append(workspace, corev1.KeyToPath{Key: key, Path: filepath.ToSlash(rel)})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var envVars []corev1.EnvVar
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		data["env."+k] = []byte(v)
+		size += len(v)
+		envVars = append(envVars, corev1.EnvVar{Name: k, ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: name}, Key: "env." + k},
+		}})
+	}
+
+	// SSH keys are mounted where the git environment expects them
+	gitDir := gitAuthDirFor(workDir)
+	var gitFiles []corev1.KeyToPath
+	if entries, err := os.ReadDir(gitDir); err == nil {
+		for _, e := range entries {
+			content, err := os.ReadFile(filepath.Join(gitDir, e.Name()))
+			if err != nil {
+				return err
+			}
+			data["git."+e.Name()] = content
+			size += len(content)
+			gitFiles = append(gitFiles, corev1.KeyToPath{Key: "git." + e.Name(), Path: e.Name()})
+		}
+	}
+	if size > maxJobInputBytes {
+		return fmt.Errorf("working directory of stack %s is too large for a runner job: %d bytes, at most %d", stack.Name, size, maxJobInputBytes)
+	}
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: stack.Namespace}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, j.Client, secret, func() error {
+		secret.Labels = labels
+		secret.Annotations = annotations
+		secret.Data = data
+		// Not a controller reference: the Secret must not requeue the Stack
+		return controllerutil.SetOwnerReference(stack, secret, j.Client.Scheme())
+	}); err != nil {
+		return fmt.Errorf("failed to store terraform job input: %w", err)
+	}
+
+	job := j.newJob(stack, name, step, labels, annotations, workspace, envVars, gitDir, gitFiles)
+	if err := controllerutil.SetControllerReference(stack, job, j.Client.Scheme()); err != nil {
+		return err
+	}
+	if err := j.Client.Create(ctx, job); err != nil && !k8serrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create terraform job: %w", err)
+	}
+	return nil
+}
+
+// newJob builds the Job running step for the Stack, honouring its
+// spec.runner overrides.
+func (j *JobRunner) newJob(stack *astrolabev1.Stack, name, step string, labels, annotations map[string]string,
+	workspace []corev1.KeyToPath, env []corev1.EnvVar, gitDir string, gitFiles []corev1.KeyToPath) *batchv1.Job {
+	image, serviceAccount, resources := j.Image, j.ServiceAccountName, j.Resources
+	if o := stack.Spec.Runner; o != nil {
+		if o.Image != "" {
+			image = o.Image
+		}
+		if o.ServiceAccountName != "" {
+			serviceAccount = o.ServiceAccountName
+		}
+		if o.Resources != nil {
+			resources = *o.Resources
+		}
+	}
+
+	files := make([]string, len(workspace))
+	for i, f := range workspace {
+		files[i] = f.Path
+	}
+	volumes := []corev1.Volume{
+		{Name: "workspace", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+		{Name: "input", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: name, Items: workspace}}},
+		{Name: "results", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+	}
+	mounts := []corev1.VolumeMount{
+		{Name: "workspace", MountPath: jobWorkspaceDir},
+		{Name: "input", MountPath: jobInputDir, ReadOnly: true},
+		{Name: "results", MountPath: jobResultsDir},
+	}
+	if len(gitFiles) > 0 {
+		// ssh refuses keys readable by others
+		mode := int32(0400)
+		volumes = append(volumes, corev1.Volume{Name: "git-auth", VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: name, Items: gitFiles, DefaultMode: &mode},
+		}})
+		mounts = append(mounts, corev1.VolumeMount{Name: "git-auth", MountPath: gitDir, ReadOnly: true})
+	}
+
+	backoffLimit := int32(0)
+	ttl := int32(jobTTLSeconds)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: stack.Namespace, Labels: labels, Annotations: annotations},
+		Spec: batchv1.JobSpec{
+			// A failed step is retried by the next run, not by the Job
+			BackoffLimit:            &backoffLimit,
+			TTLSecondsAfterFinished: &ttl,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels, Annotations: annotations},
+				Spec: corev1.PodSpec{
+					RestartPolicy:      corev1.RestartPolicyNever,
+					ServiceAccountName: serviceAccount,
+					// The results container only runs once terraform succeeded
+					InitContainers: []corev1.Container{{
+						Name:         jobContainerName,
+						Image:        image,
+						Command:      []string{"/bin/sh", "-c", jobScript(step, files)},
+						WorkingDir:   jobWorkspaceDir,
+						Env:          env,
+						Resources:    resources,
+						VolumeMounts: mounts,
+					}},
+					Containers: []corev1.Container{{
+						Name:  jobResultsContainerName,
+						Image: j.ResultsImage,
+						Args: []string{JobResultsCommand,
+							"--namespace", stack.Namespace, "--secret", name, "--dir", jobResultsDir},
+						VolumeMounts: []corev1.VolumeMount{{Name: "results", MountPath: jobResultsDir, ReadOnly: true}},
+					}},
+					Volumes: volumes,
+				},
+			},
+		},
+	}
+}
+
+// jobScript copies files into the workspace, runs terraform init and step,
+// and copies the files the step produced to jobResultsDir.
+func jobScript(step string, files []string) string {
+	initArgs, _ := terraformArgs("init")
+	stepArgs, _ := terraformArgs(step)
+	var b strings.Builder
+	fmt.Fprintf(&b, "cd %s || exit 1\n", jobWorkspaceDir)
+	vendored := false
+	for _, f := range files {
+		if strings.HasPrefix(f, vendoredModulesDir+"/") {
+			vendored = true
+			continue
+		}
+		fmt.Fprintf(&b, "cp -L %s/%s %s || exit 1\n", jobInputDir, f, f)
+	}
+	if vendored {
+		fmt.Fprintf(&b, "cp -RL %s/%s . || exit 1\n", jobInputDir, vendoredModulesDir)
+	}
+	fmt.Fprintf(&b, "terraform %s\nrc=$?\n", strings.Join(initArgs, " "))
+	fmt.Fprintf(&b, "if [ $rc -eq 0 ]; then terraform %s; rc=$?; fi\n", strings.Join(stepArgs, " "))
+	if step == "plan" {
+		showArgs, _ := terraformArgs("show")
+		fmt.Fprintf(&b, "if [ $rc -eq 0 ]; then terraform %s > %s; rc=$?; fi\n", strings.Join(showArgs, " "), planJSONFileName)
+	}
+	fmt.Fprintf(&b, "for f in %s; do\n", strings.Join(jobStepFiles[step], " "))
+	fmt.Fprintf(&b, "  if [ -f \"$f\" ]; then cp \"$f\" %s/ || exit 1; fi\n", jobResultsDir)
+	b.WriteString("done\nexit $rc\n")
+	return b.String()
+}
+
+// pruneStackRunJobs deletes the finished Jobs of runs other than keepRun,
+// with their pods and input Secrets. It reports whether Jobs of other runs
+// are still running; their runs must finish before another one starts.
+func (r *StackReconciler) pruneStackRunJobs(ctx context.Context, stack *astrolabev1.Stack, keepRun string) bool {
+	var jobs batchv1.JobList
+	if err := r.List(ctx, &jobs, client.InNamespace(stack.Namespace), client.MatchingLabels{stackLabel: stackLabelValue(stack.Name)}); err != nil {
+		ctrl.Log.Info("Failed to list terraform jobs", "name", stack.Name, "error", err)
+		return false
+	}
+	busy := false
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if !ownedByStack(job, stack.Name) || job.Labels[runLabel] == keepRun {
+			continue
+		}
+		if finished, _, _ := jobFinished(job); !finished {
+			busy = true
+			continue
+		}
+		if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !k8serrors.IsNotFound(err) {
+			ctrl.Log.Info("Failed to delete terraform job", "name", stack.Name, "job", job.Name, "error", err)
+		}
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: job.Name, Namespace: job.Namespace}}
+		if err := r.Delete(ctx, secret); err != nil && !k8serrors.IsNotFound(err) {
+			ctrl.Log.Info("Failed to delete terraform job input", "name", stack.Name, "job", job.Name, "error", err)
+		}
+	}
+	return busy
+}
+
+// JobLogsFromClientset reads the log of a Job's latest pod with clientset;
+// the manager's cached client cannot stream logs.
+func JobLogsFromClientset(clientset kubernetes.Interface) func(context.Context, *batchv1.Job) (string, error) {
+	return func(ctx context.Context, job *batchv1.Job) (string, error) {
+		pods, err := clientset.CoreV1().Pods(job.Namespace).List(ctx, metav1.ListOptions{LabelSelector: "job-name=" + job.Name})
+		if err != nil {
+			return "", err
+		}
+		if len(pods.Items) == 0 {
+			return "", fmt.Errorf("no pod found for job %s", job.Name)
+		}
+		sort.Slice(pods.Items, func(a, b int) bool {
+			return pods.Items[a].CreationTimestamp.Before(&pods.Items[b].CreationTimestamp)
+		})
+		pod := pods.Items[len(pods.Items)-1]
+		raw, err := clientset.CoreV1().Pods(job.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{Container: jobContainerName}).DoRaw(ctx)
+		return string(raw), err
+	}
+}
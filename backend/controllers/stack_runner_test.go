@@ -0,0 +1,240 @@
+package controllers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestStackJobName(t *testing.T) {
+	assert.Equal(t, "demo-20250101-120000-123-plan", stackJobName("demo", "20250101-120000.123", "plan"))
+
+	long := stackJobName(strings.Repeat("a", 60), "20250101-120000.123", "apply")
+	assert.Len(t, long, 63)
+	assert.NotEqual(t, long, stackJobName(strings.Repeat("a", 60), "20250101-120000.123", "destroy"))
+}
+
+// storeTestJobResults stands in for the results container of a Job that
+// produced files.
+func storeTestJobResults(t *testing.T, c client.Client, name string, files map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	for f, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, f), []byte(content), 0600))
+	}
+	require.NoError(t, StoreJobResults(context.Background(), c, "default", name, dir))
+}
+
+func setJobCondition(t *testing.T, c client.Client, name string, cond batchv1.JobConditionType, msg string) {
+	t.Helper()
+	var job batchv1.Job
+	require.NoError(t, c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: name}, &job))
+	job.Status.Conditions = append(job.Status.Conditions, batchv1.JobCondition{Type: cond, Status: corev1.ConditionTrue, Message: msg})
+	require.NoError(t, c.Status().Update(context.Background(), &job))
+}
+
+func TestJobRunnerRun(t *testing.T) {
+	ctx := context.Background()
+	workDir := filepath.Join(t.TempDir(), "demo")
+	require.NoError(t, os.MkdirAll(filepath.Join(workDir, ".terraform"), 0700))
+	require.NoError(t, writeFile(filepath.Join(workDir, "main.tf"), `module "vpc" {}`))
+	require.NoError(t, writeFile(filepath.Join(workDir, ".terraform.lock.hcl"), "# lock"))
+	require.NoError(t, os.MkdirAll(filepath.Join(workDir, vendoredModulesDir, "abc", "modules"), 0755))
+	require.NoError(t, writeFile(filepath.Join(workDir, vendoredModulesDir, "abc", "modules", "vpc.tf"), "# vendored"))
+	require.NoError(t, os.MkdirAll(gitAuthDirFor(workDir), 0700))
+	require.NoError(t, writeFile(filepath.Join(gitAuthDirFor(workDir), "id_0"), "key"))
+
+	stack := &astrolabev1.Stack{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default", UID: "uid-1"},
+		Spec: astrolabev1.StackSpec{
+			BackendConfig: astrolabev1.BackendConfigSpec{Type: astrolabev1.BackendTypeManaged},
+			Runner:        &astrolabev1.StackRunnerSpec{Image: "custom/terraform:1"},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(stack).Build()
+	logs := ""
+	runner := &JobRunner{
+		Client:             c,
+		JobLogs:            func(context.Context, *batchv1.Job) (string, error) { return logs, nil },
+		ResultsImage:       "astrolabe/manager:1",
+		Image:              "hashicorp/terraform",
+		ServiceAccountName: "terraform",
+	}
+	env := []string{"AWS_SECRET_ACCESS_KEY=s3cr3t"}
+
+	out, err := runner.Run(ctx, stack, "run-1", workDir, "init", env)
+	require.NoError(t, err)
+	assert.Contains(t, out, "every runner Job")
+
+	_, err = runner.Run(ctx, stack, "run-1", workDir, "plan", env)
+	require.ErrorIs(t, err, errStepPending)
+	name := stackJobName("demo", "run-1", "plan")
+	var job batchv1.Job
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "default", Name: name}, &job))
+	assert.Equal(t, map[string]string{stackLabel: "demo", runLabel: "run-1", stepLabel: "plan"}, job.Labels)
+	assert.Equal(t, "demo", job.Annotations[stackNameAnnotation])
+	require.Len(t, job.OwnerReferences, 1)
+	assert.True(t, *job.OwnerReferences[0].Controller)
+	pod := job.Spec.Template.Spec
+	assert.Equal(t, "terraform", pod.ServiceAccountName)
+	require.Len(t, pod.InitContainers, 1)
+	terraform := pod.InitContainers[0]
+	assert.Equal(t, "custom/terraform:1", terraform.Image, "spec.runner overrides the default image")
+	script := terraform.Command[2]
+	assert.Contains(t, script, "cp -L /astrolabe/input/.terraform.lock.hcl .terraform.lock.hcl")
+	assert.Contains(t, script, "cp -RL /astrolabe/input/.modules . || exit 1")
+	assert.NotContains(t, script, "vpc.tf", "vendored modules are copied as a tree")
+	assert.Contains(t, script, "terraform plan -input=false -no-color -out=tfplan")
+	assert.Contains(t, script, "terraform show -json -no-color tfplan > tfplan.json")
+	assert.Contains(t, script, `cp "$f" /astrolabe/results/`)
+	assert.NotContains(t, script, "base64", "results never pass through the log")
+	require.Len(t, terraform.Env, 1)
+	assert.Equal(t, "env.AWS_SECRET_ACCESS_KEY", terraform.Env[0].ValueFrom.SecretKeyRef.Key)
+	require.Len(t, pod.Volumes, 4)
+	assert.Equal(t, gitAuthDirFor(workDir), terraform.VolumeMounts[3].MountPath)
+	require.Len(t, pod.Containers, 1)
+	assert.Equal(t, "astrolabe/manager:1", pod.Containers[0].Image)
+	assert.Equal(t, []string{JobResultsCommand, "--namespace", "default", "--secret", name, "--dir", jobResultsDir}, pod.Containers[0].Args)
+	assert.Empty(t, pod.Containers[0].Env, "the results container gets no credentials")
+
+	var input corev1.Secret
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "default", Name: name}, &input))
+	assert.Equal(t, "s3cr3t", string(input.Data["env.AWS_SECRET_ACCESS_KEY"]))
+	assert.Equal(t, "key", string(input.Data["git.id_0"]))
+	assert.Contains(t, input.Data, "ws.main.tf")
+	assert.NotContains(t, input.Data, "ws..terraform", "directories are not copied")
+	var vendored []corev1.KeyToPath
+	for _, item := range pod.Volumes[1].Secret.Items {
+		if strings.HasPrefix(item.Path, vendoredModulesDir+"/") {
+			vendored = append(vendored, item)
+		}
+	}
+	require.Len(t, vendored, 1)
+	assert.Equal(t, ".modules/abc/modules/vpc.tf", vendored[0].Path)
+	assert.Equal(t, "# vendored", string(input.Data[vendored[0].Key]))
+
+	// The step is pending until the Job finished
+	_, err = runner.Run(ctx, stack, "run-1", workDir, "plan", env)
+	require.ErrorIs(t, err, errStepPending)
+
+	storeTestJobResults(t, c, name, map[string]string{"tfplan": "plan", "tfplan.json": `{"format_version":"1.2"}`, "terraform.tfstate": "not for plan"})
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "default", Name: name}, &input))
+	assert.NotContains(t, input.Data, "env.AWS_SECRET_ACCESS_KEY", "the results replace the credentials")
+	setJobCondition(t, c, name, batchv1.JobComplete, "")
+	logs = "Plan: 1 to add\n"
+	out, err = runner.Run(ctx, stack, "run-1", workDir, "plan", env)
+	require.NoError(t, err)
+	assert.Equal(t, "Plan: 1 to add\n", out)
+	show, err := runner.Run(ctx, stack, "run-1", workDir, "show", env)
+	require.NoError(t, err)
+	assert.Equal(t, `{"format_version":"1.2"}`, show)
+	assert.NoFileExists(t, filepath.Join(workDir, "terraform.tfstate"), "only the step's files are copied back")
+	err = c.Get(ctx, client.ObjectKey{Namespace: "default", Name: name}, &input)
+	assert.True(t, k8serrors.IsNotFound(err), "the input Secret is deleted once the Job finished")
+
+	// A plan Job that exited successfully without its plan did not succeed
+	_, err = runner.Run(ctx, stack, "run-2", workDir, "plan", env)
+	require.ErrorIs(t, err, errStepPending)
+	storeTestJobResults(t, c, stackJobName("demo", "run-2", "plan"), map[string]string{"tfplan": "plan"})
+	setJobCondition(t, c, stackJobName("demo", "run-2", "plan"), batchv1.JobComplete, "")
+	_, err = runner.Run(ctx, stack, "run-2", workDir, "plan", env)
+	assert.ErrorContains(t, err, "did not return tfplan.json")
+
+	_, err = runner.Run(ctx, stack, "run-1", workDir, "apply", env)
+	require.ErrorIs(t, err, errStepPending)
+	var apply batchv1.Job
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "default", Name: stackJobName("demo", "run-1", "apply")}, &apply))
+	assert.NotContains(t, apply.Spec.Template.Spec.InitContainers[0].Command[2], "terraform.tfstate", "state never leaves the Job")
+	setJobCondition(t, c, stackJobName("demo", "run-1", "apply"), batchv1.JobFailed, "BackoffLimitExceeded")
+	logs = "Error: boom\n"
+	_, err = runner.Run(ctx, stack, "run-1", workDir, "apply", env)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Error: boom")
+
+	// The manager reads state only from the managed backend
+	for _, backend := range []string{"local", "s3"} {
+		stack.Spec.BackendConfig.Type = backend
+		_, err = runner.Run(ctx, stack, "run-3", workDir, "plan", env)
+		assert.ErrorContains(t, err, "runner jobs need the managed backend", backend)
+	}
+	_, err = runner.Run(ctx, stack, "run-3", workDir, "destroy", env)
+	require.ErrorIs(t, err, errStepPending, "such a Stack can still be destroyed")
+}
+
+func TestJobRunnerLongStackName(t *testing.T) {
+	ctx := context.Background()
+	workDir := t.TempDir()
+	require.NoError(t, writeFile(filepath.Join(workDir, "main.tf"), `module "vpc" {}`))
+	name := strings.Repeat("a", 70)
+	stack := &astrolabev1.Stack{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: "uid-1"},
+		Spec:       astrolabev1.StackSpec{BackendConfig: astrolabev1.BackendConfigSpec{Type: astrolabev1.BackendTypeManaged}},
+	}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(stack).Build()
+	runner := &JobRunner{Client: c, Image: "hashicorp/terraform"}
+
+	_, err := runner.Run(ctx, stack, "run-1", workDir, "plan", nil)
+	require.ErrorIs(t, err, errStepPending)
+	var job batchv1.Job
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "default", Name: stackJobName(name, "run-1", "plan")}, &job))
+	for _, labels := range []map[string]string{job.Labels, job.Spec.Template.Labels} {
+		assert.Empty(t, validation.IsValidLabelValue(labels[stackLabel]))
+		assert.Equal(t, stackLabelValue(name), labels[stackLabel])
+	}
+	assert.Equal(t, name, job.Annotations[stackNameAnnotation])
+	assert.Equal(t, name, job.Spec.Template.Annotations[stackNameAnnotation])
+}
+
+func TestBeginStackRunWithJobs(t *testing.T) {
+	ctx := context.Background()
+	stack := &astrolabev1.Stack{ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default", UID: "uid-1"}}
+	finished := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "old", Namespace: "default", Labels: map[string]string{stackLabel: "demo", runLabel: "old"}},
+		Status:     batchv1.JobStatus{Conditions: []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}},
+	}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(stack, finished).Build()
+	r := &StackReconciler{Client: c, Runner: &JobRunner{Client: c}}
+
+	id, wait := r.beginStackRun(ctx, stack, false, "Created")
+	require.False(t, wait)
+	var jobs batchv1.JobList
+	require.NoError(t, c.List(ctx, &jobs))
+	assert.Empty(t, jobs.Items, "finished Jobs of other runs are deleted")
+
+	resumed, wait := r.beginStackRun(ctx, stack, false, "Resync")
+	require.False(t, wait)
+	assert.Equal(t, id, resumed, "the run in flight is resumed")
+
+	// Destroying waits for the Jobs of the run in flight
+	running := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "running", Namespace: "default", Labels: map[string]string{stackLabel: "demo", runLabel: id}}}
+	require.NoError(t, c.Create(ctx, running))
+	_, wait = r.beginStackRun(ctx, stack, true, "Deleted")
+	assert.True(t, wait)
+	assert.Len(t, stack.Status.Runs, 1)
+}
+
+func TestJobLogsFromClientset(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "demo-plan-abcde", Namespace: "default", Labels: map[string]string{"job-name": "demo-plan"}}}
+	logs := JobLogsFromClientset(kubefake.NewSimpleClientset(pod))
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "demo-plan", Namespace: "default"}}
+	out, err := logs(context.Background(), job)
+	require.NoError(t, err)
+	assert.Equal(t, "fake logs", out)
+
+	_, err = logs(context.Background(), &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"}})
+	assert.Error(t, err)
+}
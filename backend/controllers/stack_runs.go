@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Statuses of a StackApply run record.
+const (
+	runRunning          = "Running"
+	runSucceeded        = "Succeeded"
+	runFailed           = "Failed"
+	runAwaitingApproval = "AwaitingApproval"
+)
+
+// runTrigger explains why a reconcile runs terraform for the Stack, judging
+// from its previous run.
+func runTrigger(stack *astrolabev1.Stack, upstreamHash string) string {
+	if len(stack.Status.Runs) == 0 {
+		return "Created"
+	}
+	last := stack.Status.Runs[len(stack.Status.Runs)-1]
+	switch {
+	case last.Generation != stack.Generation:
+		return "SpecChanged"
+	case last.Status == runSucceeded && stack.Status.UpstreamOutputsHash != upstreamHash:
+		return "UpstreamOutputsChanged"
+	case last.Status == runAwaitingApproval && stack.Status.Plan != nil &&
+		stack.Annotations[astrolabev1.ApprovedPlanAnnotation] == stack.Status.Plan.Hash:
+		return "Approved"
+	case last.Status == runFailed:
+		return "Retry"
+	default:
+		return "Resync"
+	}
+}
+
+// startStackRun appends a Running record to the run history of the Stack,
+// dropping the oldest records beyond MaxStackRuns, and returns its ID.
+// A run left Running was interrupted, for example by a manager restart.
+func startStackRun(stack *astrolabev1.Stack, trigger string) string {
+	finishStackRun(stack, runFailed, "", "Interrupted before it finished")
+	now := metav1.NewTime(time.Now().UTC())
+	id := now.Format("20060102-150405.000")
+	for n := 2; hasStackRun(stack, id); n++ {
+		id = fmt.Sprintf("%s-%d", now.Format("20060102-150405.000"), n)
+	}
+	stack.Status.Runs = append(stack.Status.Runs, astrolabev1.StackApply{
+		ID:         id,
+		Trigger:    trigger,
+		Generation: stack.Generation,
+		Status:     runRunning,
+		Logs:       "secret/" + stackRunLogName(stack.Name, id),
+		StartedAt:  now,
+	})
+	if n := len(stack.Status.Runs); n > astrolabev1.MaxStackRuns {
+		stack.Status.Runs = append([]astrolabev1.StackApply(nil), stack.Status.Runs[n-astrolabev1.MaxStackRuns:]...)
+	}
+	return id
+}
+
+func hasStackRun(stack *astrolabev1.Stack, id string) bool {
+	for _, run := range stack.Status.Runs {
+		if run.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// currentStackRun returns the Running record of the Stack, if any.
+func currentStackRun(stack *astrolabev1.Stack) *astrolabev1.StackApply {
+	if n := len(stack.Status.Runs); n > 0 && stack.Status.Runs[n-1].Status == runRunning {
+		return &stack.Status.Runs[n-1]
+	}
+	return nil
+}
+
+// recordRunPlan notes the plan the current run made or applies.
+func recordRunPlan(stack *astrolabev1.Stack) {
+	if run := currentStackRun(stack); run != nil && stack.Status.Plan != nil {
+		run.PlanSummary = stack.Status.Plan.Summary
+		run.PlanHash = stack.Status.Plan.Hash
+	}
+}
+
+// finishStackRun completes the current run, if any, with the given status.
+func finishStackRun(stack *astrolabev1.Stack, status, failedStep, summary string) {
+	run := currentStackRun(stack)
+	if run == nil {
+		return
+	}
+	now := metav1.NewTime(time.Now().UTC())
+	run.Status = status
+	run.FailedStep = failedStep
+	run.Summary = summary
+	run.FinishedAt = &now
+}
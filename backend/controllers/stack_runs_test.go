@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"testing"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRunTrigger(t *testing.T) {
+	stack := &astrolabev1.Stack{ObjectMeta: metav1.ObjectMeta{Generation: 2}}
+	assert.Equal(t, "Created", runTrigger(stack, ""))
+
+	stack.Status.Runs = []astrolabev1.StackApply{{Generation: 1, Status: runSucceeded}}
+	assert.Equal(t, "SpecChanged", runTrigger(stack, ""))
+
+	stack.Status.Runs[0].Generation = 2
+	stack.Status.UpstreamOutputsHash = "old"
+	assert.Equal(t, "UpstreamOutputsChanged", runTrigger(stack, "new"))
+	assert.Equal(t, "Resync", runTrigger(stack, "old"))
+
+	stack.Status.Runs[0].Status = runAwaitingApproval
+	stack.Status.Plan = &astrolabev1.StackPlan{Hash: "abc"}
+	assert.Equal(t, "Resync", runTrigger(stack, "old"), "not approved yet")
+	stack.Annotations = map[string]string{astrolabev1.ApprovedPlanAnnotation: "abc"}
+	assert.Equal(t, "Approved", runTrigger(stack, "old"))
+
+	stack.Status.Runs[0].Status = runFailed
+	assert.Equal(t, "Retry", runTrigger(stack, "old"))
+}
+
+func TestStackRunHistory(t *testing.T) {
+	stack := &astrolabev1.Stack{ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default", Generation: 4}}
+	stack.Status.Plan = &astrolabev1.StackPlan{Hash: "abc", Summary: "Plan: 1 to add, 0 to change, 0 to destroy."}
+
+	id := startStackRun(stack, "Created")
+	require.Len(t, stack.Status.Runs, 1)
+	run := stack.Status.Runs[0]
+	assert.Equal(t, id, run.ID)
+	assert.Equal(t, runRunning, run.Status)
+	assert.Equal(t, int64(4), run.Generation)
+	assert.Equal(t, "secret/demo-run-"+id, run.Logs)
+
+	recordRunPlan(stack)
+	finishStackRun(stack, runFailed, "apply", "boom")
+	run = stack.Status.Runs[0]
+	assert.Equal(t, runFailed, run.Status)
+	assert.Equal(t, "apply", run.FailedStep)
+	assert.Equal(t, "boom", run.Summary)
+	assert.Equal(t, "abc", run.PlanHash)
+	assert.Equal(t, "Plan: 1 to add, 0 to change, 0 to destroy.", run.PlanSummary)
+	require.NotNil(t, run.FinishedAt)
+
+	// Finishing again is a no-op once no run is Running
+	finishStackRun(stack, runSucceeded, "", "")
+	assert.Equal(t, runFailed, stack.Status.Runs[0].Status)
+
+	// A run left Running is marked interrupted by the next one
+	startStackRun(stack, "Retry")
+	startStackRun(stack, "Retry")
+	require.Len(t, stack.Status.Runs, 3)
+	assert.Equal(t, runFailed, stack.Status.Runs[1].Status)
+	assert.Equal(t, "Interrupted before it finished", stack.Status.Runs[1].Summary)
+	assert.Equal(t, runRunning, stack.Status.Runs[2].Status)
+
+	for i := 0; i < astrolabev1.MaxStackRuns; i++ {
+		startStackRun(stack, "Resync")
+	}
+	assert.Len(t, stack.Status.Runs, astrolabev1.MaxStackRuns)
+	ids := map[string]bool{}
+	for _, run := range stack.Status.Runs {
+		ids[run.ID] = true
+	}
+	assert.Len(t, ids, astrolabev1.MaxStackRuns, "run IDs are unique")
+	assert.Equal(t, "Resync", stack.Status.Runs[0].Trigger, "the oldest runs are dropped")
+}
@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Stacks with the managed backend keep their state with Terraform's
+// kubernetes backend in their own namespace: Terraform writes it, gzipped,
+// to the Secret tfstate-<workspace>-<secret_suffix> and locks it with the
+// Lease lock-tfstate-<workspace>-<secret_suffix>. The controller renders the
+// backend, reads the state from the Secret and deletes both after destroy,
+// as long as the Secret carries the Stack's stateLabel.
+const (
+	// managedStateWorkspace is the Terraform workspace of every Stack.
+	managedStateWorkspace = "default"
+	// stateLabel marks the state of a Stack; it is not stackLabel, whose
+	// Secrets are pruned with the run history.
+	stateLabel = "astrolabe.io/state"
+)
+
+// managedStateSettings returns the settings of a managed backend with
+// secret_suffix defaulted to the Stack's name.
+func managedStateSettings(stack *astrolabev1.Stack) (map[string]interface{}, error) {
+	settings := map[string]interface{}{}
+	if raw := stack.Spec.BackendConfig.Settings.Raw; len(raw) > 0 {
+		if err := json.Unmarshal(raw, &settings); err != nil {
+			return nil, fmt.Errorf("backend settings: %w", err)
+		}
+	}
+	if _, ok := settings["secret_suffix"]; !ok {
+		settings["secret_suffix"] = stack.Name
+	}
+	return settings, nil
+}
+
+// managedStateNames returns the names of the state Secret and lock Lease of
+// a Stack with the managed backend.
+func managedStateNames(stack *astrolabev1.Stack) (secret, lease string, err error) {
+	settings, err := managedStateSettings(stack)
+	if err != nil {
+		return "", "", err
+	}
+	suffix, ok := settings["secret_suffix"].(string)
+	if !ok || suffix == "" {
+		return "", "", fmt.Errorf("backend settings: secret_suffix must be a non-empty string")
+	}
+	secret = fmt.Sprintf("tfstate-%s-%s", managedStateWorkspace, suffix)
+	return secret, "lock-" + secret, nil
+}
+
+// stateBackend returns the backend rendered for the Stack, spelling out the
+// kubernetes backend of the managed one. The namespace is always the
+// Stack's, so a Stack cannot reach another namespace's state.
+func stateBackend(stack astrolabev1.Stack) (astrolabev1.BackendConfigSpec, error) {
+	if stack.Spec.BackendConfig.Type != astrolabev1.BackendTypeManaged {
+		return stack.Spec.BackendConfig, nil
+	}
+	settings, err := managedStateSettings(&stack)
+	if err != nil {
+		return astrolabev1.BackendConfigSpec{}, err
+	}
+	labels, _ := settings["labels"].(map[string]interface{})
+	if labels == nil {
+		labels = map[string]interface{}{}
+	}
+	labels[stateLabel] = stackLabelValue(stack.Name)
+	settings["labels"] = labels
+	settings["namespace"] = stack.Namespace
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		return astrolabev1.BackendConfigSpec{}, err
+	}
+	return astrolabev1.BackendConfigSpec{Type: "kubernetes", Settings: apiextensionsv1.JSON{Raw: raw}}, nil
+}
+
+// managedBackendEnv points the kubernetes backend of a managed Stack at the
+// cluster terraform runs in. Outside the cluster, the backend falls back to
+// KUBE_CONFIG_PATH from the manager's environment.
+func (r *StackReconciler) managedBackendEnv(stack *astrolabev1.Stack) []string {
+	if stack.Spec.BackendConfig.Type != astrolabev1.BackendTypeManaged {
+		return nil
+	}
+	if _, job := r.runner().(*JobRunner); job || os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return []string{"KUBE_IN_CLUSTER_CONFIG=true"}
+	}
+	return nil
+}
+
+// readStackState returns the Terraform state of the Stack: the Secret of the
+// managed backend, or the local state in workDir otherwise.
+func (r *StackReconciler) readStackState(ctx context.Context, stack *astrolabev1.Stack, workDir string) ([]byte, error) {
+	if stack.Spec.BackendConfig.Type != astrolabev1.BackendTypeManaged {
+		data, err := os.ReadFile(filepath.Join(workDir, "terraform.tfstate"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read state: %w", err)
+		}
+		return data, nil
+	}
+	name, _, err := managedStateNames(stack)
+	if err != nil {
+		return nil, err
+	}
+	// Terraform just wrote the Secret; the cache may not have seen it yet
+	var secret corev1.Secret
+	if err := r.apiReader().Get(ctx, client.ObjectKey{Namespace: stack.Namespace, Name: name}, &secret); err != nil {
+		return nil, fmt.Errorf("failed to read state secret %s: %w", name, err)
+	}
+	data := secret.Data["tfstate"]
+	if len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		return data, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state secret %s: %w", name, err)
+	}
+	defer zr.Close()
+	data, err = io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state secret %s: %w", name, err)
+	}
+	return data, nil
+}
+
+// deleteManagedState deletes the state Secret and lock Lease of a destroyed
+// Stack with the managed backend. A Secret without the Stack's stateLabel
+// was not written for it and is left alone, and so is its Lease.
+func (r *StackReconciler) deleteManagedState(ctx context.Context, stack *astrolabev1.Stack) error {
+	if stack.Spec.BackendConfig.Type != astrolabev1.BackendTypeManaged {
+		return nil
+	}
+	name, lease, err := managedStateNames(stack)
+	if err != nil {
+		return err
+	}
+	var secret corev1.Secret
+	if err := r.apiReader().Get(ctx, client.ObjectKey{Namespace: stack.Namespace, Name: name}, &secret); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if secret.Labels[stateLabel] != stackLabelValue(stack.Name) {
+		ctrl.Log.Info("Not deleting state secret of another owner", "name", stack.Name, "secret", name, "owner", secret.Labels[stateLabel])
+		return nil
+	}
+	// The Lease goes first: only the Secret tells that it belongs to the Stack
+	if err := r.Delete(ctx, &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: lease, Namespace: stack.Namespace}}); err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	if err := r.Delete(ctx, &secret); err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// apiReader returns the reader for objects that must not come from the cache.
+func (r *StackReconciler) apiReader() client.Reader {
+	if r.APIReader == nil {
+		return r.Client
+	}
+	return r.APIReader
+}
@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"testing"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func managedStack(settings string) *astrolabev1.Stack {
+	return &astrolabev1.Stack{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "team-a"},
+		Spec: astrolabev1.StackSpec{BackendConfig: astrolabev1.BackendConfigSpec{
+			Type:     astrolabev1.BackendTypeManaged,
+			Settings: apiextensionsv1.JSON{Raw: []byte(settings)},
+		}},
+	}
+}
+
+func TestStateBackendManaged(t *testing.T) {
+	backend, err := stateBackend(*managedStack(`{"labels":{"team":"a"},"namespace":"kube-system"}`))
+	require.NoError(t, err)
+	out, err := renderBackendTf(backend, "demo")
+	require.NoError(t, err)
+	assert.Equal(t, `terraform {
+  backend "kubernetes" {
+    labels = {
+      "astrolabe.io/state" = "demo"
+      team                 = "a"
+    }
+    namespace     = "team-a"
+    secret_suffix = "demo"
+  }
+}
+`, out, "the Stack's namespace always wins")
+
+	local := astrolabev1.Stack{Spec: astrolabev1.StackSpec{BackendConfig: astrolabev1.BackendConfigSpec{Type: "local"}}}
+	backend, err = stateBackend(local)
+	require.NoError(t, err)
+	assert.Equal(t, local.Spec.BackendConfig, backend)
+}
+
+func TestManagedState(t *testing.T) {
+	ctx := context.Background()
+	stack := managedStack(`{"secret_suffix":"net"}`)
+	var state bytes.Buffer
+	zw := gzip.NewWriter(&state)
+	_, err := zw.Write([]byte(`{"outputs":{"vpc_id":{"value":"vpc-1"}}}`))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "tfstate-default-net", Namespace: "team-a", Labels: map[string]string{stateLabel: "demo"}},
+			Data:       map[string][]byte{"tfstate": state.Bytes()},
+		},
+		&coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: "lock-tfstate-default-net", Namespace: "team-a"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "tfstate-default-other", Namespace: "team-a", Labels: map[string]string{stateLabel: "other"}}},
+		&coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: "lock-tfstate-default-other", Namespace: "team-a"}},
+	).Build()
+	r := &StackReconciler{Client: c}
+
+	// State the Stack did not write is never deleted, whatever the suffix says
+	require.NoError(t, r.deleteManagedState(ctx, managedStack(`{"secret_suffix":"other"}`)))
+
+	data, err := r.readStackState(ctx, stack, t.TempDir())
+	require.NoError(t, err)
+	outputs, _, err := parseTerraformState(data)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"vpc_id": "vpc-1"}, outputs)
+
+	require.NoError(t, r.deleteManagedState(ctx, stack))
+	require.NoError(t, r.deleteManagedState(ctx, stack), "already deleted state is fine")
+	var secrets corev1.SecretList
+	require.NoError(t, c.List(ctx, &secrets))
+	require.Len(t, secrets.Items, 1)
+	assert.Equal(t, "tfstate-default-other", secrets.Items[0].Name)
+	var leases coordinationv1.LeaseList
+	require.NoError(t, c.List(ctx, &leases))
+	require.Len(t, leases.Items, 1)
+	assert.Equal(t, "lock-tfstate-default-other", leases.Items[0].Name)
+
+	_, err = r.readStackState(ctx, stack, t.TempDir())
+	assert.ErrorContains(t, err, "tfstate-default-net")
+}
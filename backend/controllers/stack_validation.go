@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	"github.com/junaid18183/astrolabe/internal/tfmodule"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// validateStackVariables checks the variables and linked inputs of every
+// Stack module against the inputs its Module declares and the outputs of the
+// Modules it links to. modules[i] is the Module for stack.Spec.Modules[i].
+func validateStackVariables(stack *astrolabev1.Stack, modules []astrolabev1.Module) field.ErrorList {
+	var errs field.ErrorList
+	byName := map[string]astrolabev1.Module{}
+	for i, stackMod := range stack.Spec.Modules {
+		byName[stackMod.Name] = modules[i]
+	}
+	modulesPath := field.NewPath("spec", "modules")
+	for i, stackMod := range stack.Spec.Modules {
+		linked, linkErrs := validateLinkedInputs(modules[i], stackMod, byName, modulesPath.Index(i).Child("linkedInputs"))
+		errs = append(errs, linkErrs...)
+		errs = append(errs, validateModuleVariables(modules[i], stackMod, linked, modulesPath.Index(i).Child("variables"))...)
+	}
+	return errs
+}
+
+// validateLinkedInputs checks that every linked input sets a variable of the
+// module, once, from an existing output of another module in the Stack. It
+// returns the names of the variables the links set.
+func validateLinkedInputs(mod astrolabev1.Module, stackMod astrolabev1.StackModuleRef, byName map[string]astrolabev1.Module, path *field.Path) (map[string]bool, field.ErrorList) {
+	var errs field.ErrorList
+	inputs := map[string]bool{}
+	for _, in := range mod.Status.Inputs {
+		inputs[in.Name] = true
+	}
+	linked := map[string]bool{}
+	for j, link := range stackMod.LinkedInputs {
+		linkPath := path.Index(j)
+		if linked[link.ToVariable] {
+			errs = append(errs, field.Duplicate(linkPath.Child("toVariable"), link.ToVariable))
+		} else if !inputs[link.ToVariable] {
+			errs = append(errs, field.Invalid(linkPath.Child("toVariable"), link.ToVariable, fmt.Sprintf("module %s has no input variable %q", mod.Name, link.ToVariable)))
+		}
+		linked[link.ToVariable] = true
+
+		if link.FromModuleID == stackMod.Name {
+			errs = append(errs, field.Invalid(linkPath.Child("fromModuleId"), link.FromModuleID, "a module cannot take inputs from its own outputs"))
+			continue
+		}
+		from, ok := byName[link.FromModuleID]
+		if !ok {
+			errs = append(errs, field.NotFound(linkPath.Child("fromModuleId"), link.FromModuleID))
+			continue
+		}
+		if !moduleHasOutput(from, link.FromOutput) {
+			errs = append(errs, field.Invalid(linkPath.Child("fromOutput"), link.FromOutput, fmt.Sprintf("module %s has no output %q", from.Name, link.FromOutput)))
+		}
+	}
+	return linked, errs
+}
+
+func moduleHasOutput(mod astrolabev1.Module, name string) bool {
+	for _, out := range mod.Status.Outputs {
+		if out.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validateModuleVariables reports unknown variables, missing required
+// variables and values that do not conform to their input's Terraform type.
+// Variables in linked are set by linked inputs and must not have a value too.
+func validateModuleVariables(mod astrolabev1.Module, stackMod astrolabev1.StackModuleRef, linked map[string]bool, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	variables := map[string]interface{}{}
+	if len(stackMod.Variables.Raw) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(stackMod.Variables.Raw))
+		dec.UseNumber()
+		if err := dec.Decode(&variables); err != nil {
+			return field.ErrorList{field.Invalid(path, field.OmitValueType{}, "must be an object of variable values: "+err.Error())}
+		}
+	}
+
+	inputs := map[string]astrolabev1.ModuleInput{}
+	for _, in := range mod.Status.Inputs {
+		inputs[in.Name] = in
+		if _, ok := variables[in.Name]; !ok && !linked[in.Name] && in.Required {
+			errs = append(errs, field.Required(path.Child(in.Name), fmt.Sprintf("required by module %s", mod.Name)))
+		}
+	}
+
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		in, ok := inputs[name]
+		if !ok {
+			errs = append(errs, field.Forbidden(path.Child(name), fmt.Sprintf("module %s has no input variable %q", mod.Name, name)))
+			continue
+		}
+		if linked[name] {
+			errs = append(errs, field.Forbidden(path.Child(name), "is also set by a linked input"))
+			continue
+		}
+		ty, _, err := tfmodule.ParseType(in.Type)
+		if err != nil {
+			// The Module controller rejects invalid types, so this is a stale status
+			errs = append(errs, field.InternalError(path.Child(name), err))
+			continue
+		}
+		errs = append(errs, tfmodule.ValidateValue(ty, variables[name], path.Child(name), in.Sensitive)...)
+	}
+	return errs
+}
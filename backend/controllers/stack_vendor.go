@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	"github.com/junaid18183/astrolabe/internal/archive"
+	"github.com/junaid18183/astrolabe/internal/oci"
+)
+
+// vendorOCIModules pulls the oci Modules among modules into workDir at the
+// digest each Module resolved, where moduleSourceAddress points terraform, and
+// removes copies no longer used. Registry credentials stay in the controller.
+func (r *StackReconciler) vendorOCIModules(ctx context.Context, workDir string, modules []astrolabev1.Module) error {
+	root := filepath.Join(workDir, vendoredModulesDir)
+	keep := map[string]bool{}
+	for _, mod := range modules {
+		if mod.Spec.Source.Type != "oci" {
+			continue
+		}
+		rel := vendoredModulePath(mod)
+		keep[filepath.Base(rel)] = true
+		dir := filepath.Join(workDir, filepath.FromSlash(rel))
+		if _, err := os.Stat(dir); err == nil {
+			continue
+		}
+		if err := r.vendorOCIModule(ctx, root, dir, mod); err != nil {
+			return fmt.Errorf("module %s: %w", mod.Name, err)
+		}
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, e := range entries {
+		if !keep[e.Name()] {
+			if err := os.RemoveAll(filepath.Join(root, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// vendorOCIModule pulls mod into a scratch directory below root and moves its
+// module directory, honouring spec.source.path, to dir.
+func (r *StackReconciler) vendorOCIModule(ctx context.Context, root, dir string, mod astrolabev1.Module) error {
+	src := mod.Spec.Source
+	digest := ociModuleDigest(mod)
+	if digest == "" {
+		return fmt.Errorf("the Module has not resolved a digest yet")
+	}
+	ref, err := oci.ParseReference(src.URL, src.Version)
+	if err != nil {
+		return err
+	}
+	ref.Tag, ref.Digest = "", digest
+	c, err := ociClientFor(ctx, r.Client, r.OCI, mod.Namespace, src)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+	pullDir, err := os.MkdirTemp(root, ".pull-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(pullDir)
+	limits := archive.DefaultLimits
+	if r.ArchiveLimits != nil {
+		limits = *r.ArchiveLimits
+	}
+	moduleDir, _, err := fetchOCISource(ctx, c, pullDir, ref, limits)
+	if err != nil {
+		return err
+	}
+	if src.Path != "" {
+		if moduleDir, err = resolveModulePath(pullDir, src.Path); err != nil {
+			return err
+		}
+	}
+	return os.Rename(moduleDir, dir)
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	"github.com/junaid18183/astrolabe/internal/oci"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newPrivateOCIRegistry serves the module tarball of writeTestTar as
+// modules/vpc:1.0.0 to the user "ci" with the password "secret" and returns
+// the registry host and the manifest digest.
+func newPrivateOCIRegistry(t *testing.T) (string, string) {
+	t.Helper()
+	var layer bytes.Buffer
+	gz := gzip.NewWriter(&layer)
+	writeTestTar(t, gz)
+	require.NoError(t, gz.Close())
+	digestOf := func(b []byte) string {
+		sum := sha256.Sum256(b)
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+	manifest, err := json.Marshal(oci.Manifest{
+		MediaType: oci.MediaTypeImageManifest,
+		Layers:    []oci.Descriptor{{MediaType: "application/vnd.oci.image.layer.v1.tar+gzip", Digest: digestOf(layer.Bytes()), Size: int64(layer.Len())}},
+	})
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "ci" || pass != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="private"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/modules/vpc/manifests/" + digestOf(manifest):
+			w.Header().Set("Content-Type", oci.MediaTypeImageManifest)
+			_, _ = w.Write(manifest)
+		case "/v2/modules/vpc/blobs/" + digestOf(layer.Bytes()):
+			_, _ = w.Write(layer.Bytes())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://"), digestOf(manifest)
+}
+
+func TestVendorOCIModules(t *testing.T) {
+	ctx := context.Background()
+	host, digest := newPrivateOCIRegistry(t)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "default"},
+		Data:       map[string][]byte{"username": []byte("ci"), "token": []byte("secret")},
+	}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(secret).Build()
+	ociClient := oci.NewClient(nil)
+	ociClient.PlainHTTP = true
+	r := &StackReconciler{Client: c, OCI: ociClient}
+
+	mod := astrolabev1.Module{
+		ObjectMeta: metav1.ObjectMeta{Name: "vpc", Namespace: "default"},
+		Spec: astrolabev1.ModuleSpec{Source: astrolabev1.ModuleSource{
+			Type: "oci", URL: "oci://" + host + "/modules/vpc", Version: "1.0.0",
+		}},
+		Status: astrolabev1.ModuleStatus{ResolvedDigest: digest},
+	}
+	sub := *mod.DeepCopy()
+	sub.Name = "sub"
+	sub.Spec.Source.Path = "*/modules/sub"
+	workDir := t.TempDir()
+	stale := filepath.Join(workDir, vendoredModulesDir, "0000000000000000")
+	require.NoError(t, os.MkdirAll(stale, 0755))
+
+	// Private registries need the Module's secretRef
+	err := r.vendorOCIModules(ctx, workDir, []astrolabev1.Module{mod})
+	assert.ErrorContains(t, err, "requires credentials")
+	mod.Spec.Source.SecretRef = &astrolabev1.ModuleSecretRef{Name: "registry"}
+	sub.Spec.Source.SecretRef = mod.Spec.Source.SecretRef
+
+	require.NoError(t, r.vendorOCIModules(ctx, workDir, []astrolabev1.Module{mod, sub}))
+	assert.FileExists(t, filepath.Join(workDir, vendoredModulePath(mod), "main.tf"), "the archive's top-level directory is the module")
+	assert.FileExists(t, filepath.Join(workDir, vendoredModulePath(sub), "main.tf"), "spec.source.path selects a subdirectory")
+	assert.NoDirExists(t, filepath.Join(workDir, vendoredModulePath(sub), "modules"))
+	assert.NoDirExists(t, stale, "copies no Module uses are removed")
+	entries, err := os.ReadDir(filepath.Join(workDir, vendoredModulesDir))
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "no scratch directories are left behind")
+
+	// An existing copy is reused without contacting the registry
+	r.OCI = oci.NewClient(nil)
+	require.NoError(t, r.vendorOCIModules(ctx, workDir, []astrolabev1.Module{mod}))
+	assert.NoDirExists(t, filepath.Join(workDir, vendoredModulePath(sub)))
+
+	unresolved := *mod.DeepCopy()
+	unresolved.Status.ResolvedDigest = ""
+	err = r.vendorOCIModules(ctx, workDir, []astrolabev1.Module{unresolved})
+	assert.ErrorContains(t, err, "module vpc: the Module has not resolved a digest yet")
+}
@@ -0,0 +1,278 @@
+// Package archive safely extracts module archives (zip and tar, optionally
+// gzip, bzip2 or xz compressed). Extraction rejects entries that would escape
+// the destination directory, absolute paths and links, and enforces limits on
+// the extracted size, number of files and compression ratio so a malicious
+// archive cannot exhaust the node's disk.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ulikunitz/xz"
+)
+
+// Supported archive kinds.
+const (
+	KindZip    = "zip"
+	KindTar    = "tar"
+	KindTarGz  = "tar.gz"
+	KindTarBz2 = "tar.bz2"
+	KindTarXz  = "tar.xz"
+)
+
+// Limits bounds what an archive may expand to. Zero values disable a limit.
+type Limits struct {
+	// MaxTotalSize is the maximum number of bytes written across all files
+	MaxTotalSize int64
+	// MaxFiles is the maximum number of files and directories created
+	MaxFiles int
+	// MaxCompressionRatio is the maximum ratio of extracted bytes to archive bytes
+	MaxCompressionRatio float64
+}
+
+// DefaultLimits comfortably fit real-world Terraform modules.
+var DefaultLimits = Limits{
+	MaxTotalSize:        512 << 20,
+	MaxFiles:            10000,
+	MaxCompressionRatio: 100,
+}
+
+// ratioGracePeriod is how many bytes may be extracted before the compression
+// ratio is enforced, so tiny, highly compressible archives are not rejected.
+const ratioGracePeriod = 1 << 20
+
+// ViolationError reports an archive that is unsafe to extract.
+type ViolationError struct {
+	Entry  string
+	Reason string
+}
+
+func (e *ViolationError) Error() string {
+	if e.Entry == "" {
+		return "unsafe archive: " + e.Reason
+	}
+	return fmt.Sprintf("unsafe archive entry %q: %s", e.Entry, e.Reason)
+}
+
+// Extract extracts the archive at archivePath of the given kind into dest.
+func Extract(archivePath, kind, dest string, limits Limits) error {
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	dest, err = filepath.Abs(dest)
+	if err != nil {
+		return err
+	}
+	x := &extractor{dest: dest, limits: limits, archiveSize: info.Size()}
+
+	if kind == KindZip {
+		return x.zip(archivePath)
+	}
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var stream io.Reader = f
+	switch kind {
+	case KindTar:
+	case KindTarGz:
+		gzr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gzr.Close()
+		stream = gzr
+	case KindTarBz2:
+		stream = bzip2.NewReader(f)
+	case KindTarXz:
+		xzr, err := xz.NewReader(f)
+		if err != nil {
+			return err
+		}
+		stream = xzr
+	default:
+		return fmt.Errorf("unsupported archive type: %s", kind)
+	}
+	return x.tar(stream)
+}
+
+type extractor struct {
+	dest        string
+	limits      Limits
+	archiveSize int64
+	written     int64
+	files       int
+}
+
+func (x *extractor) zip(archivePath string) error {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		mode := f.Mode()
+		switch {
+		case mode&os.ModeSymlink != 0:
+			return &ViolationError{Entry: f.Name, Reason: "symbolic links are not allowed"}
+		case mode.IsDir():
+			if err := x.mkdir(f.Name); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = x.writeFile(f.Name, mode, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			return &ViolationError{Entry: f.Name, Reason: fmt.Sprintf("unsupported file mode %s", mode)}
+		}
+	}
+	return nil
+}
+
+func (x *extractor) tar(stream io.Reader) error {
+	tr := tar.NewReader(stream)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := x.mkdir(hdr.Name); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := x.writeFile(hdr.Name, os.FileMode(hdr.Mode), tr); err != nil {
+				return err
+			}
+		case tar.TypeSymlink, tar.TypeLink:
+			return &ViolationError{Entry: hdr.Name, Reason: "symbolic and hard links are not allowed"}
+		case tar.TypeXGlobalHeader, tar.TypeXHeader:
+			// Metadata only, e.g. GitHub's pax_global_header
+		default:
+			return &ViolationError{Entry: hdr.Name, Reason: fmt.Sprintf("unsupported entry type %q", string(hdr.Typeflag))}
+		}
+	}
+}
+
+// target validates an entry name and returns its destination path.
+func (x *extractor) target(name string) (string, error) {
+	slashed := strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(slashed, "/") || filepath.IsAbs(name) || (len(slashed) > 1 && slashed[1] == ':') {
+		return "", &ViolationError{Entry: name, Reason: "absolute paths are not allowed"}
+	}
+	cleaned := path.Clean(slashed)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", &ViolationError{Entry: name, Reason: "path escapes the destination directory"}
+	}
+	if cleaned == "." {
+		return x.dest, nil
+	}
+	target := filepath.Join(x.dest, filepath.FromSlash(cleaned))
+	// Guard against a directory created earlier being swapped for a link
+	parent, err := filepath.EvalSymlinks(filepath.Dir(target))
+	if err == nil && parent != x.dest && !strings.HasPrefix(parent, x.dest+string(os.PathSeparator)) {
+		return "", &ViolationError{Entry: name, Reason: "path escapes the destination directory"}
+	}
+	return target, nil
+}
+
+func (x *extractor) countFile(name string) error {
+	x.files++
+	if x.limits.MaxFiles > 0 && x.files > x.limits.MaxFiles {
+		return &ViolationError{Entry: name, Reason: fmt.Sprintf("archive contains more than %d files", x.limits.MaxFiles)}
+	}
+	return nil
+}
+
+func (x *extractor) mkdir(name string) error {
+	target, err := x.target(name)
+	if err != nil {
+		return err
+	}
+	if target == x.dest {
+		return nil
+	}
+	if err := x.countFile(name); err != nil {
+		return err
+	}
+	return os.MkdirAll(target, 0755)
+}
+
+func (x *extractor) writeFile(name string, mode os.FileMode, r io.Reader) error {
+	target, err := x.target(name)
+	if err != nil {
+		return err
+	}
+	if err := x.countFile(name); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	// Only permission bits are kept; setuid and friends are dropped
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	budget, reason := x.budget()
+	n, err := io.Copy(out, io.LimitReader(r, budget+1))
+	out.Close()
+	x.written += n
+	if err != nil {
+		return err
+	}
+	if n > budget {
+		return &ViolationError{Entry: name, Reason: reason}
+	}
+	return nil
+}
+
+// budget returns how many more bytes may be written and the violation to
+// report if the budget is exceeded.
+func (x *extractor) budget() (int64, string) {
+	budget := int64(1<<62) - x.written
+	reason := ""
+	if x.limits.MaxTotalSize > 0 {
+		budget = x.limits.MaxTotalSize - x.written
+		reason = fmt.Sprintf("archive expands to more than %d bytes", x.limits.MaxTotalSize)
+	}
+	if x.limits.MaxCompressionRatio > 0 {
+		allowed := int64(x.limits.MaxCompressionRatio * float64(x.archiveSize))
+		if allowed < ratioGracePeriod {
+			allowed = ratioGracePeriod
+		}
+		if allowed-x.written < budget {
+			budget = allowed - x.written
+			reason = fmt.Sprintf("archive exceeds the maximum compression ratio of %g", x.limits.MaxCompressionRatio)
+		}
+	}
+	if budget < 0 {
+		budget = 0
+	}
+	return budget, reason
+}
@@ -0,0 +1,179 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tarEntry struct {
+	hdr  tar.Header
+	body string
+}
+
+func writeTar(t *testing.T, entries []tarEntry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := e.hdr
+		if hdr.Typeflag == 0 {
+			hdr.Typeflag = tar.TypeReg
+		}
+		if hdr.Mode == 0 && hdr.Typeflag != tar.TypeXGlobalHeader {
+			hdr.Mode = 0644
+		}
+		hdr.Size = int64(len(e.body))
+		require.NoError(t, tw.WriteHeader(&hdr))
+		_, err := tw.Write([]byte(e.body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	p := filepath.Join(t.TempDir(), "module.tar")
+	require.NoError(t, os.WriteFile(p, buf.Bytes(), 0644))
+	return p
+}
+
+func assertViolation(t *testing.T, err error, reason string) {
+	t.Helper()
+	var violation *ViolationError
+	require.True(t, errors.As(err, &violation), "expected a ViolationError, got %v", err)
+	assert.Contains(t, violation.Reason, reason)
+}
+
+func TestExtractTar(t *testing.T) {
+	archivePath := writeTar(t, []tarEntry{
+		{hdr: tar.Header{Typeflag: tar.TypeXGlobalHeader, PAXRecords: map[string]string{"comment": "abc123"}}},
+		{hdr: tar.Header{Name: "vpc/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "vpc/main.tf", Mode: 04755}, body: `resource "null_resource" "a" {}`},
+		{hdr: tar.Header{Name: "./vpc/modules/sub/variables.tf"}, body: `variable "x" {}`},
+	})
+	dest := t.TempDir()
+	require.NoError(t, Extract(archivePath, KindTar, dest, DefaultLimits))
+
+	info, err := os.Stat(filepath.Join(dest, "vpc", "main.tf"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode()&^os.ModeType, "setuid bit must be dropped")
+	assert.FileExists(t, filepath.Join(dest, "vpc", "modules", "sub", "variables.tf"))
+}
+
+func TestExtractRejectsUnsafeTarEntries(t *testing.T) {
+	cases := map[string]struct {
+		entry  tarEntry
+		reason string
+	}{
+		"parent traversal": {tarEntry{hdr: tar.Header{Name: "vpc/../../evil.tf"}}, "escapes"},
+		"absolute path":    {tarEntry{hdr: tar.Header{Name: "/etc/cron.d/evil"}}, "absolute"},
+		"drive letter":     {tarEntry{hdr: tar.Header{Name: `C:\Windows\evil`}}, "absolute"},
+		"symlink":          {tarEntry{hdr: tar.Header{Name: "vpc/link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}}, "links"},
+		"hardlink":         {tarEntry{hdr: tar.Header{Name: "vpc/link", Typeflag: tar.TypeLink, Linkname: "vpc/main.tf"}}, "links"},
+		"device":           {tarEntry{hdr: tar.Header{Name: "vpc/dev", Typeflag: tar.TypeChar}}, "unsupported"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			parent := t.TempDir()
+			dest := filepath.Join(parent, "dest")
+			err := Extract(writeTar(t, []tarEntry{tc.entry}), KindTar, dest, DefaultLimits)
+			assertViolation(t, err, tc.reason)
+			assert.NoFileExists(t, filepath.Join(parent, "evil.tf"))
+		})
+	}
+}
+
+func TestExtractRejectsSymlinkedParent(t *testing.T) {
+	dest := t.TempDir()
+	outside := t.TempDir()
+	require.NoError(t, os.Symlink(outside, filepath.Join(dest, "vpc")))
+
+	err := Extract(writeTar(t, []tarEntry{{hdr: tar.Header{Name: "vpc/main.tf"}, body: "x"}}), KindTar, dest, DefaultLimits)
+	assertViolation(t, err, "escapes")
+	assert.NoFileExists(t, filepath.Join(outside, "main.tf"))
+}
+
+func TestExtractZip(t *testing.T) {
+	write := func(t *testing.T, files map[string]string, symlink string) string {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for name, body := range files {
+			w, err := zw.Create(name)
+			require.NoError(t, err)
+			_, err = w.Write([]byte(body))
+			require.NoError(t, err)
+		}
+		if symlink != "" {
+			hdr := &zip.FileHeader{Name: symlink}
+			hdr.SetMode(os.ModeSymlink | 0777)
+			w, err := zw.CreateHeader(hdr)
+			require.NoError(t, err)
+			_, err = w.Write([]byte("/etc/passwd"))
+			require.NoError(t, err)
+		}
+		require.NoError(t, zw.Close())
+		p := filepath.Join(t.TempDir(), "module.zip")
+		require.NoError(t, os.WriteFile(p, buf.Bytes(), 0644))
+		return p
+	}
+
+	dest := t.TempDir()
+	require.NoError(t, Extract(write(t, map[string]string{"vpc/main.tf": "x"}, ""), KindZip, dest, DefaultLimits))
+	assert.FileExists(t, filepath.Join(dest, "vpc", "main.tf"))
+
+	err := Extract(write(t, map[string]string{"../../evil.tf": "x"}, ""), KindZip, t.TempDir(), DefaultLimits)
+	assertViolation(t, err, "escapes")
+
+	err = Extract(write(t, nil, "vpc/link"), KindZip, t.TempDir(), DefaultLimits)
+	assertViolation(t, err, "links")
+}
+
+func TestExtractLimits(t *testing.T) {
+	t.Run("total size", func(t *testing.T) {
+		archivePath := writeTar(t, []tarEntry{
+			{hdr: tar.Header{Name: "a.tf"}, body: strings.Repeat("a", 600)},
+			{hdr: tar.Header{Name: "b.tf"}, body: strings.Repeat("b", 600)},
+		})
+		err := Extract(archivePath, KindTar, t.TempDir(), Limits{MaxTotalSize: 1000})
+		assertViolation(t, err, "more than 1000 bytes")
+	})
+
+	t.Run("file count", func(t *testing.T) {
+		entries := []tarEntry{}
+		for i := 0; i < 5; i++ {
+			entries = append(entries, tarEntry{hdr: tar.Header{Name: filepath.Join("vpc", string(rune('a'+i))+".tf")}})
+		}
+		err := Extract(writeTar(t, entries), KindTar, t.TempDir(), Limits{MaxFiles: 4})
+		assertViolation(t, err, "more than 4 files")
+	})
+
+	t.Run("compression ratio", func(t *testing.T) {
+		// 4MiB of zeros compresses to a few KiB
+		var tarBuf bytes.Buffer
+		tw := tar.NewWriter(&tarBuf)
+		body := make([]byte, 4<<20)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "bomb.tf", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(body))}))
+		_, err := tw.Write(body)
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+		var gz bytes.Buffer
+		gzw := gzip.NewWriter(&gz)
+		_, err = gzw.Write(tarBuf.Bytes())
+		require.NoError(t, err)
+		require.NoError(t, gzw.Close())
+		archivePath := filepath.Join(t.TempDir(), "bomb.tar.gz")
+		require.NoError(t, os.WriteFile(archivePath, gz.Bytes(), 0644))
+
+		err = Extract(archivePath, KindTarGz, t.TempDir(), Limits{MaxCompressionRatio: 100})
+		assertViolation(t, err, "compression ratio")
+
+		// Without a ratio limit the same archive extracts fine
+		require.NoError(t, Extract(archivePath, KindTarGz, t.TempDir(), Limits{}))
+	})
+}
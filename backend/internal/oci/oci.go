@@ -0,0 +1,352 @@
+// Package oci pulls Terraform modules packaged as OCI artifacts using the
+// OCI distribution API. It supports anonymous and bearer-token registries, as
+// well as basic credentials for private ones, and resolves tags to immutable
+// manifest digests.
+//
+// See https://github.com/opencontainers/distribution-spec/blob/main/spec.md
+package oci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MediaTypeImageManifest  = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeImageIndex     = "application/vnd.oci.image.index.v1+json"
+	MediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// Reference identifies a module artifact: registry host, repository and a tag or digest.
+type Reference struct {
+	Registry   string
+	Repository string
+	// Tag is set when the artifact is addressed by tag
+	Tag string
+	// Digest is set when the artifact is addressed by digest, e.g. "sha256:..."
+	Digest string
+}
+
+// ParseReference parses "[oci://]registry/repository[:tag|@digest]". When the
+// address carries neither a tag nor a digest, version is used, and "latest" if
+// that is empty too. version may itself be a tag or a digest.
+func ParseReference(raw, version string) (Reference, error) {
+	raw = strings.TrimPrefix(raw, "oci://")
+	host, repo, ok := strings.Cut(raw, "/")
+	if !ok || host == "" || repo == "" {
+		return Reference{}, fmt.Errorf("invalid OCI reference %q: expected registry/repository", raw)
+	}
+	ref := Reference{Registry: host}
+	if r, digest, found := strings.Cut(repo, "@"); found {
+		repo, ref.Digest = r, digest
+	} else if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		repo, ref.Tag = repo[:i], repo[i+1:]
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		switch {
+		case strings.Contains(version, ":"):
+			ref.Digest = version
+		case version != "":
+			ref.Tag = version
+		default:
+			ref.Tag = "latest"
+		}
+	}
+	if ref.Digest != "" {
+		if _, _, err := parseDigest(ref.Digest); err != nil {
+			return Reference{}, err
+		}
+	}
+	ref.Repository = repo
+	return ref, nil
+}
+
+// String renders the reference as registry/repository followed by @digest or :tag.
+func (r Reference) String() string {
+	if r.Digest != "" {
+		return r.Registry + "/" + r.Repository + "@" + r.Digest
+	}
+	return r.Registry + "/" + r.Repository + ":" + r.Tag
+}
+
+// Descriptor describes a blob referenced from a manifest.
+type Descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// Manifest is the subset of an OCI image manifest used to locate module content.
+type Manifest struct {
+	MediaType    string       `json:"mediaType"`
+	ArtifactType string       `json:"artifactType,omitempty"`
+	Config       Descriptor   `json:"config"`
+	Layers       []Descriptor `json:"layers"`
+}
+
+// Client talks to OCI distribution registries.
+type Client struct {
+	HTTPClient *http.Client
+	// PlainHTTP uses http:// instead of https:// to reach registries, for local test registries
+	PlainHTTP bool
+	// Username and Password authenticate to private registries, either
+	// directly or to obtain a bearer token; anonymous when Password is empty
+	Username string
+	Password string
+
+	mu sync.Mutex
+	// authorizations holds the Authorization header per registry/repository
+	authorizations map[string]string
+}
+
+// NewClient returns a Client using httpClient, or a client with a sane timeout if nil.
+func NewClient(httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 5 * time.Minute}
+	}
+	return &Client{HTTPClient: httpClient, authorizations: map[string]string{}}
+}
+
+// WithCredentials returns a Client sharing c's transport that authenticates
+// with username and password. Tokens obtained by either are not shared.
+func (c *Client) WithCredentials(username, password string) *Client {
+	cc := NewClient(c.HTTPClient)
+	cc.PlainHTTP = c.PlainHTTP
+	cc.Username, cc.Password = username, password
+	return cc
+}
+
+// Resolve fetches the manifest for ref and returns it with its content digest.
+// If ref is addressed by digest, the manifest content is verified against it.
+func (c *Client) Resolve(ctx context.Context, ref Reference) (*Manifest, string, error) {
+	target := ref.Tag
+	if ref.Digest != "" {
+		target = ref.Digest
+	}
+	accept := strings.Join([]string{MediaTypeImageManifest, MediaTypeDockerManifest, MediaTypeImageIndex}, ", ")
+	resp, err := c.get(ctx, ref, "manifests/"+target, accept)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(body)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	if ref.Digest != "" && ref.Digest != digest {
+		return nil, "", fmt.Errorf("manifest digest mismatch: expected %s, got %s", ref.Digest, digest)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, "", fmt.Errorf("decoding manifest for %s: %w", ref, err)
+	}
+	if manifest.MediaType == "" {
+		manifest.MediaType = resp.Header.Get("Content-Type")
+	}
+	if manifest.MediaType == MediaTypeImageIndex {
+		return nil, "", fmt.Errorf("%s is an image index; reference a single module artifact manifest instead", ref)
+	}
+	return &manifest, digest, nil
+}
+
+// ModuleLayer picks the layer holding the module package. Artifacts with a
+// single layer use it; otherwise the first archive-typed layer is chosen.
+func (m *Manifest) ModuleLayer() (Descriptor, error) {
+	if len(m.Layers) == 1 {
+		return m.Layers[0], nil
+	}
+	for _, l := range m.Layers {
+		if ArchiveKind(l.MediaType) != "" {
+			return l, nil
+		}
+	}
+	return Descriptor{}, errors.New("artifact has no layer containing a module archive")
+}
+
+// ArchiveKind maps a layer media type to an archive kind understood by the
+// archive package ("zip", "tar.gz" or "tar"), or "" if it is not an archive.
+func ArchiveKind(mediaType string) string {
+	switch {
+	case strings.HasSuffix(mediaType, "gzip"):
+		return "tar.gz"
+	case strings.HasSuffix(mediaType, "zip"):
+		return "zip"
+	case strings.HasSuffix(mediaType, "tar"):
+		return "tar"
+	}
+	return ""
+}
+
+// FetchBlob streams the blob desc from ref's repository into w, verifying its digest.
+func (c *Client) FetchBlob(ctx context.Context, ref Reference, desc Descriptor, w io.Writer) error {
+	algo, want, err := parseDigest(desc.Digest)
+	if err != nil {
+		return err
+	}
+	resp, err := c.get(ctx, ref, "blobs/"+desc.Digest, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	h := algo()
+	if _, err := io.Copy(io.MultiWriter(w, h), resp.Body); err != nil {
+		return fmt.Errorf("downloading blob %s: %w", desc.Digest, err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("blob digest mismatch: expected %s, got %s", desc.Digest, got)
+	}
+	return nil
+}
+
+func (c *Client) get(ctx context.Context, ref Reference, suffix, accept string) (*http.Response, error) {
+	scheme := "https"
+	if c.PlainHTTP {
+		scheme = "http"
+	}
+	u := (&url.URL{Scheme: scheme, Host: ref.Registry, Path: "/v2/" + ref.Repository + "/" + suffix}).String()
+	do := func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		c.mu.Lock()
+		authorization := c.authorizations[ref.Registry+"/"+ref.Repository]
+		c.mu.Unlock()
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		return c.HTTPClient.Do(req)
+	}
+	resp, err := do()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := c.authenticate(ctx, ref, challenge); err != nil {
+			return nil, err
+		}
+		if resp, err = do(); err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return resp, nil
+}
+
+// authenticate answers a "WWW-Authenticate" challenge. A Basic challenge is
+// met with the Client's credentials; a "Bearer realm=...,service=...,scope=..."
+// challenge runs the token flow, anonymously unless the Client has credentials.
+func (c *Client) authenticate(ctx context.Context, ref Reference, challenge string) error {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	switch {
+	case strings.EqualFold(scheme, "Basic"):
+		if c.Password == "" {
+			return fmt.Errorf("registry %s requires credentials", ref.Registry)
+		}
+		c.setAuthorization(ref, "Basic "+base64.StdEncoding.EncodeToString([]byte(c.Username+":"+c.Password)))
+		return nil
+	case !strings.EqualFold(scheme, "Bearer"):
+		return fmt.Errorf("registry %s requires unsupported authentication %q", ref.Registry, scheme)
+	}
+	attrs := parseChallengeParams(params)
+	realm, err := url.Parse(attrs["realm"])
+	if err != nil || realm.Host == "" {
+		return fmt.Errorf("registry %s returned an invalid token realm %q", ref.Registry, attrs["realm"])
+	}
+	q := realm.Query()
+	if svc := attrs["service"]; svc != "" {
+		q.Set("service", svc)
+	}
+	scope := attrs["scope"]
+	if scope == "" {
+		scope = "repository:" + ref.Repository + ":pull"
+	}
+	q.Set("scope", scope)
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if c.Password != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("requesting registry token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("requesting registry token: %s", resp.Status)
+	}
+	var tok struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return fmt.Errorf("decoding registry token: %w", err)
+	}
+	token := tok.Token
+	if token == "" {
+		token = tok.AccessToken
+	}
+	c.setAuthorization(ref, "Bearer "+token)
+	return nil
+}
+
+func (c *Client) setAuthorization(ref Reference, authorization string) {
+	c.mu.Lock()
+	c.authorizations[ref.Registry+"/"+ref.Repository] = authorization
+	c.mu.Unlock()
+}
+
+func parseChallengeParams(s string) map[string]string {
+	attrs := map[string]string{}
+	for s != "" {
+		var key, value string
+		key, s, _ = strings.Cut(strings.TrimLeft(s, ", "), "=")
+		if strings.HasPrefix(s, `"`) {
+			value, s, _ = strings.Cut(s[1:], `"`)
+		} else {
+			value, s, _ = strings.Cut(s, ",")
+		}
+		attrs[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return attrs
+}
+
+func parseDigest(digest string) (func() hash.Hash, string, error) {
+	algo, hexSum, ok := strings.Cut(digest, ":")
+	if !ok || hexSum == "" {
+		return nil, "", fmt.Errorf("invalid digest %q", digest)
+	}
+	switch algo {
+	case "sha256":
+		return sha256.New, hexSum, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported digest algorithm %q", algo)
+	}
+}
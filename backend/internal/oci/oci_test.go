@@ -0,0 +1,184 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func digestOf(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// newStandInRegistry serves one artifact, modules/vpc:1.0.0, behind the
+// anonymous bearer token flow used by registry:2 with token auth enabled.
+func newStandInRegistry(t *testing.T, layer []byte) (*httptest.Server, string) {
+	t.Helper()
+	manifest, err := json.Marshal(Manifest{
+		MediaType:    MediaTypeImageManifest,
+		ArtifactType: "application/vnd.opentofu.modulepkg",
+		Config:       Descriptor{MediaType: "application/vnd.oci.empty.v1+json", Digest: digestOf([]byte("{}")), Size: 2},
+		Layers:       []Descriptor{{MediaType: "application/vnd.oci.image.layer.v1.tar+gzip", Digest: digestOf(layer), Size: int64(len(layer))}},
+	})
+	require.NoError(t, err)
+	manifestDigest := digestOf(manifest)
+
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "repository:modules/vpc:pull", r.URL.Query().Get("scope"))
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": "anon-token"})
+	})
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer anon-token" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+srv.URL+`/token",service="stand-in"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/modules/vpc/manifests/1.0.0", "/v2/modules/vpc/manifests/" + manifestDigest:
+			w.Header().Set("Content-Type", MediaTypeImageManifest)
+			_, _ = w.Write(manifest)
+		case "/v2/modules/vpc/blobs/" + digestOf(layer):
+			_, _ = w.Write(layer)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	srv = httptest.NewTLSServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, manifestDigest
+}
+
+func TestParseReference(t *testing.T) {
+	ref, err := ParseReference("oci://registry.example.com/modules/vpc", "1.0.0")
+	require.NoError(t, err)
+	assert.Equal(t, Reference{Registry: "registry.example.com", Repository: "modules/vpc", Tag: "1.0.0"}, ref)
+
+	ref, err = ParseReference("localhost:5000/modules/vpc:2.0.0", "")
+	require.NoError(t, err)
+	assert.Equal(t, Reference{Registry: "localhost:5000", Repository: "modules/vpc", Tag: "2.0.0"}, ref)
+
+	ref, err = ParseReference("registry.example.com/modules/vpc", "")
+	require.NoError(t, err)
+	assert.Equal(t, "latest", ref.Tag)
+
+	ref, err = ParseReference("registry.example.com/modules/vpc", "sha256:0123abcd")
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:0123abcd", ref.Digest)
+	assert.Equal(t, "registry.example.com/modules/vpc@sha256:0123abcd", ref.String())
+
+	_, err = ParseReference("registry.example.com/modules/vpc@md5:abc", "")
+	assert.Error(t, err)
+
+	_, err = ParseReference("vpc", "")
+	assert.Error(t, err)
+}
+
+func TestClientPullsFromStandInRegistry(t *testing.T) {
+	layer := []byte("not really a tarball")
+	srv, manifestDigest := newStandInRegistry(t, layer)
+	client := NewClient(srv.Client())
+	ctx := context.Background()
+
+	ref, err := ParseReference(strings.TrimPrefix(srv.URL, "https://")+"/modules/vpc", "1.0.0")
+	require.NoError(t, err)
+
+	manifest, digest, err := client.Resolve(ctx, ref)
+	require.NoError(t, err)
+	assert.Equal(t, manifestDigest, digest)
+
+	desc, err := manifest.ModuleLayer()
+	require.NoError(t, err)
+	assert.Equal(t, "tar.gz", ArchiveKind(desc.MediaType))
+
+	var buf bytes.Buffer
+	require.NoError(t, client.FetchBlob(ctx, ref, desc, &buf))
+	assert.Equal(t, layer, buf.Bytes())
+
+	// Pinning by digest resolves to the same manifest
+	ref.Tag, ref.Digest = "", digest
+	_, pinned, err := client.Resolve(ctx, ref)
+	require.NoError(t, err)
+	assert.Equal(t, digest, pinned)
+}
+
+func TestClientRejectsDigestMismatch(t *testing.T) {
+	srv, _ := newStandInRegistry(t, []byte("layer"))
+	client := NewClient(srv.Client())
+	ref, err := ParseReference(strings.TrimPrefix(srv.URL, "https://")+"/modules/vpc", "1.0.0")
+	require.NoError(t, err)
+
+	manifest, _, err := client.Resolve(context.Background(), ref)
+	require.NoError(t, err)
+	desc := manifest.Layers[0]
+	desc.Digest = digestOf([]byte("something else"))
+	err = client.FetchBlob(context.Background(), ref, desc, &bytes.Buffer{})
+	assert.Error(t, err)
+}
+
+// newPrivateRegistry serves modules/vpc:1.0.0 only to the user "ci" with the
+// password "secret": directly with Basic auth, or through a token realm when
+// bearer is set.
+func newPrivateRegistry(t *testing.T, bearer bool) *httptest.Server {
+	t.Helper()
+	manifest, err := json.Marshal(Manifest{MediaType: MediaTypeImageManifest})
+	require.NoError(t, err)
+
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "ci" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "ci-token"})
+	})
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		authorized := r.Header.Get("Authorization") == "Bearer ci-token"
+		challenge := `Bearer realm="` + srv.URL + `/token"`
+		if !bearer {
+			user, pass, ok := r.BasicAuth()
+			authorized = ok && user == "ci" && pass == "secret"
+			challenge = `Basic realm="private"`
+		}
+		if !authorized {
+			w.Header().Set("WWW-Authenticate", challenge)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", MediaTypeImageManifest)
+		_, _ = w.Write(manifest)
+	})
+	srv = httptest.NewTLSServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClientAuthenticatesToPrivateRegistry(t *testing.T) {
+	for _, bearer := range []bool{false, true} {
+		srv := newPrivateRegistry(t, bearer)
+		ref, err := ParseReference(strings.TrimPrefix(srv.URL, "https://")+"/modules/vpc", "1.0.0")
+		require.NoError(t, err)
+
+		anonymous := NewClient(srv.Client())
+		_, _, err = anonymous.Resolve(context.Background(), ref)
+		assert.Error(t, err, "bearer=%v", bearer)
+
+		_, _, err = anonymous.WithCredentials("ci", "wrong").Resolve(context.Background(), ref)
+		assert.Error(t, err, "bearer=%v", bearer)
+
+		_, _, err = anonymous.WithCredentials("ci", "secret").Resolve(context.Background(), ref)
+		assert.NoError(t, err, "bearer=%v", bearer)
+	}
+}
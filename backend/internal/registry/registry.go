@@ -0,0 +1,254 @@
+// Package registry implements the subset of the Terraform module registry
+// protocol needed to resolve a version constraint to a concrete module version
+// and download location.
+//
+// See https://developer.hashicorp.com/terraform/internals/module-registry-protocol
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	goversion "github.com/hashicorp/go-version"
+)
+
+// DefaultHost is used when a module address does not include a hostname.
+const DefaultHost = "registry.terraform.io"
+
+// Address identifies a module in a registry, e.g. "terraform-aws-modules/vpc/aws".
+type Address struct {
+	Host      string
+	Namespace string
+	Name      string
+	Provider  string
+}
+
+// ParseAddress parses "[host/]namespace/name/provider".
+func ParseAddress(raw string) (Address, error) {
+	parts := strings.Split(strings.Trim(raw, "/"), "/")
+	switch len(parts) {
+	case 3:
+		parts = append([]string{DefaultHost}, parts...)
+	case 4:
+	default:
+		return Address{}, fmt.Errorf("invalid registry module address %q: expected [host/]namespace/name/provider", raw)
+	}
+	for _, p := range parts {
+		if p == "" {
+			return Address{}, fmt.Errorf("invalid registry module address %q: empty segment", raw)
+		}
+	}
+	return Address{Host: parts[0], Namespace: parts[1], Name: parts[2], Provider: parts[3]}, nil
+}
+
+// String returns the address in the form Terraform expects in a module source.
+func (a Address) String() string {
+	short := a.Namespace + "/" + a.Name + "/" + a.Provider
+	if a.Host == DefaultHost {
+		return short
+	}
+	return a.Host + "/" + short
+}
+
+// Client talks to Terraform module registries.
+type Client struct {
+	HTTPClient *http.Client
+
+	mu        sync.Mutex
+	discovery map[string]*url.URL
+}
+
+// NewClient returns a Client using httpClient, or a client with a sane timeout if nil.
+func NewClient(httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &Client{HTTPClient: httpClient, discovery: map[string]*url.URL{}}
+}
+
+// modulesBaseURL performs service discovery for host and returns the modules.v1 endpoint.
+func (c *Client) modulesBaseURL(ctx context.Context, host string) (*url.URL, error) {
+	c.mu.Lock()
+	if u, ok := c.discovery[host]; ok {
+		c.mu.Unlock()
+		return u, nil
+	}
+	c.mu.Unlock()
+
+	wellKnown := &url.URL{Scheme: "https", Host: host, Path: "/.well-known/terraform.json"}
+	var services map[string]interface{}
+	if err := c.getJSON(ctx, wellKnown.String(), &services); err != nil {
+		return nil, fmt.Errorf("service discovery for %s failed: %w", host, err)
+	}
+	raw, ok := services["modules.v1"].(string)
+	if !ok || raw == "" {
+		return nil, fmt.Errorf("host %s does not provide a module registry (modules.v1)", host)
+	}
+	ref, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modules.v1 endpoint %q from %s: %w", raw, host, err)
+	}
+	base := wellKnown.ResolveReference(ref)
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+
+	c.mu.Lock()
+	c.discovery[host] = base
+	c.mu.Unlock()
+	return base, nil
+}
+
+func (c *Client) moduleURL(ctx context.Context, addr Address, suffix string) (*url.URL, error) {
+	base, err := c.modulesBaseURL(ctx, addr.Host)
+	if err != nil {
+		return nil, err
+	}
+	rel := &url.URL{Path: strings.Join([]string{addr.Namespace, addr.Name, addr.Provider, suffix}, "/")}
+	return base.ResolveReference(rel), nil
+}
+
+// Versions lists all versions published for addr.
+func (c *Client) Versions(ctx context.Context, addr Address) ([]string, error) {
+	u, err := c.moduleURL(ctx, addr, "versions")
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Modules []struct {
+			Versions []struct {
+				Version string `json:"version"`
+			} `json:"versions"`
+		} `json:"modules"`
+	}
+	if err := c.getJSON(ctx, u.String(), &resp); err != nil {
+		return nil, fmt.Errorf("listing versions of %s failed: %w", addr, err)
+	}
+	versions := []string{}
+	for _, m := range resp.Modules {
+		for _, v := range m.Versions {
+			versions = append(versions, v.Version)
+		}
+	}
+	return versions, nil
+}
+
+// ResolveVersion returns the newest published version of addr matching constraint.
+// An empty constraint selects the newest non-prerelease version, like Terraform does.
+func (c *Client) ResolveVersion(ctx context.Context, addr Address, constraint string) (string, error) {
+	versions, err := c.Versions(ctx, addr)
+	if err != nil {
+		return "", err
+	}
+	return LatestMatching(versions, constraint)
+}
+
+// LatestMatching picks the newest entry of versions that satisfies constraint.
+func LatestMatching(versions []string, constraint string) (string, error) {
+	var constraints goversion.Constraints
+	if strings.TrimSpace(constraint) != "" {
+		var err error
+		constraints, err = goversion.NewConstraint(constraint)
+		if err != nil {
+			return "", fmt.Errorf("invalid version constraint %q: %w", constraint, err)
+		}
+	}
+	candidates := make([]*goversion.Version, 0, len(versions))
+	for _, raw := range versions {
+		v, err := goversion.NewVersion(raw)
+		if err != nil {
+			continue
+		}
+		// Prereleases are only selected when the constraint names them exactly
+		if v.Prerelease() != "" && !strings.Contains(constraint, v.Original()) {
+			continue
+		}
+		if constraints != nil && !constraints.Check(v) {
+			continue
+		}
+		candidates = append(candidates, v)
+	}
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("no version matches constraint %q (available: %s)", constraint, strings.Join(versions, ", "))
+	}
+	sort.Sort(goversion.Collection(candidates))
+	return candidates[len(candidates)-1].Original(), nil
+}
+
+// DownloadLocation returns the go-getter address the registry hands out for addr at version.
+// Relative locations are resolved against the download endpoint, per the protocol.
+func (c *Client) DownloadLocation(ctx context.Context, addr Address, version string) (string, error) {
+	u, err := c.moduleURL(ctx, addr, version+"/download")
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("download lookup for %s %s failed: %w", addr, version, err)
+	}
+	defer resp.Body.Close()
+
+	location := resp.Header.Get("X-Terraform-Get")
+	switch {
+	case resp.StatusCode == http.StatusNoContent && location != "":
+	case resp.StatusCode == http.StatusOK:
+		if location == "" {
+			var body struct {
+				Location string `json:"location"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				return "", fmt.Errorf("download lookup for %s %s returned no location: %w", addr, version, err)
+			}
+			location = body.Location
+		}
+	default:
+		return "", fmt.Errorf("download lookup for %s %s failed: %s", addr, version, resp.Status)
+	}
+	if location == "" {
+		return "", fmt.Errorf("download lookup for %s %s returned no location", addr, version)
+	}
+	return resolveLocation(u, location), nil
+}
+
+// resolveLocation resolves download locations relative to the registry, as
+// Terraform does: only paths starting with "/", "./" or "../". Everything
+// else, such as "git::https://..." or the "github.com/org/repo" shorthand,
+// is a go-getter address and passed through.
+func resolveLocation(base *url.URL, location string) string {
+	if !strings.HasPrefix(location, "/") && !strings.HasPrefix(location, "./") && !strings.HasPrefix(location, "../") {
+		return location
+	}
+	ref, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+	return base.ResolveReference(ref).String()
+}
+
+func (c *Client) getJSON(ctx context.Context, rawURL string, into interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(into)
+}
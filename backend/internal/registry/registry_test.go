@@ -0,0 +1,116 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newStandInRegistry serves the discovery, versions and download endpoints for
+// a single module, the way a private Terraform registry would.
+func newStandInRegistry(t *testing.T, versions []string) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/terraform.json", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"modules.v1": "/api/modules/v1/"})
+	})
+	mux.HandleFunc("/api/modules/v1/acme/vpc/aws/versions", func(w http.ResponseWriter, _ *http.Request) {
+		list := []map[string]string{}
+		for _, v := range versions {
+			list = append(list, map[string]string{"version": v})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"modules": []interface{}{map[string]interface{}{"versions": list}},
+		})
+	})
+	mux.HandleFunc("/api/modules/v1/acme/vpc/aws/", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/download") {
+			http.NotFound(w, r)
+			return
+		}
+		version := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/modules/v1/acme/vpc/aws/"), "/download")
+		w.Header().Set("X-Terraform-Get", "/archives/vpc-"+version+".tar.gz")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	srv := httptest.NewTLSServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestParseAddress(t *testing.T) {
+	addr, err := ParseAddress("terraform-aws-modules/vpc/aws")
+	require.NoError(t, err)
+	assert.Equal(t, Address{Host: DefaultHost, Namespace: "terraform-aws-modules", Name: "vpc", Provider: "aws"}, addr)
+	assert.Equal(t, "terraform-aws-modules/vpc/aws", addr.String())
+
+	addr, err = ParseAddress("registry.example.com/acme/vpc/aws")
+	require.NoError(t, err)
+	assert.Equal(t, "registry.example.com", addr.Host)
+	assert.Equal(t, "registry.example.com/acme/vpc/aws", addr.String())
+
+	_, err = ParseAddress("acme/vpc")
+	assert.Error(t, err)
+}
+
+func TestLatestMatching(t *testing.T) {
+	versions := []string{"4.9.0", "5.0.0", "5.1.2", "5.10.0", "6.0.0", "6.1.0-beta1"}
+
+	v, err := LatestMatching(versions, "~> 5.0")
+	require.NoError(t, err)
+	assert.Equal(t, "5.10.0", v)
+
+	v, err = LatestMatching(versions, ">= 5.0, < 5.2")
+	require.NoError(t, err)
+	assert.Equal(t, "5.1.2", v)
+
+	v, err = LatestMatching(versions, "")
+	require.NoError(t, err)
+	assert.Equal(t, "6.0.0", v)
+
+	v, err = LatestMatching(versions, "6.1.0-beta1")
+	require.NoError(t, err)
+	assert.Equal(t, "6.1.0-beta1", v)
+
+	_, err = LatestMatching(versions, "~> 7.0")
+	assert.Error(t, err)
+
+	_, err = LatestMatching(versions, "not a constraint")
+	assert.Error(t, err)
+}
+
+func TestClientResolvesAgainstStandInRegistry(t *testing.T) {
+	srv := newStandInRegistry(t, []string{"5.0.0", "5.2.1", "5.3.0", "6.0.0"})
+	host := strings.TrimPrefix(srv.URL, "https://")
+	client := NewClient(srv.Client())
+	ctx := context.Background()
+
+	addr, err := ParseAddress(host + "/acme/vpc/aws")
+	require.NoError(t, err)
+
+	version, err := client.ResolveVersion(ctx, addr, "~> 5.2")
+	require.NoError(t, err)
+	assert.Equal(t, "5.3.0", version)
+
+	location, err := client.DownloadLocation(ctx, addr, version)
+	require.NoError(t, err)
+	assert.Equal(t, srv.URL+"/archives/vpc-5.3.0.tar.gz", location)
+}
+
+func TestResolveLocationKeepsForcedGetters(t *testing.T) {
+	srv := newStandInRegistry(t, nil)
+	base, err := http.NewRequest(http.MethodGet, srv.URL+"/api/modules/v1/acme/vpc/aws/1.0.0/download", nil)
+	require.NoError(t, err)
+	loc := "git::https://example.com/acme/vpc.git?ref=v1.0.0"
+	assert.Equal(t, loc, resolveLocation(base.URL, loc))
+	assert.Equal(t, "https://cdn.example.com/vpc.zip", resolveLocation(base.URL, "https://cdn.example.com/vpc.zip"))
+	// go-getter shorthands are not paths on the registry
+	assert.Equal(t, "github.com/acme/vpc?ref=v1.0.0", resolveLocation(base.URL, "github.com/acme/vpc?ref=v1.0.0"))
+	assert.Equal(t, srv.URL+"/api/modules/v1/acme/vpc/aws/1.0.0/vpc.tar.gz", resolveLocation(base.URL, "./vpc.tar.gz"))
+	assert.Equal(t, srv.URL+"/archives/vpc.tar.gz", resolveLocation(base.URL, "/archives/vpc.tar.gz"))
+}
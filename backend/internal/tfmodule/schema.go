@@ -0,0 +1,150 @@
+package tfmodule
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+// JSONSchemaDialect is the JSON Schema draft InputSchema documents use.
+const JSONSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// legacyTypes maps the quoted type names of Terraform 0.11 to their modern equivalents.
+var legacyTypes = map[string]string{
+	"list": "list(string)",
+	"map":  "map(string)",
+}
+
+// ParseType parses a Terraform type constraint as written in a variable
+// block, e.g. `map(object({ name = string, port = optional(number, 80) }))`,
+// returning the type and the defaults of any optional object attributes.
+// An empty constraint means "any".
+func ParseType(constraint string) (cty.Type, *typeexpr.Defaults, error) {
+	constraint = strings.TrimSpace(constraint)
+	if constraint == "" {
+		return cty.DynamicPseudoType, nil, nil
+	}
+	if modern, ok := legacyTypes[constraint]; ok {
+		constraint = modern
+	}
+	expr, diags := hclsyntax.ParseExpression([]byte(constraint), "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return cty.NilType, nil, fmt.Errorf("invalid type constraint %q: %s", constraint, diags.Error())
+	}
+	ty, defaults, diags := typeexpr.TypeConstraintWithDefaults(expr)
+	if diags.HasErrors() {
+		return cty.NilType, nil, fmt.Errorf("invalid type constraint %q: %s", constraint, diags.Error())
+	}
+	return ty, defaults, nil
+}
+
+// InputSchema returns a JSON Schema describing the object of variable values
+// the module accepts, so callers can validate inputs without understanding
+// Terraform types. Undeclared variables are rejected, as Terraform does.
+func (m *Module) InputSchema() ([]byte, error) {
+	props := map[string]interface{}{}
+	required := []string{}
+	for _, v := range m.Variables {
+		ty, defaults, err := ParseType(v.Type)
+		if err != nil {
+			return nil, fmt.Errorf("variable %q: %w", v.Name, err)
+		}
+		prop := TypeSchema(ty, defaults)
+		if v.Description != "" {
+			prop["description"] = v.Description
+		}
+		if v.Default != nil {
+			prop["default"] = v.Default
+		}
+		if v.Sensitive {
+			prop["writeOnly"] = true
+		}
+		props[v.Name] = prop
+		if v.Required {
+			required = append(required, v.Name)
+		}
+	}
+	schema := map[string]interface{}{
+		"$schema":              JSONSchemaDialect,
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return json.Marshal(schema)
+}
+
+// TypeSchema translates a Terraform type into a JSON Schema fragment.
+// Optional object attributes are left out of "required" and carry their
+// default, if any. Objects allow additional properties because Terraform
+// silently drops unknown attributes when converting to an object type.
+func TypeSchema(ty cty.Type, defaults *typeexpr.Defaults) map[string]interface{} {
+	child := func(key string) *typeexpr.Defaults {
+		if defaults == nil {
+			return nil
+		}
+		return defaults.Children[key]
+	}
+	switch {
+	case ty == cty.String:
+		return map[string]interface{}{"type": "string"}
+	case ty == cty.Number:
+		return map[string]interface{}{"type": "number"}
+	case ty == cty.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case ty.IsListType():
+		return map[string]interface{}{"type": "array", "items": TypeSchema(ty.ElementType(), child(""))}
+	case ty.IsSetType():
+		return map[string]interface{}{"type": "array", "items": TypeSchema(ty.ElementType(), child("")), "uniqueItems": true}
+	case ty.IsMapType():
+		return map[string]interface{}{"type": "object", "additionalProperties": TypeSchema(ty.ElementType(), child(""))}
+	case ty.IsTupleType():
+		elems := ty.TupleElementTypes()
+		items := make([]interface{}, len(elems))
+		for i, et := range elems {
+			items[i] = TypeSchema(et, child(strconv.Itoa(i)))
+		}
+		return map[string]interface{}{"type": "array", "prefixItems": items, "items": false, "minItems": len(elems), "maxItems": len(elems)}
+	case ty.IsObjectType():
+		props := map[string]interface{}{}
+		required := []string{}
+		for name, at := range ty.AttributeTypes() {
+			prop := TypeSchema(at, child(name))
+			if defaults != nil {
+				if def, ok := defaults.DefaultValues[name]; ok && !def.IsNull() {
+					// Terraform applies nested optional attribute defaults to a default value too
+					if nested := child(name); nested != nil {
+						def = nested.Apply(def)
+					}
+					if raw, err := ctyjson.Marshal(def, def.Type()); err == nil {
+						prop["default"] = json.RawMessage(raw)
+					}
+				}
+			}
+			props[name] = prop
+			if !ty.AttributeOptional(name) {
+				required = append(required, name)
+			}
+		}
+		schema := map[string]interface{}{"type": "object", "properties": props}
+		if len(required) > 0 {
+			sort.Strings(required)
+			schema["required"] = required
+		}
+		return schema
+	default:
+		// "any" accepts every value
+		return map[string]interface{}{}
+	}
+}
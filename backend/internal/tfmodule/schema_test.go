@@ -0,0 +1,104 @@
+package tfmodule
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestParseType(t *testing.T) {
+	ty, _, err := ParseType("")
+	require.NoError(t, err)
+	assert.Equal(t, cty.DynamicPseudoType, ty)
+
+	ty, _, err = ParseType("list")
+	require.NoError(t, err)
+	assert.Equal(t, cty.List(cty.String), ty)
+
+	ty, defaults, err := ParseType(`object({ name = string, port = optional(number, 80) })`)
+	require.NoError(t, err)
+	assert.True(t, ty.AttributeOptional("port"))
+	assert.True(t, defaults.DefaultValues["port"].Equals(cty.NumberIntVal(80)).True())
+
+	_, _, err = ParseType("strng")
+	assert.ErrorContains(t, err, "strng")
+	_, _, err = ParseType("map(")
+	assert.Error(t, err)
+}
+
+func schemaFor(t *testing.T, constraint string) string {
+	t.Helper()
+	ty, defaults, err := ParseType(constraint)
+	require.NoError(t, err)
+	out, err := json.Marshal(TypeSchema(ty, defaults))
+	require.NoError(t, err)
+	return string(out)
+}
+
+func TestTypeSchema(t *testing.T) {
+	assert.JSONEq(t, `{"type":"string"}`, schemaFor(t, "string"))
+	assert.JSONEq(t, `{}`, schemaFor(t, "any"))
+	assert.JSONEq(t, `{"type":"array","items":{"type":"number"}}`, schemaFor(t, "list(number)"))
+	assert.JSONEq(t, `{"type":"array","items":{"type":"string"},"uniqueItems":true}`, schemaFor(t, "set(string)"))
+	assert.JSONEq(t, `{"type":"object","additionalProperties":{"type":"boolean"}}`, schemaFor(t, "map(bool)"))
+	assert.JSONEq(t, `{"type":"array","prefixItems":[{"type":"string"},{"type":"number"}],"items":false,"minItems":2,"maxItems":2}`,
+		schemaFor(t, "tuple([string, number])"))
+
+	assert.JSONEq(t, `{
+		"type": "object",
+		"additionalProperties": {
+			"type": "object",
+			"properties": {
+				"cidr": {"type": "string"},
+				"public": {"type": "boolean", "default": false},
+				"tags": {"type": "object", "additionalProperties": {"type": "string"}},
+				"nat": {
+					"type": "object",
+					"properties": {"count": {"type": "number", "default": 1}},
+					"default": {"count": 1}
+				}
+			},
+			"required": ["cidr"]
+		}
+	}`, schemaFor(t, `map(object({
+		cidr   = string
+		public = optional(bool, false)
+		tags   = optional(map(string))
+		nat    = optional(object({ count = optional(number, 1) }), {})
+	}))`))
+}
+
+func TestInputSchema(t *testing.T) {
+	mod := &Module{Variables: []Variable{
+		{Name: "name", Type: "string", Description: "Name prefix", Required: true},
+		{Name: "password", Type: "string", Sensitive: true, Required: true},
+		{Name: "tags", Type: "map(string)", Default: json.RawMessage(`{"team":"platform"}`)},
+		{Name: "anything", Type: "any", Default: json.RawMessage(`null`)},
+	}}
+	out, err := mod.InputSchema()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"type": "object",
+		"additionalProperties": false,
+		"required": ["name", "password"],
+		"properties": {
+			"name": {"type": "string", "description": "Name prefix"},
+			"password": {"type": "string", "writeOnly": true},
+			"tags": {"type": "object", "additionalProperties": {"type": "string"}, "default": {"team": "platform"}},
+			"anything": {"default": null}
+		}
+	}`, string(out))
+}
+
+func TestLoadRejectsInvalidType(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"variables.tf": "variable \"ok\" { type = string }\nvariable \"bad\" {\n  type = lisst(string)\n}\n",
+	})
+	_, err := Load(dir)
+	assert.ErrorContains(t, err, "variables.tf:3,")
+	assert.ErrorContains(t, err, "Invalid type constraint")
+}
@@ -0,0 +1,390 @@
+// Package tfmodule reads the interface of a Terraform module (its variables,
+// outputs, provider requirements, resources and module calls) directly from
+// the .tf and .tf.json files in a directory, without running terraform or
+// terraform-docs.
+package tfmodule
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+// Module is the parsed interface of a Terraform module.
+type Module struct {
+	Variables         []Variable
+	Outputs           []Output
+	Providers         []Provider
+	RequiredVersion   string
+	RequiredProviders map[string]string
+	Resources         []Resource
+	ModuleCalls       []ModuleCall
+}
+
+// Variable is an input variable declared with a variable block.
+type Variable struct {
+	Name        string
+	Type        string
+	Description string
+	// Default is the JSON encoding of the default value, or nil if there is none
+	Default   json.RawMessage
+	Required  bool
+	Sensitive bool
+}
+
+// Output is a value declared with an output block.
+type Output struct {
+	Name        string
+	Description string
+	Sensitive   bool
+}
+
+// Provider is a provider the module requires or configures.
+type Provider struct {
+	Name    string
+	Source  string
+	Version string
+}
+
+// Resource is a managed resource or data source.
+type Resource struct {
+	Mode string // "managed" or "data"
+	Type string
+	Name string
+}
+
+// ModuleCall is a child module called with a module block.
+type ModuleCall struct {
+	Name   string
+	Source string
+}
+
+// Diagnostic is a problem found while parsing, located by file and position.
+type Diagnostic struct {
+	Filename string
+	Line     int
+	Column   int
+	Summary  string
+	Detail   string
+}
+
+func (d Diagnostic) String() string {
+	msg := d.Summary
+	if d.Detail != "" {
+		msg += ": " + d.Detail
+	}
+	if d.Filename == "" {
+		return msg
+	}
+	return fmt.Sprintf("%s:%d,%d: %s", d.Filename, d.Line, d.Column, msg)
+}
+
+// Diagnostics is returned as the error from Load when the module has errors.
+type Diagnostics []Diagnostic
+
+func (diags Diagnostics) Error() string {
+	msgs := make([]string, len(diags))
+	for i, d := range diags {
+		msgs[i] = d.String()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+var fileSchema = &hcl.BodySchema{
+	Blocks: []hcl.BlockHeaderSchema{
+		{Type: "terraform"},
+		{Type: "variable", LabelNames: []string{"name"}},
+		{Type: "output", LabelNames: []string{"name"}},
+		{Type: "provider", LabelNames: []string{"name"}},
+		{Type: "resource", LabelNames: []string{"type", "name"}},
+		{Type: "data", LabelNames: []string{"type", "name"}},
+		{Type: "module", LabelNames: []string{"name"}},
+	},
+}
+
+var terraformSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{{Name: "required_version"}},
+	Blocks:     []hcl.BlockHeaderSchema{{Type: "required_providers"}},
+}
+
+var variableSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{Name: "type"},
+		{Name: "description"},
+		{Name: "default"},
+		{Name: "sensitive"},
+	},
+}
+
+var outputSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{Name: "description"},
+		{Name: "sensitive"},
+	},
+}
+
+var moduleCallSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{{Name: "source"}},
+}
+
+// Load parses the Terraform configuration files in dir. Override files are
+// ignored since they cannot change a module's interface in practice. Errors
+// are returned as Diagnostics with file names relative to dir.
+func Load(dir string) (*Module, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	l := &loader{
+		dir:    dir,
+		parser: hclparse.NewParser(),
+		mod:    &Module{RequiredProviders: map[string]string{}},
+	}
+	found := false
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || isOverrideFile(name) {
+			continue
+		}
+		var file *hcl.File
+		var diags hcl.Diagnostics
+		switch {
+		case strings.HasSuffix(name, ".tf"):
+			file, diags = l.parser.ParseHCLFile(filepath.Join(dir, name))
+		case strings.HasSuffix(name, ".tf.json"):
+			file, diags = l.parser.ParseJSONFile(filepath.Join(dir, name))
+		default:
+			continue
+		}
+		found = true
+		l.addDiags(diags)
+		if file != nil {
+			l.loadFile(file)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no Terraform configuration files found in %s", filepath.Base(dir))
+	}
+	if len(l.diags) > 0 {
+		return nil, l.diags
+	}
+	l.finish()
+	return l.mod, nil
+}
+
+func isOverrideFile(name string) bool {
+	base := strings.TrimSuffix(strings.TrimSuffix(name, ".json"), ".tf")
+	return base == "override" || strings.HasSuffix(base, "_override")
+}
+
+type loader struct {
+	dir       string
+	parser    *hclparse.Parser
+	mod       *Module
+	diags     Diagnostics
+	providers map[string]*Provider
+}
+
+// addDiags records error diagnostics; warnings do not affect the interface.
+func (l *loader) addDiags(diags hcl.Diagnostics) {
+	for _, d := range diags {
+		if d.Severity != hcl.DiagError {
+			continue
+		}
+		diag := Diagnostic{Summary: d.Summary, Detail: d.Detail}
+		if d.Subject != nil {
+			diag.Filename = d.Subject.Filename
+			if rel, err := filepath.Rel(l.dir, d.Subject.Filename); err == nil {
+				diag.Filename = rel
+			}
+			diag.Line = d.Subject.Start.Line
+			diag.Column = d.Subject.Start.Column
+		}
+		l.diags = append(l.diags, diag)
+	}
+}
+
+func (l *loader) provider(name string) *Provider {
+	if l.providers == nil {
+		l.providers = map[string]*Provider{}
+	}
+	p, ok := l.providers[name]
+	if !ok {
+		p = &Provider{Name: name}
+		l.providers[name] = p
+	}
+	return p
+}
+
+func (l *loader) loadFile(file *hcl.File) {
+	content, _, diags := file.Body.PartialContent(fileSchema)
+	l.addDiags(diags)
+	for _, block := range content.Blocks {
+		switch block.Type {
+		case "terraform":
+			l.loadTerraform(block)
+		case "variable":
+			l.loadVariable(file, block)
+		case "output":
+			l.loadOutput(block)
+		case "provider":
+			l.provider(block.Labels[0])
+		case "resource", "data":
+			mode := "managed"
+			if block.Type == "data" {
+				mode = "data"
+			}
+			l.mod.Resources = append(l.mod.Resources, Resource{Mode: mode, Type: block.Labels[0], Name: block.Labels[1]})
+			// Without an explicit provider argument, the provider is implied by
+			// the type prefix; terraform_data and friends are built in
+			if prefix := strings.SplitN(block.Labels[0], "_", 2)[0]; prefix != "terraform" {
+				l.provider(prefix)
+			}
+		case "module":
+			content, _, diags := block.Body.PartialContent(moduleCallSchema)
+			l.addDiags(diags)
+			call := ModuleCall{Name: block.Labels[0]}
+			if attr, ok := content.Attributes["source"]; ok {
+				l.addDiags(gohcl.DecodeExpression(attr.Expr, nil, &call.Source))
+			}
+			l.mod.ModuleCalls = append(l.mod.ModuleCalls, call)
+		}
+	}
+}
+
+func (l *loader) loadTerraform(block *hcl.Block) {
+	content, _, diags := block.Body.PartialContent(terraformSchema)
+	l.addDiags(diags)
+	if attr, ok := content.Attributes["required_version"]; ok {
+		var constraint string
+		l.addDiags(gohcl.DecodeExpression(attr.Expr, nil, &constraint))
+		if l.mod.RequiredVersion != "" && constraint != "" {
+			constraint = l.mod.RequiredVersion + ", " + constraint
+		}
+		l.mod.RequiredVersion = constraint
+	}
+	for _, rp := range content.Blocks {
+		attrs, diags := rp.Body.JustAttributes()
+		l.addDiags(diags)
+		for name, attr := range attrs {
+			p := l.provider(name)
+			// Legacy form: aws = "~> 5.0"
+			if val, diags := attr.Expr.Value(nil); !diags.HasErrors() && val.Type() == cty.String && !val.IsNull() {
+				p.Version = val.AsString()
+				l.mod.RequiredProviders[name] = p.Version
+				continue
+			}
+			// Object form; configuration_aliases holds references, so only
+			// source and version are evaluated
+			pairs, diags := hcl.ExprMap(attr.Expr)
+			l.addDiags(diags)
+			for _, pair := range pairs {
+				key := hcl.ExprAsKeyword(pair.Key)
+				if key == "" {
+					if k, diags := pair.Key.Value(nil); !diags.HasErrors() && k.Type() == cty.String {
+						key = k.AsString()
+					}
+				}
+				switch key {
+				case "source":
+					l.addDiags(gohcl.DecodeExpression(pair.Value, nil, &p.Source))
+				case "version":
+					l.addDiags(gohcl.DecodeExpression(pair.Value, nil, &p.Version))
+				}
+			}
+			l.mod.RequiredProviders[name] = p.Version
+		}
+	}
+}
+
+func (l *loader) loadVariable(file *hcl.File, block *hcl.Block) {
+	content, _, diags := block.Body.PartialContent(variableSchema)
+	l.addDiags(diags)
+	v := Variable{Name: block.Labels[0], Type: "any", Required: true}
+	if attr, ok := content.Attributes["type"]; ok {
+		v.Type = typeExprString(file, attr.Expr)
+		if _, _, err := ParseType(v.Type); err != nil {
+			rng := attr.Expr.Range()
+			l.addDiags(hcl.Diagnostics{{Severity: hcl.DiagError, Summary: "Invalid type constraint", Detail: err.Error(), Subject: &rng}})
+		}
+	}
+	if attr, ok := content.Attributes["description"]; ok {
+		l.addDiags(gohcl.DecodeExpression(attr.Expr, nil, &v.Description))
+	}
+	if attr, ok := content.Attributes["sensitive"]; ok {
+		l.addDiags(gohcl.DecodeExpression(attr.Expr, nil, &v.Sensitive))
+	}
+	if attr, ok := content.Attributes["default"]; ok {
+		v.Required = false
+		val, diags := attr.Expr.Value(nil)
+		l.addDiags(diags)
+		if !diags.HasErrors() && !val.IsNull() && val.IsWhollyKnown() {
+			raw, err := ctyjson.Marshal(val, val.Type())
+			if err != nil {
+				rng := attr.Expr.Range()
+				l.addDiags(hcl.Diagnostics{{Severity: hcl.DiagError, Summary: "Invalid default value", Detail: err.Error(), Subject: &rng}})
+			} else {
+				v.Default = raw
+			}
+		}
+	}
+	l.mod.Variables = append(l.mod.Variables, v)
+}
+
+// typeExprString returns a type constraint as written, e.g. "map(string)".
+// Type constraints are keywords and function calls rather than values, so the
+// source text is used; JSON files and legacy quoted types hold a plain string.
+func typeExprString(file *hcl.File, expr hcl.Expression) string {
+	if val, diags := expr.Value(nil); !diags.HasErrors() && val.Type() == cty.String && !val.IsNull() {
+		return val.AsString()
+	}
+	rng := expr.Range()
+	if rng.End.Byte > len(file.Bytes) || rng.Start.Byte >= rng.End.Byte {
+		return "any"
+	}
+	return strings.TrimSpace(string(rng.SliceBytes(file.Bytes)))
+}
+
+func (l *loader) loadOutput(block *hcl.Block) {
+	content, _, diags := block.Body.PartialContent(outputSchema)
+	l.addDiags(diags)
+	o := Output{Name: block.Labels[0]}
+	if attr, ok := content.Attributes["description"]; ok {
+		l.addDiags(gohcl.DecodeExpression(attr.Expr, nil, &o.Description))
+	}
+	if attr, ok := content.Attributes["sensitive"]; ok {
+		l.addDiags(gohcl.DecodeExpression(attr.Expr, nil, &o.Sensitive))
+	}
+	l.mod.Outputs = append(l.mod.Outputs, o)
+}
+
+// finish sorts everything by name, as terraform-docs does, so the result does
+// not depend on file order.
+func (l *loader) finish() {
+	for _, p := range l.providers {
+		l.mod.Providers = append(l.mod.Providers, *p)
+	}
+	sort.Slice(l.mod.Variables, func(i, j int) bool { return l.mod.Variables[i].Name < l.mod.Variables[j].Name })
+	sort.Slice(l.mod.Outputs, func(i, j int) bool { return l.mod.Outputs[i].Name < l.mod.Outputs[j].Name })
+	sort.Slice(l.mod.Providers, func(i, j int) bool { return l.mod.Providers[i].Name < l.mod.Providers[j].Name })
+	sort.Slice(l.mod.ModuleCalls, func(i, j int) bool { return l.mod.ModuleCalls[i].Name < l.mod.ModuleCalls[j].Name })
+	sort.Slice(l.mod.Resources, func(i, j int) bool {
+		a, b := l.mod.Resources[i], l.mod.Resources[j]
+		if a.Mode != b.Mode {
+			return a.Mode == "managed"
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Name < b.Name
+	})
+}
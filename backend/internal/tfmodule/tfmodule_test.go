@@ -0,0 +1,158 @@
+package tfmodule
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeModule(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestLoad(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"versions.tf": `
+terraform {
+  required_version = ">= 1.3"
+  required_providers {
+    aws = {
+      source                = "hashicorp/aws"
+      version               = "~> 5.0"
+      configuration_aliases = [aws.peer]
+    }
+    random = "~> 3.0"
+  }
+}
+`,
+		"variables.tf": `
+variable "name" {
+  description = "Name prefix"
+  type        = string
+}
+
+variable "tags" {
+  type = map(string)
+  default = {
+    team = "platform"
+  }
+}
+
+variable "subnets" {
+  type = list(object({
+    cidr = string
+    az   = string
+  }))
+  default = []
+}
+
+variable "password" {
+  type      = string
+  sensitive = true
+  default   = null
+}
+
+variable "untyped" {}
+`,
+		"main.tf": `
+locals {
+  prefix = var.name
+}
+
+resource "aws_vpc" "this" {
+  cidr_block = "10.0.0.0/16"
+}
+
+data "aws_region" "current" {}
+
+resource "terraform_data" "marker" {}
+
+module "subnets" {
+  source = "./modules/subnets"
+  vpc_id = aws_vpc.this.id
+}
+`,
+		"outputs.tf.json": `{
+  "output": {
+    "vpc_id": {"value": "${aws_vpc.this.id}", "description": "VPC ID"},
+    "secret": {"value": "${var.password}", "sensitive": true}
+  },
+  "variable": {
+    "json_var": {"type": "list(string)", "default": ["a"]}
+  }
+}`,
+		"override.tf": `variable "name" { default = "ignored" }`,
+		"README.md":   "# not terraform",
+	})
+
+	mod, err := Load(dir)
+	require.NoError(t, err)
+
+	vars := map[string]Variable{}
+	for _, v := range mod.Variables {
+		vars[v.Name] = v
+	}
+	require.Len(t, vars, 6)
+	assert.Equal(t, Variable{Name: "name", Type: "string", Description: "Name prefix", Required: true}, vars["name"])
+	assert.Equal(t, "map(string)", vars["tags"].Type)
+	assert.JSONEq(t, `{"team":"platform"}`, string(vars["tags"].Default))
+	assert.False(t, vars["tags"].Required)
+	assert.Contains(t, vars["subnets"].Type, "list(object({")
+	assert.JSONEq(t, `[]`, string(vars["subnets"].Default))
+	assert.True(t, vars["password"].Sensitive)
+	assert.False(t, vars["password"].Required)
+	assert.Nil(t, vars["password"].Default)
+	assert.Equal(t, "any", vars["untyped"].Type)
+	assert.True(t, vars["untyped"].Required)
+	assert.Equal(t, "list(string)", vars["json_var"].Type)
+	assert.JSONEq(t, `["a"]`, string(vars["json_var"].Default))
+	assert.Equal(t, "json_var", mod.Variables[0].Name, "variables are sorted by name")
+
+	assert.Equal(t, []Output{{Name: "secret", Sensitive: true}, {Name: "vpc_id", Description: "VPC ID"}}, mod.Outputs)
+
+	assert.Equal(t, ">= 1.3", mod.RequiredVersion)
+	assert.Equal(t, map[string]string{"aws": "~> 5.0", "random": "~> 3.0"}, mod.RequiredProviders)
+	assert.Equal(t, []Provider{
+		{Name: "aws", Source: "hashicorp/aws", Version: "~> 5.0"},
+		{Name: "random", Version: "~> 3.0"},
+	}, mod.Providers)
+
+	assert.Equal(t, []Resource{
+		{Mode: "managed", Type: "aws_vpc", Name: "this"},
+		{Mode: "managed", Type: "terraform_data", Name: "marker"},
+		{Mode: "data", Type: "aws_region", Name: "current"},
+	}, mod.Resources)
+	assert.Equal(t, []ModuleCall{{Name: "subnets", Source: "./modules/subnets"}}, mod.ModuleCalls)
+}
+
+func TestLoadDiagnostics(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"main.tf": "variable \"ok\" {}\n\nvariable \"broken\" {\n  type = string\n",
+	})
+	_, err := Load(dir)
+	var diags Diagnostics
+	require.ErrorAs(t, err, &diags)
+	require.NotEmpty(t, diags)
+	assert.Equal(t, "main.tf", diags[0].Filename)
+	assert.Equal(t, 3, diags[0].Line)
+	assert.Contains(t, err.Error(), "main.tf:3,")
+
+	dir = writeModule(t, map[string]string{
+		"variables.tf": "variable \"count\" {\n  default = var.other\n}\n",
+	})
+	_, err = Load(dir)
+	assert.ErrorContains(t, err, "variables.tf:2,")
+}
+
+func TestLoadEmptyDir(t *testing.T) {
+	_, err := Load(writeModule(t, map[string]string{"README.md": "hi"}))
+	assert.ErrorContains(t, err, "no Terraform configuration files")
+}
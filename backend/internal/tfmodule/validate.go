@@ -0,0 +1,148 @@
+package tfmodule
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
+	"github.com/zclconf/go-cty/cty"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// ValidateValue checks a JSON-decoded value (decoded with UseNumber) against a
+// Terraform type, reporting every mismatch with its path. It follows
+// Terraform's conversion rules: primitives convert to and from strings,
+// null is accepted anywhere, and extra object attributes are ignored.
+// sensitive keeps values out of the error messages.
+func ValidateValue(ty cty.Type, value interface{}, path *field.Path, sensitive bool) field.ErrorList {
+	var errs field.ErrorList
+	invalid := func(detail string) {
+		var shown interface{} = field.OmitValueType{}
+		switch value.(type) {
+		case string, json.Number, bool:
+			if !sensitive {
+				shown = value
+			}
+		}
+		errs = append(errs, field.Invalid(path, shown, detail))
+	}
+	mismatch := func() {
+		invalid(fmt.Sprintf("must be %s, not %s", typeexpr.TypeString(ty), jsonKind(value)))
+	}
+	if value == nil || ty == cty.DynamicPseudoType {
+		return nil
+	}
+	switch {
+	case ty == cty.String:
+		switch value.(type) {
+		case string, json.Number, bool:
+		default:
+			mismatch()
+		}
+	case ty == cty.Number:
+		switch v := value.(type) {
+		case json.Number:
+		case string:
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				invalid("must be number, not a non-numeric string")
+			}
+		default:
+			mismatch()
+		}
+	case ty == cty.Bool:
+		switch v := value.(type) {
+		case bool:
+		case string:
+			if v != "true" && v != "false" {
+				invalid(`must be bool; only the strings "true" and "false" convert`)
+			}
+		default:
+			mismatch()
+		}
+	case ty.IsListType() || ty.IsSetType():
+		items, ok := value.([]interface{})
+		if !ok {
+			mismatch()
+			break
+		}
+		for i, item := range items {
+			errs = append(errs, ValidateValue(ty.ElementType(), item, path.Index(i), sensitive)...)
+		}
+	case ty.IsTupleType():
+		items, ok := value.([]interface{})
+		if !ok {
+			mismatch()
+			break
+		}
+		elems := ty.TupleElementTypes()
+		if len(items) != len(elems) {
+			invalid(fmt.Sprintf("must have exactly %d elements, not %d", len(elems), len(items)))
+			break
+		}
+		for i, item := range items {
+			errs = append(errs, ValidateValue(elems[i], item, path.Index(i), sensitive)...)
+		}
+	case ty.IsMapType():
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			mismatch()
+			break
+		}
+		for _, key := range sortedKeys(obj) {
+			errs = append(errs, ValidateValue(ty.ElementType(), obj[key], path.Key(key), sensitive)...)
+		}
+	case ty.IsObjectType():
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			mismatch()
+			break
+		}
+		attrs := ty.AttributeTypes()
+		names := make([]string, 0, len(attrs))
+		for name := range attrs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			v, present := obj[name]
+			if !present {
+				if !ty.AttributeOptional(name) {
+					errs = append(errs, field.Required(path.Child(name), "attribute is required by "+typeexpr.TypeString(ty)))
+				}
+				continue
+			}
+			errs = append(errs, ValidateValue(attrs[name], v, path.Child(name), sensitive)...)
+		}
+	}
+	return errs
+}
+
+func jsonKind(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case json.Number, float64:
+		return "number"
+	case bool:
+		return "bool"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,75 @@
+package tfmodule
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func decodeJSON(t *testing.T, raw string) interface{} {
+	t.Helper()
+	dec := json.NewDecoder(bytes.NewReader([]byte(raw)))
+	dec.UseNumber()
+	var v interface{}
+	require.NoError(t, dec.Decode(&v))
+	return v
+}
+
+func validate(t *testing.T, constraint, raw string) field.ErrorList {
+	t.Helper()
+	ty, _, err := ParseType(constraint)
+	require.NoError(t, err)
+	return ValidateValue(ty, decodeJSON(t, raw), field.NewPath("vars", "x"), false)
+}
+
+func TestValidateValueAcceptsConvertibleValues(t *testing.T) {
+	cases := map[string][]string{
+		"string":                           {`"a"`, `1`, `true`, `null`},
+		"number":                           {`1.5`, `"42"`},
+		"bool":                             {`false`, `"true"`},
+		"list(string)":                     {`[]`, `["a", 1]`},
+		"set(number)":                      {`[1, 1]`},
+		"map(bool)":                        {`{"a": true}`},
+		"tuple([string, number])":          {`["a", 1]`},
+		"any":                              {`{"anything": [1]}`},
+		"object({ a = string })":           {`{"a": "x", "extra": 1}`},
+		"object({ a = optional(string) })": {`{}`},
+	}
+	for constraint, values := range cases {
+		for _, raw := range values {
+			assert.Empty(t, validate(t, constraint, raw), "%s %s", constraint, raw)
+		}
+	}
+}
+
+func TestValidateValueReportsEveryMismatch(t *testing.T) {
+	errs := validate(t, `list(object({ cidr = string, az = string, public = optional(bool) }))`,
+		`[{"cidr": "10.0.0.0/24", "az": "a"}, {"cidr": ["x"], "public": "yes"}, "oops"]`)
+	require.Len(t, errs, 4)
+	assert.Equal(t, "vars.x[1].az", errs[0].Field)
+	assert.Equal(t, field.ErrorTypeRequired, errs[0].Type)
+	assert.Equal(t, "vars.x[1].cidr", errs[1].Field)
+	assert.Contains(t, errs[1].Detail, "must be string, not array")
+	assert.Equal(t, "vars.x[1].public", errs[2].Field)
+	assert.Equal(t, "vars.x[2]", errs[3].Field)
+
+	errs = validate(t, "map(number)", `{"a": 1, "b": "two"}`)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "vars.x[b]", errs[0].Field)
+
+	errs = validate(t, "tuple([string, number])", `["a"]`)
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Detail, "exactly 2 elements")
+}
+
+func TestValidateValueHidesSensitiveValues(t *testing.T) {
+	ty, _, err := ParseType("number")
+	require.NoError(t, err)
+	errs := ValidateValue(ty, "hunter2", field.NewPath("password"), true)
+	require.Len(t, errs, 1)
+	assert.NotContains(t, errs.ToAggregate().Error(), "hunter2")
+}
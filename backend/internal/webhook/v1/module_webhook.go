@@ -0,0 +1,165 @@
+package v1
+
+import (
+	"context"
+	"fmt"
+	neturl "net/url"
+	"regexp"
+	"strings"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	"github.com/junaid18183/astrolabe/internal/oci"
+	"github.com/junaid18183/astrolabe/internal/registry"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var modulelog = logf.Log.WithName("module-resource")
+
+// scpLikeGitURL matches the "user@host:path" form git accepts for SSH remotes.
+var scpLikeGitURL = regexp.MustCompile(`^[A-Za-z0-9._~-]+@[A-Za-z0-9.-]+:[^/]`)
+
+// SetupModuleWebhookWithManager registers the validating webhook for Module in the manager.
+func SetupModuleWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&astrolabev1.Module{}).
+		WithValidator(&ModuleCustomValidator{}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/validate-astrolabe-io-v1-module,mutating=false,failurePolicy=fail,sideEffects=None,groups=astrolabe.io,resources=modules,verbs=create;update,versions=v1,name=vmodule-v1.kb.io,admissionReviewVersions=v1
+
+// ModuleCustomValidator rejects Modules whose source URL cannot be fetched
+// with their source type.
+type ModuleCustomValidator struct{}
+
+var _ webhook.CustomValidator = &ModuleCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator.
+func (v *ModuleCustomValidator) ValidateCreate(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	mod, ok := obj.(*astrolabev1.Module)
+	if !ok {
+		return nil, fmt.Errorf("expected a Module object but got %T", obj)
+	}
+	modulelog.Info("Validation for Module upon creation", "name", mod.GetName())
+	return nil, moduleInvalid(mod, validateModule(mod))
+}
+
+// ValidateUpdate implements webhook.CustomValidator.
+func (v *ModuleCustomValidator) ValidateUpdate(_ context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldMod, ok := oldObj.(*astrolabev1.Module)
+	if !ok {
+		return nil, fmt.Errorf("expected a Module object for the oldObj but got %T", oldObj)
+	}
+	mod, ok := newObj.(*astrolabev1.Module)
+	if !ok {
+		return nil, fmt.Errorf("expected a Module object for the newObj but got %T", newObj)
+	}
+	modulelog.Info("Validation for Module upon update", "name", mod.GetName())
+	if mod.DeletionTimestamp != nil || apiequality.Semantic.DeepEqual(oldMod.Spec, mod.Spec) {
+		return nil, nil
+	}
+	return nil, moduleInvalid(mod, validateModule(mod))
+}
+
+// ValidateDelete implements webhook.CustomValidator.
+func (v *ModuleCustomValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func moduleInvalid(mod *astrolabev1.Module, errs field.ErrorList) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(astrolabev1.GroupVersion.WithKind("Module").GroupKind(), mod.Name, errs)
+}
+
+func validateModule(mod *astrolabev1.Module) field.ErrorList {
+	path := field.NewPath("spec", "source")
+	return append(validateModuleSource(mod.Spec.Source, path), validateModuleVerification(mod.Spec.Source, path)...)
+}
+
+// validateModuleVerification rejects checksum and verification on sources
+// whose verified content would not be what Stacks run: terraform init
+// downloads registry modules again, and oci digests already pin the content.
+func validateModuleVerification(src astrolabev1.ModuleSource, path *field.Path) field.ErrorList {
+	if src.Type != "registry" && src.Type != "oci" {
+		return nil
+	}
+	var errs field.ErrorList
+	detail := fmt.Sprintf("is not supported for %s sources", src.Type)
+	if src.Checksum != "" {
+		errs = append(errs, field.Forbidden(path.Child("checksum"), detail))
+	}
+	if src.Verification != nil {
+		errs = append(errs, field.Forbidden(path.Child("verification"), detail))
+	}
+	return errs
+}
+
+// validateModuleSource checks that the URL has the form the source type fetches.
+func validateModuleSource(src astrolabev1.ModuleSource, path *field.Path) field.ErrorList {
+	urlPath := path.Child("url")
+	if src.URL == "" {
+		return field.ErrorList{field.Required(urlPath, "")}
+	}
+	invalid := func(detail string) field.ErrorList {
+		return field.ErrorList{field.Invalid(urlPath, src.URL, detail)}
+	}
+	switch src.Type {
+	case "git":
+		if strings.HasPrefix(src.URL, "git::") {
+			return invalid(`must be a plain clone URL without the "git::" prefix; use version for the ref and path for a subdirectory`)
+		}
+		if scpLikeGitURL.MatchString(src.URL) {
+			return nil
+		}
+		u, err := neturl.Parse(src.URL)
+		if err != nil {
+			return invalid("must be a git clone URL: " + err.Error())
+		}
+		// Only authenticated, encrypted remotes: file would read the manager's own
+		// disk, and http or git are open to tampering in transit
+		switch u.Scheme {
+		case "https", "ssh":
+			if u.Host == "" {
+				return invalid("must include a host")
+			}
+		case "oci":
+			return invalid(`must be a git clone URL; use type "oci" for OCI artifacts`)
+		default:
+			return invalid(`must be a git clone URL using https or ssh, or "user@host:path"`)
+		}
+	case "http":
+		u, err := neturl.Parse(src.URL)
+		if err != nil {
+			return invalid("must be an http or https archive URL: " + err.Error())
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return invalid("must be an http or https archive URL")
+		}
+		if u.Host == "" {
+			return invalid("must include a host")
+		}
+	case "registry":
+		if strings.Contains(src.URL, "://") {
+			return invalid("must be a registry module address such as namespace/name/provider, not a URL")
+		}
+		if _, err := registry.ParseAddress(src.URL); err != nil {
+			return invalid(err.Error())
+		}
+	case "oci":
+		if scheme, _, found := strings.Cut(src.URL, "://"); found && scheme != "oci" {
+			return invalid(`must be an OCI reference such as "oci://registry/repository"`)
+		}
+		if _, err := oci.ParseReference(src.URL, src.Version); err != nil {
+			return invalid(err.Error())
+		}
+	}
+	return nil
+}
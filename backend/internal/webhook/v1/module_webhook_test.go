@@ -0,0 +1,73 @@
+package v1
+
+import (
+	"context"
+	"testing"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestValidateModuleSource(t *testing.T) {
+	cases := []struct {
+		src     astrolabev1.ModuleSource
+		invalid string
+	}{
+		{src: astrolabev1.ModuleSource{Type: "git", URL: "https://github.com/terraform-aws-modules/terraform-aws-vpc.git"}},
+		{src: astrolabev1.ModuleSource{Type: "git", URL: "git@github.com:org/modules.git"}},
+		{src: astrolabev1.ModuleSource{Type: "git", URL: "ssh://git@example.com/org/modules.git"}},
+		{src: astrolabev1.ModuleSource{Type: "git", URL: "git::https://github.com/org/modules.git"}, invalid: `"git::" prefix`},
+		{src: astrolabev1.ModuleSource{Type: "git", URL: "terraform-aws-modules/vpc/aws"}, invalid: "must be a git clone URL"},
+		{src: astrolabev1.ModuleSource{Type: "git", URL: "file:///var/run/secrets/repo"}, invalid: "using https or ssh"},
+		{src: astrolabev1.ModuleSource{Type: "git", URL: "http://example.com/org/modules.git"}, invalid: "using https or ssh"},
+		{src: astrolabev1.ModuleSource{Type: "git", URL: "git://example.com/org/modules.git"}, invalid: "using https or ssh"},
+		{src: astrolabev1.ModuleSource{Type: "http", URL: "https://example.com/vpc.tar.gz"}},
+		{src: astrolabev1.ModuleSource{Type: "http", URL: "git@github.com:org/modules.git"}, invalid: "must be an http or https archive URL"},
+		{src: astrolabev1.ModuleSource{Type: "registry", URL: "terraform-aws-modules/vpc/aws"}},
+		{src: astrolabev1.ModuleSource{Type: "registry", URL: "registry.example.com/org/vpc/aws"}},
+		{src: astrolabev1.ModuleSource{Type: "registry", URL: "https://github.com/org/modules.git"}, invalid: "not a URL"},
+		{src: astrolabev1.ModuleSource{Type: "registry", URL: "vpc/aws"}, invalid: "namespace/name/provider"},
+		{src: astrolabev1.ModuleSource{Type: "oci", URL: "oci://ghcr.io/org/vpc", Version: "v1.0.0"}},
+		{src: astrolabev1.ModuleSource{Type: "oci", URL: "ghcr.io/org/vpc@sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"}},
+		{src: astrolabev1.ModuleSource{Type: "oci", URL: "https://ghcr.io/org/vpc"}, invalid: "must be an OCI reference"},
+		{src: astrolabev1.ModuleSource{Type: "oci", URL: "vpc"}, invalid: "expected registry/repository"},
+		{src: astrolabev1.ModuleSource{Type: "git"}, invalid: "Required value"},
+	}
+	for _, tc := range cases {
+		errs := validateModuleSource(tc.src, field.NewPath("spec", "source"))
+		if tc.invalid == "" {
+			assert.Empty(t, errs, "%s %s", tc.src.Type, tc.src.URL)
+			continue
+		}
+		if assert.Len(t, errs, 1, "%s %s", tc.src.Type, tc.src.URL) {
+			assert.Equal(t, "spec.source.url", errs[0].Field)
+			assert.Contains(t, errs[0].Error(), tc.invalid)
+		}
+	}
+}
+
+func TestModuleValidatorRejectsMismatchedURL(t *testing.T) {
+	mod := &astrolabev1.Module{Spec: astrolabev1.ModuleSpec{Source: astrolabev1.ModuleSource{Type: "http", URL: "oci://ghcr.io/org/vpc"}}}
+	_, err := (&ModuleCustomValidator{}).ValidateCreate(context.Background(), mod)
+	assert.ErrorContains(t, err, "spec.source.url")
+}
+
+func TestModuleValidatorRejectsUnpinnableVerification(t *testing.T) {
+	checksum := "sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	verification := &astrolabev1.ModuleVerification{SecretRef: astrolabev1.ModuleSecretRef{Name: "keys"}}
+	mod := &astrolabev1.Module{Spec: astrolabev1.ModuleSpec{Source: astrolabev1.ModuleSource{
+		Type: "registry", URL: "terraform-aws-modules/vpc/aws", Checksum: checksum, Verification: verification,
+	}}}
+	_, err := (&ModuleCustomValidator{}).ValidateCreate(context.Background(), mod)
+	assert.ErrorContains(t, err, "spec.source.checksum: Forbidden: is not supported for registry sources")
+	assert.ErrorContains(t, err, "spec.source.verification: Forbidden: is not supported for registry sources")
+
+	mod.Spec.Source = astrolabev1.ModuleSource{Type: "oci", URL: "oci://ghcr.io/org/vpc", Checksum: checksum}
+	_, err = (&ModuleCustomValidator{}).ValidateCreate(context.Background(), mod)
+	assert.ErrorContains(t, err, "spec.source.checksum: Forbidden: is not supported for oci sources")
+
+	mod.Spec.Source = astrolabev1.ModuleSource{Type: "http", URL: "https://example.com/vpc.tar.gz", Checksum: checksum, Verification: verification}
+	_, err = (&ModuleCustomValidator{}).ValidateCreate(context.Background(), mod)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,453 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	admissionv1 "k8s.io/api/admission/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var stacklog = logf.Log.WithName("stack-resource")
+
+// SupportedBackendTypes lists the Terraform state backends a Stack may use.
+var SupportedBackendTypes = []string{"azurerm", "consul", "cos", "gcs", "http", "kubernetes", "local", astrolabev1.BackendTypeManaged, "oss", "pg", "remote", "s3"}
+
+// managedBackendSettings are the settings a managed backend may set; the
+// controller sets the rest.
+var managedBackendSettings = []string{"labels", "secret_suffix"}
+
+// SetupStackWebhookWithManager registers the validating and defaulting webhooks for Stack in the manager.
+func SetupStackWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&astrolabev1.Stack{}).
+		WithValidator(&StackCustomValidator{Client: mgr.GetClient()}).
+		WithDefaulter(&StackCustomDefaulter{Client: mgr.GetClient()}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-astrolabe-io-v1-stack,mutating=true,failurePolicy=fail,sideEffects=None,groups=astrolabe.io,resources=stacks,verbs=create;update,versions=v1,name=mstack-v1.kb.io,admissionReviewVersions=v1
+
+// StackCustomDefaulter stores the effective configuration of a Stack: unset
+// optional variables take their Module defaults and the state key of the
+// backend is made explicit.
+type StackCustomDefaulter struct {
+	Client client.Reader
+}
+
+var _ webhook.CustomDefaulter = &StackCustomDefaulter{}
+
+// Default implements webhook.CustomDefaulter.
+func (d *StackCustomDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	stack, ok := obj.(*astrolabev1.Stack)
+	if !ok {
+		return fmt.Errorf("expected a Stack object but got %T", obj)
+	}
+	stacklog.Info("Defaulting for Stack", "name", stack.GetName())
+	// Leave a Stack being deleted alone so its finalizer can always be removed,
+	// and do not turn metadata-only updates such as adding a finalizer into
+	// spec changes
+	if stack.DeletionTimestamp != nil || specUnchanged(ctx, stack) {
+		return nil
+	}
+	// A Stack created with generateName has no name yet; the controller
+	// derives the state key and suffix from its final name instead
+	if stack.Name != "" {
+		if err := defaultBackendKey(&stack.Spec.BackendConfig, stack.Name); err != nil {
+			return err
+		}
+	}
+	for i := range stack.Spec.Modules {
+		stackMod := &stack.Spec.Modules[i]
+		stackMod.DependsOn = uniqueStrings(stackMod.DependsOn)
+		var mod astrolabev1.Module
+		if err := d.Client.Get(ctx, client.ObjectKey{Namespace: stack.Namespace, Name: stackMod.Name}, &mod); err != nil {
+			// The Stack controller reports missing Modules; they may simply be created later
+			stacklog.Info("Not defaulting variables, Module not found", "name", stack.GetName(), "module", stackMod.Name, "error", err.Error())
+			mod = astrolabev1.Module{}
+		}
+		if err := defaultVariables(stackMod, mod.Status.Inputs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// specUnchanged reports whether the admission request in ctx is an update
+// that leaves the Stack's spec as it was.
+func specUnchanged(ctx context.Context, stack *astrolabev1.Stack) bool {
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil || req.Operation != admissionv1.Update || len(req.OldObject.Raw) == 0 {
+		return false
+	}
+	var old astrolabev1.Stack
+	if err := json.Unmarshal(req.OldObject.Raw, &old); err != nil {
+		return false
+	}
+	return apiequality.Semantic.DeepEqual(old.Spec, stack.Spec)
+}
+
+// stateKeyBackends are the backend types whose state path the operator sets
+// itself when the Stack leaves "key" out, as renderBackendTf does.
+var stateKeyBackends = map[string]bool{"s3": true, "azurerm": true}
+
+func defaultBackendKey(backend *astrolabev1.BackendConfigSpec, stackName string) error {
+	settings := map[string]interface{}{}
+	if len(backend.Settings.Raw) > 0 {
+		if err := decodeJSON(backend.Settings.Raw, &settings); err != nil {
+			return fmt.Errorf("spec.backendConfig.settings must be an object: %w", err)
+		}
+	}
+	if _, ok := settings["key"]; !ok && stateKeyBackends[backend.Type] {
+		settings["key"] = fmt.Sprintf("astrolabe/%s.tfstate", stackName)
+	}
+	// The state Secret of a managed backend is named after the Stack
+	if _, ok := settings["secret_suffix"]; !ok && backend.Type == astrolabev1.BackendTypeManaged {
+		settings["secret_suffix"] = stackName
+	}
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	backend.Settings.Raw = raw
+	return nil
+}
+
+// validateManagedBackend checks the settings of a managed backend: the
+// controller picks the namespace and cluster, and secret_suffix must yield
+// valid Secret and Lease names derived from the Stack's name, so a Stack
+// cannot name another one's state.
+func validateManagedBackend(stack *astrolabev1.Stack, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	settings := map[string]interface{}{}
+	if len(stack.Spec.BackendConfig.Settings.Raw) > 0 {
+		if err := decodeJSON(stack.Spec.BackendConfig.Settings.Raw, &settings); err != nil {
+			return append(errs, field.Invalid(path, string(stack.Spec.BackendConfig.Settings.Raw), "must be an object"))
+		}
+	}
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !containsString(managedBackendSettings, name) {
+			errs = append(errs, field.NotSupported(path.Key(name), name, managedBackendSettings))
+		}
+	}
+	suffix, ok := settings["secret_suffix"]
+	if stack.Name == "" {
+		// generateName has not named the Stack yet, so there is nothing to
+		// derive a suffix from; the controller defaults it to the final name
+		if ok {
+			errs = append(errs, field.Forbidden(path.Key("secret_suffix"), "cannot be set on a Stack created with generateName"))
+		}
+	} else {
+		if !ok {
+			suffix = stack.Name
+		}
+		errs = append(errs, validateSecretSuffix(stack, suffix, path.Key("secret_suffix"))...)
+	}
+	if labels, ok := settings["labels"]; ok {
+		if _, isMap := labels.(map[string]interface{}); !isMap {
+			errs = append(errs, field.Invalid(path.Key("labels"), labels, "must be an object"))
+		}
+	}
+	return errs
+}
+
+// validateSecretSuffix checks that the secret_suffix of a managed backend
+// yields valid names and derives from the Stack's name.
+func validateSecretSuffix(stack *astrolabev1.Stack, suffix interface{}, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if s, isString := suffix.(string); !isString || s == "" {
+		errs = append(errs, field.Invalid(path, suffix, "must be a non-empty string"))
+	} else {
+		// Terraform names the lock Lease lock-tfstate-default-<secret_suffix>
+		for _, msg := range validation.IsDNS1123Subdomain("lock-tfstate-default-" + s) {
+			errs = append(errs, field.Invalid(path, s, msg))
+		}
+		if s != stack.Name && !strings.HasPrefix(s, stack.Name+"-") {
+			errs = append(errs, field.Invalid(path, s, fmt.Sprintf("must be %q or start with %q", stack.Name, stack.Name+"-")))
+		}
+	}
+	return errs
+}
+
+// managedSecretSuffix returns the secret_suffix of a Stack with the managed
+// backend, defaulting to its name, and false for other Stacks.
+func managedSecretSuffix(stack *astrolabev1.Stack) (string, bool) {
+	if stack.Spec.BackendConfig.Type != astrolabev1.BackendTypeManaged {
+		return "", false
+	}
+	settings := map[string]interface{}{}
+	if len(stack.Spec.BackendConfig.Settings.Raw) > 0 {
+		if err := decodeJSON(stack.Spec.BackendConfig.Settings.Raw, &settings); err != nil {
+			return "", false
+		}
+	}
+	suffix, ok := settings["secret_suffix"]
+	if !ok {
+		return stack.Name, true
+	}
+	s, ok := suffix.(string)
+	return s, ok
+}
+
+// validateStateOwner rejects a managed backend whose secret_suffix another
+// Stack in the namespace already uses: both would share one state.
+func (v *StackCustomValidator) validateStateOwner(ctx context.Context, stack *astrolabev1.Stack) field.ErrorList {
+	suffix, ok := managedSecretSuffix(stack)
+	if !ok || suffix == "" {
+		return nil
+	}
+	path := field.NewPath("spec", "backendConfig", "settings").Key("secret_suffix")
+	var stacks astrolabev1.StackList
+	if err := v.Client.List(ctx, &stacks, client.InNamespace(stack.Namespace)); err != nil {
+		return field.ErrorList{field.InternalError(path, err)}
+	}
+	for i := range stacks.Items {
+		other := &stacks.Items[i]
+		if other.Name == stack.Name {
+			continue
+		}
+		if s, ok := managedSecretSuffix(other); ok && s == suffix {
+			return field.ErrorList{field.Invalid(path, suffix, fmt.Sprintf("is already used by Stack %s", other.Name))}
+		}
+	}
+	return nil
+}
+
+// defaultVariables adds the default of every input the Stack does not set,
+// directly or through a linked or stack input, and rewrites the variables as canonical
+// JSON with sorted keys.
+func defaultVariables(stackMod *astrolabev1.StackModuleRef, inputs []astrolabev1.ModuleInput) error {
+	variables := map[string]interface{}{}
+	if len(stackMod.Variables.Raw) > 0 {
+		if err := decodeJSON(stackMod.Variables.Raw, &variables); err != nil {
+			return fmt.Errorf("variables of module %s must be an object: %w", stackMod.Name, err)
+		}
+	}
+	linked := map[string]bool{}
+	for _, link := range stackMod.LinkedInputs {
+		linked[link.ToVariable] = true
+	}
+	for _, in := range stackMod.StackInputs {
+		linked[in.ToVariable] = true
+	}
+	for _, in := range inputs {
+		if _, ok := variables[in.Name]; ok || linked[in.Name] || in.Default == nil {
+			continue
+		}
+		variables[in.Name] = json.RawMessage(in.Default.Raw)
+	}
+	if len(variables) == 0 {
+		stackMod.Variables.Raw = nil
+		return nil
+	}
+	raw, err := json.Marshal(variables)
+	if err != nil {
+		return err
+	}
+	stackMod.Variables.Raw = raw
+	return nil
+}
+
+// decodeJSON decodes with UseNumber so large and precise numbers survive re-encoding.
+func decodeJSON(raw []byte, into interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	return dec.Decode(into)
+}
+
+func uniqueStrings(list []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// +kubebuilder:webhook:path=/validate-astrolabe-io-v1-stack,mutating=false,failurePolicy=fail,sideEffects=None,groups=astrolabe.io,resources=stacks,verbs=create;update,versions=v1,name=vstack-v1.kb.io,admissionReviewVersions=v1
+
+// StackCustomValidator rejects Stacks whose module graph or backend can never
+// be applied, so the mistake surfaces at apply time instead of in reconcile.
+type StackCustomValidator struct {
+	// Client lists the other Stacks of a namespace, whose managed state a
+	// Stack must not share.
+	Client client.Reader
+}
+
+var _ webhook.CustomValidator = &StackCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator.
+func (v *StackCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	stack, ok := obj.(*astrolabev1.Stack)
+	if !ok {
+		return nil, fmt.Errorf("expected a Stack object but got %T", obj)
+	}
+	stacklog.Info("Validation for Stack upon creation", "name", stack.GetName())
+	return nil, stackInvalid(stack, v.validate(ctx, stack))
+}
+
+// ValidateUpdate implements webhook.CustomValidator.
+func (v *StackCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldStack, ok := oldObj.(*astrolabev1.Stack)
+	if !ok {
+		return nil, fmt.Errorf("expected a Stack object for the oldObj but got %T", oldObj)
+	}
+	stack, ok := newObj.(*astrolabev1.Stack)
+	if !ok {
+		return nil, fmt.Errorf("expected a Stack object for the newObj but got %T", newObj)
+	}
+	stacklog.Info("Validation for Stack upon update", "name", stack.GetName())
+	// Stacks created before the webhook existed must still accept finalizer
+	// and metadata updates, or they could never be deleted
+	if stack.DeletionTimestamp != nil || apiequality.Semantic.DeepEqual(oldStack.Spec, stack.Spec) {
+		return nil, nil
+	}
+	return nil, stackInvalid(stack, v.validate(ctx, stack))
+}
+
+// ValidateDelete implements webhook.CustomValidator.
+func (v *StackCustomValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validate runs validateStack and the checks against other Stacks.
+func (v *StackCustomValidator) validate(ctx context.Context, stack *astrolabev1.Stack) field.ErrorList {
+	errs := validateStack(stack)
+	if len(errs) > 0 {
+		return errs
+	}
+	return v.validateStateOwner(ctx, stack)
+}
+
+func stackInvalid(stack *astrolabev1.Stack, errs field.ErrorList) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(astrolabev1.GroupVersion.WithKind("Stack").GroupKind(), stack.Name, errs)
+}
+
+// validateStack checks the backend type and that spec.modules forms a DAG of
+// uniquely named modules, counting linked inputs as dependencies.
+// Reference cycles through other Stacks are reported by the controller.
+func validateStack(stack *astrolabev1.Stack) field.ErrorList {
+	var errs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	backendType := specPath.Child("backendConfig", "type")
+	if stack.Spec.BackendConfig.Type == "" {
+		errs = append(errs, field.Required(backendType, ""))
+	} else if !containsString(SupportedBackendTypes, stack.Spec.BackendConfig.Type) {
+		errs = append(errs, field.NotSupported(backendType, stack.Spec.BackendConfig.Type, SupportedBackendTypes))
+	} else if stack.Spec.BackendConfig.Type == astrolabev1.BackendTypeManaged {
+		errs = append(errs, validateManagedBackend(stack, specPath.Child("backendConfig", "settings"))...)
+	}
+
+	modulesPath := specPath.Child("modules")
+	index := map[string]int{}
+	for i, mod := range stack.Spec.Modules {
+		if _, dup := index[mod.Name]; dup {
+			errs = append(errs, field.Duplicate(modulesPath.Index(i).Child("name"), mod.Name))
+			continue
+		}
+		index[mod.Name] = i
+	}
+	for i, mod := range stack.Spec.Modules {
+		for j, dep := range mod.DependsOn {
+			if _, ok := index[dep]; !ok {
+				errs = append(errs, field.NotFound(modulesPath.Index(i).Child("dependsOn").Index(j), dep))
+			}
+		}
+		for j, link := range mod.LinkedInputs {
+			if _, ok := index[link.FromModuleID]; !ok {
+				errs = append(errs, field.NotFound(modulesPath.Index(i).Child("linkedInputs").Index(j).Child("fromModuleId"), link.FromModuleID))
+			}
+		}
+		for j, in := range mod.StackInputs {
+			if in.StackRef.Name == stack.Name && (in.StackRef.Namespace == "" || in.StackRef.Namespace == stack.Namespace) {
+				errs = append(errs, field.Invalid(modulesPath.Index(i).Child("stackInputs").Index(j).Child("stackRef"), in.StackRef.Name,
+					"a Stack cannot reference its own outputs"))
+			}
+		}
+	}
+
+	for _, cycle := range dependencyCycles(stack.Spec.Modules, index) {
+		errs = append(errs, field.Invalid(modulesPath.Index(index[cycle[0]]).Child("dependsOn"), field.OmitValueType{},
+			"dependency cycle: "+strings.Join(cycle, " -> ")))
+	}
+	return errs
+}
+
+// dependencyCycles returns each cycle in the dependency graph once, as the list
+// of module names along it ending with its first name again. Unknown
+// dependencies are ignored; they are reported separately.
+func dependencyCycles(modules []astrolabev1.StackModuleRef, index map[string]int) [][]string {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int, len(index))
+	var stack []string
+	var cycles [][]string
+	var visit func(name string)
+	visit = func(name string) {
+		state[name] = visiting
+		stack = append(stack, name)
+		deps := modules[index[name]].Dependencies()
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if _, ok := index[dep]; !ok {
+				continue
+			}
+			switch state[dep] {
+			case unvisited:
+				visit(dep)
+			case visiting:
+				start := len(stack) - 1
+				for stack[start] != dep {
+					start--
+				}
+				cycle := append(append([]string(nil), stack[start:]...), dep)
+				cycles = append(cycles, cycle)
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = done
+	}
+	for _, mod := range modules {
+		if state[mod.Name] == unvisited {
+			visit(mod.Name)
+		}
+	}
+	return cycles
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,227 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	astrolabev1 "github.com/junaid18183/astrolabe/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func stackWithModules(mods ...astrolabev1.StackModuleRef) *astrolabev1.Stack {
+	return &astrolabev1.Stack{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default"},
+		Spec: astrolabev1.StackSpec{
+			BackendConfig: astrolabev1.BackendConfigSpec{Type: "s3"},
+			Modules:       mods,
+		},
+	}
+}
+
+func TestStackValidatorAcceptsDAG(t *testing.T) {
+	stack := stackWithModules(
+		astrolabev1.StackModuleRef{Name: "vpc"},
+		astrolabev1.StackModuleRef{Name: "eks", DependsOn: []string{"vpc"}},
+		astrolabev1.StackModuleRef{Name: "apps", DependsOn: []string{"eks", "vpc"}},
+	)
+	_, err := (&StackCustomValidator{}).ValidateCreate(context.Background(), stack)
+	assert.NoError(t, err)
+}
+
+func TestStackValidatorRejectsInvalidStack(t *testing.T) {
+	stack := stackWithModules(
+		astrolabev1.StackModuleRef{Name: "vpc"},
+		astrolabev1.StackModuleRef{Name: "vpc"},
+		astrolabev1.StackModuleRef{Name: "eks", DependsOn: []string{"vcp"}},
+	)
+	stack.Spec.BackendConfig.Type = "s4"
+	_, err := (&StackCustomValidator{}).ValidateCreate(context.Background(), stack)
+	require.True(t, apierrors.IsInvalid(err), "got %v", err)
+	assert.ErrorContains(t, err, `spec.backendConfig.type: Unsupported value: "s4"`)
+	assert.ErrorContains(t, err, `spec.modules[1].name: Duplicate value: "vpc"`)
+	assert.ErrorContains(t, err, `spec.modules[2].dependsOn[0]: Not found: "vcp"`)
+}
+
+func TestStackValidatorChecksManagedBackend(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, astrolabev1.AddToScheme(scheme))
+	network := stackWithModules()
+	network.Name = "network"
+	network.Spec.BackendConfig = astrolabev1.BackendConfigSpec{Type: astrolabev1.BackendTypeManaged}
+	shared := stackWithModules()
+	shared.Name = "shared"
+	shared.Spec.BackendConfig = astrolabev1.BackendConfigSpec{
+		Type:     astrolabev1.BackendTypeManaged,
+		Settings: apiextensionsv1.JSON{Raw: []byte(`{"secret_suffix":"demo-prod"}`)},
+	}
+	v := &StackCustomValidator{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(network, shared).Build()}
+
+	stack := stackWithModules(astrolabev1.StackModuleRef{Name: "vpc"})
+	stack.Spec.BackendConfig = astrolabev1.BackendConfigSpec{Type: astrolabev1.BackendTypeManaged}
+	_, err := v.ValidateCreate(context.Background(), stack)
+	assert.NoError(t, err)
+
+	stack.Spec.BackendConfig.Settings.Raw = []byte(`{"namespace":"kube-system","secret_suffix":"Not_Valid","labels":{"team":"platform"}}`)
+	_, err = v.ValidateCreate(context.Background(), stack)
+	require.True(t, apierrors.IsInvalid(err), "got %v", err)
+	assert.ErrorContains(t, err, `spec.backendConfig.settings[namespace]: Unsupported value: "namespace"`)
+	assert.ErrorContains(t, err, `spec.backendConfig.settings[secret_suffix]: Invalid value: "Not_Valid"`)
+	assert.NotContains(t, err.Error(), "settings[labels]")
+
+	// The suffix must derive from the Stack's name
+	stack.Spec.BackendConfig.Settings.Raw = []byte(`{"secret_suffix":"network"}`)
+	_, err = v.ValidateCreate(context.Background(), stack)
+	require.True(t, apierrors.IsInvalid(err), "got %v", err)
+	assert.ErrorContains(t, err, `spec.backendConfig.settings[secret_suffix]: Invalid value: "network": must be "demo" or start with "demo-"`)
+
+	// ... and not be used by another Stack
+	stack.Spec.BackendConfig.Settings.Raw = []byte(`{"secret_suffix":"demo-prod"}`)
+	_, err = v.ValidateCreate(context.Background(), stack)
+	require.True(t, apierrors.IsInvalid(err), "got %v", err)
+	assert.ErrorContains(t, err, `spec.backendConfig.settings[secret_suffix]: Invalid value: "demo-prod": is already used by Stack shared`)
+
+	stack.Spec.BackendConfig.Settings.Raw = []byte(`{"secret_suffix":"demo-dev"}`)
+	_, err = v.ValidateCreate(context.Background(), stack)
+	assert.NoError(t, err)
+
+	// A Stack created with generateName cannot name its suffix after itself yet
+	generated := stackWithModules()
+	generated.Name, generated.GenerateName = "", "demo-"
+	generated.Spec.BackendConfig = astrolabev1.BackendConfigSpec{Type: astrolabev1.BackendTypeManaged}
+	_, err = v.ValidateCreate(context.Background(), generated)
+	assert.NoError(t, err)
+	generated.Spec.BackendConfig.Settings.Raw = []byte(`{"secret_suffix":"demo-dev"}`)
+	_, err = v.ValidateCreate(context.Background(), generated)
+	assert.ErrorContains(t, err, "spec.backendConfig.settings[secret_suffix]: Forbidden: cannot be set on a Stack created with generateName")
+}
+
+func TestStackValidatorRejectsCycles(t *testing.T) {
+	stack := stackWithModules(
+		astrolabev1.StackModuleRef{Name: "a", DependsOn: []string{"c"}},
+		astrolabev1.StackModuleRef{Name: "b", DependsOn: []string{"a"}},
+		astrolabev1.StackModuleRef{Name: "c", DependsOn: []string{"b"}},
+		astrolabev1.StackModuleRef{Name: "d", DependsOn: []string{"d"}},
+	)
+	_, err := (&StackCustomValidator{}).ValidateCreate(context.Background(), stack)
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "spec.modules[0].dependsOn: Invalid value: dependency cycle: a -> c -> b -> a")
+	assert.ErrorContains(t, err, "spec.modules[3].dependsOn: Invalid value: dependency cycle: d -> d")
+}
+
+func TestStackValidatorLinkedInputs(t *testing.T) {
+	stack := stackWithModules(
+		astrolabev1.StackModuleRef{Name: "vpc", DependsOn: []string{"eks"}},
+		astrolabev1.StackModuleRef{Name: "eks", LinkedInputs: []astrolabev1.LinkedInput{
+			{FromModuleID: "vpc", FromOutput: "private_subnets", ToVariable: "subnet_ids"},
+			{FromModuleID: "rds", FromOutput: "endpoint", ToVariable: "db"},
+		}},
+	)
+	_, err := (&StackCustomValidator{}).ValidateCreate(context.Background(), stack)
+	require.Error(t, err)
+	assert.ErrorContains(t, err, `spec.modules[1].linkedInputs[1].fromModuleId: Not found: "rds"`)
+	assert.ErrorContains(t, err, "dependency cycle: vpc -> eks -> vpc")
+}
+
+func TestStackValidatorRejectsSelfReference(t *testing.T) {
+	stack := stackWithModules(astrolabev1.StackModuleRef{Name: "vpc", StackInputs: []astrolabev1.StackOutputReference{
+		{StackRef: astrolabev1.StackReference{Name: "network", Namespace: "default"}, Output: "vpc_id", ToVariable: "vpc_id"},
+		{StackRef: astrolabev1.StackReference{Name: "demo"}, Output: "cidr", ToVariable: "cidr"},
+	}})
+	_, err := (&StackCustomValidator{}).ValidateCreate(context.Background(), stack)
+	require.Error(t, err)
+	assert.ErrorContains(t, err, `spec.modules[0].stackInputs[1].stackRef: Invalid value: "demo": a Stack cannot reference its own outputs`)
+	assert.NotContains(t, err.Error(), "stackInputs[0]")
+}
+
+func TestStackValidatorUpdate(t *testing.T) {
+	v := &StackCustomValidator{}
+	invalid := stackWithModules(astrolabev1.StackModuleRef{Name: "a", DependsOn: []string{"a"}})
+
+	// Metadata-only changes to a Stack that predates the webhook are allowed
+	updated := invalid.DeepCopy()
+	updated.Finalizers = []string{"stack.finalizers.astrolabe.io"}
+	_, err := v.ValidateUpdate(context.Background(), invalid, updated)
+	assert.NoError(t, err)
+
+	valid := stackWithModules(astrolabev1.StackModuleRef{Name: "a"})
+	_, err = v.ValidateUpdate(context.Background(), valid, invalid)
+	assert.Error(t, err)
+}
+
+func TestStackDefaulter(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, astrolabev1.AddToScheme(s))
+	vpc := &astrolabev1.Module{
+		ObjectMeta: metav1.ObjectMeta{Name: "vpc", Namespace: "default"},
+		Status: astrolabev1.ModuleStatus{Inputs: []astrolabev1.ModuleInput{
+			{Name: "name", Type: "string", Required: true},
+			{Name: "cidr", Type: "string", Default: &apiextensionsv1.JSON{Raw: []byte(`"10.0.0.0/16"`)}},
+			{Name: "tags", Type: "map(string)", Default: &apiextensionsv1.JSON{Raw: []byte(`{"team":"platform"}`)}},
+			{Name: "password", Type: "string"},
+		}},
+	}
+	c := fake.NewClientBuilder().WithScheme(s).WithObjects(vpc).Build()
+
+	stack := stackWithModules(
+		astrolabev1.StackModuleRef{Name: "vpc", Variables: apiextensionsv1.JSON{Raw: []byte(`{"tags": {}, "name": "demo", "count": 12345678901234567890}`)}},
+		astrolabev1.StackModuleRef{Name: "eks", DependsOn: []string{"vpc", "vpc"}},
+		astrolabev1.StackModuleRef{Name: "vpc", LinkedInputs: []astrolabev1.LinkedInput{
+			{FromModuleID: "eks", FromOutput: "cidr", ToVariable: "cidr"},
+		}},
+		astrolabev1.StackModuleRef{Name: "vpc", StackInputs: []astrolabev1.StackOutputReference{
+			{StackRef: astrolabev1.StackReference{Name: "network"}, Output: "cidr", ToVariable: "cidr"},
+		}},
+	)
+	require.NoError(t, (&StackCustomDefaulter{Client: c}).Default(context.Background(), stack))
+
+	assert.JSONEq(t, `{"key":"astrolabe/demo.tfstate"}`, string(stack.Spec.BackendConfig.Settings.Raw))
+	assert.Equal(t, `{"cidr":"10.0.0.0/16","count":12345678901234567890,"name":"demo","tags":{}}`, string(stack.Spec.Modules[0].Variables.Raw))
+	assert.Nil(t, stack.Spec.Modules[1].Variables.Raw, "unknown modules are left alone")
+	assert.Equal(t, []string{"vpc"}, stack.Spec.Modules[1].DependsOn)
+	assert.Equal(t, `{"tags":{"team":"platform"}}`, string(stack.Spec.Modules[2].Variables.Raw), "linked variables are not defaulted")
+	assert.Equal(t, `{"tags":{"team":"platform"}}`, string(stack.Spec.Modules[3].Variables.Raw), "variables set from other Stacks are not defaulted")
+
+	// An explicit key is kept, and other backends get none
+	stack.Spec.BackendConfig.Settings.Raw = []byte(`{"bucket":"state","key":"custom.tfstate"}`)
+	require.NoError(t, (&StackCustomDefaulter{Client: c}).Default(context.Background(), stack))
+	assert.JSONEq(t, `{"bucket":"state","key":"custom.tfstate"}`, string(stack.Spec.BackendConfig.Settings.Raw))
+	stack.Spec.BackendConfig = astrolabev1.BackendConfigSpec{Type: "local"}
+	require.NoError(t, (&StackCustomDefaulter{Client: c}).Default(context.Background(), stack))
+	assert.JSONEq(t, `{}`, string(stack.Spec.BackendConfig.Settings.Raw))
+
+	// The managed backend names its state Secret after the Stack
+	stack.Spec.BackendConfig = astrolabev1.BackendConfigSpec{Type: astrolabev1.BackendTypeManaged}
+	require.NoError(t, (&StackCustomDefaulter{Client: c}).Default(context.Background(), stack))
+	assert.JSONEq(t, `{"secret_suffix":"demo"}`, string(stack.Spec.BackendConfig.Settings.Raw))
+
+	// With generateName the name is not known yet; the controller defaults both later
+	for _, backend := range []string{"s3", astrolabev1.BackendTypeManaged} {
+		generated := stackWithModules()
+		generated.Name, generated.GenerateName = "", "demo-"
+		generated.Spec.BackendConfig = astrolabev1.BackendConfigSpec{Type: backend}
+		require.NoError(t, (&StackCustomDefaulter{Client: c}).Default(context.Background(), generated))
+		assert.Nil(t, generated.Spec.BackendConfig.Settings.Raw, backend)
+	}
+}
+
+func TestStackDefaulterSkipsMetadataUpdates(t *testing.T) {
+	stack := stackWithModules(astrolabev1.StackModuleRef{Name: "vpc"})
+	old, err := json.Marshal(stack)
+	require.NoError(t, err)
+	ctx := admission.NewContextWithRequest(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Update,
+		OldObject: runtime.RawExtension{Raw: old},
+	}})
+	// No client is needed because nothing is looked up
+	require.NoError(t, (&StackCustomDefaulter{}).Default(ctx, stack))
+	assert.Nil(t, stack.Spec.BackendConfig.Settings.Raw)
+}
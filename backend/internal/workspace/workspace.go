@@ -0,0 +1,104 @@
+// Package workspace manages the Terraform working directories of Stacks.
+// Every Stack gets its own directory below a root, which is meant to be a
+// PersistentVolume mounted into the manager so that .terraform, the
+// dependency lock file and local state survive restarts. A workspace is
+// locked while an operation uses it, across processes sharing the root too.
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrLocked is returned by Acquire while another operation holds the
+// workspace.
+var ErrLocked = errors.New("workspace is locked")
+
+// Store hands out the workspaces below Root.
+type Store struct {
+	Root string
+}
+
+// New returns a Store keeping workspaces below root.
+func New(root string) *Store {
+	return &Store{Root: root}
+}
+
+// Dir returns the workspace directory of a Stack. The UID keeps a Stack
+// recreated under the same name from inheriting the previous one's state.
+func (s *Store) Dir(namespace, name, uid string) string {
+	if uid != "" {
+		name += "-" + uid
+	}
+	return filepath.Join(s.Root, namespace, name)
+}
+
+// Workspace is a workspace directory held by one operation until Release.
+type Workspace struct {
+	Dir  string
+	lock *os.File
+}
+
+// Acquire locks the workspace of a Stack for operation, creating its
+// directory if needed. It fails with ErrLocked, naming the holder, while
+// another operation holds it; the lock goes with the process holding it, so
+// a crashed manager never leaves a workspace locked.
+func (s *Store) Acquire(namespace, name, uid, operation string) (*Workspace, error) {
+	dir := s.Dir(namespace, name, uid)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(lockPath(dir), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		holder, _ := os.ReadFile(lockPath(dir))
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%w by %s", ErrLocked, strings.TrimSpace(string(holder)))
+		}
+		return nil, fmt.Errorf("failed to lock workspace %s: %w", dir, err)
+	}
+	// Record the holder for the error of the next Acquire; best effort
+	if err := f.Truncate(0); err == nil {
+		fmt.Fprintf(f, "%s (pid %d) since %s\n", operation, os.Getpid(), time.Now().UTC().Format(time.RFC3339))
+	}
+	return &Workspace{Dir: dir, lock: f}, nil
+}
+
+// Release unlocks the workspace. It is safe to call more than once.
+func (w *Workspace) Release() {
+	if w.lock == nil {
+		return
+	}
+	w.lock.Truncate(0)
+	w.lock.Close()
+	w.lock = nil
+}
+
+// Remove deletes the workspace directory and its lock, for example once the
+// Stack's resources were destroyed, and releases it.
+func (w *Workspace) Remove() error {
+	defer w.Release()
+	if err := os.RemoveAll(w.Dir); err != nil {
+		return err
+	}
+	if err := os.Remove(lockPath(w.Dir)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// Drop the namespace directory with its last workspace
+	os.Remove(filepath.Dir(w.Dir))
+	return nil
+}
+
+// lockPath keeps the lock beside the directory, so removing the directory
+// does not remove the lock of the operation removing it.
+func lockPath(dir string) string {
+	return dir + ".lock"
+}
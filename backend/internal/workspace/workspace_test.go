@@ -0,0 +1,46 @@
+package workspace
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDir(t *testing.T) {
+	s := New("/var/lib/astrolabe")
+	assert.Equal(t, "/var/lib/astrolabe/default/demo-1234", s.Dir("default", "demo", "1234"))
+	assert.Equal(t, "/var/lib/astrolabe/default/demo", s.Dir("default", "demo", ""))
+}
+
+func TestAcquireLocksWorkspace(t *testing.T) {
+	s := New(t.TempDir())
+	ws, err := s.Acquire("default", "demo", "1234", "reconcile")
+	require.NoError(t, err)
+	assert.DirExists(t, ws.Dir)
+
+	_, err = s.Acquire("default", "demo", "1234", "destroy")
+	require.ErrorIs(t, err, ErrLocked)
+	assert.Contains(t, err.Error(), "reconcile (pid")
+
+	// Other Stacks are not affected
+	other, err := s.Acquire("default", "demo", "5678", "reconcile")
+	require.NoError(t, err)
+	other.Release()
+
+	require.NoError(t, os.WriteFile(filepath.Join(ws.Dir, "terraform.tfstate"), []byte("{}"), 0600))
+	ws.Release()
+	ws.Release()
+
+	again, err := s.Acquire("default", "demo", "1234", "destroy")
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(again.Dir, "terraform.tfstate"), "the workspace is kept between operations")
+	require.NoError(t, again.Remove())
+	assert.NoDirExists(t, again.Dir)
+	assert.NoFileExists(t, lockPath(again.Dir))
+
+	_, err = s.Acquire("default", "demo", "1234", "reconcile")
+	require.NoError(t, err, "a removed workspace is unlocked")
+}
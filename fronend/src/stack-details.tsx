@@ -112,6 +112,80 @@ function StackDetailsView() {
         />
       </SectionBox>
 
+      <SectionBox title="Plan">
+        <NameValueTable
+          rows={
+            status.plan
+              ? [
+                  { name: 'Summary', value: status.plan.summary || '-' },
+                  { name: 'Hash', value: status.plan.hash || '-' },
+                  { name: 'Created', value: status.plan.createdAt || '-' },
+                ]
+              : [{ name: 'No plan', value: '-' }]
+          }
+        />
+        <Table
+          columns={[
+            {
+              header: 'Action',
+              accessorFn: (c: any) => c.action || '-',
+            },
+            {
+              header: 'Address',
+              accessorFn: (c: any) => c.resource?.address || '-',
+            },
+            {
+              header: 'Before',
+              accessorFn: (c: any) => (c.resource?.before ? JSON.stringify(c.resource.before) : '-'),
+            },
+            {
+              header: 'After',
+              accessorFn: (c: any) => (c.resource?.after ? JSON.stringify(c.resource.after) : '-'),
+            },
+          ]}
+          data={Array.isArray(status.plan?.changes) ? status.plan.changes : []}
+          emptyMessage="No changes"
+        />
+      </SectionBox>
+
+      <SectionBox title="Runs">
+        <Table
+          columns={[
+            {
+              header: 'ID',
+              accessorFn: (run: any) => run.id || '-',
+            },
+            {
+              header: 'Trigger',
+              accessorFn: (run: any) => run.trigger || '-',
+            },
+            {
+              header: 'Status',
+              accessorFn: (run: any) =>
+                run.failedStep ? `${run.status} (${run.failedStep})` : run.status || '-',
+            },
+            {
+              header: 'Plan',
+              accessorFn: (run: any) => run.planSummary || '-',
+            },
+            {
+              header: 'Started',
+              accessorFn: (run: any) => run.startedAt || '-',
+            },
+            {
+              header: 'Finished',
+              accessorFn: (run: any) => run.finishedAt || '-',
+            },
+            {
+              header: 'Summary',
+              accessorFn: (run: any) => run.summary || '-',
+            },
+          ]}
+          data={Array.isArray(status.runs) ? [...status.runs].reverse() : []}
+          emptyMessage="No runs"
+        />
+      </SectionBox>
+
       <SectionBox title="Conditions">
         <ConditionsTable resource={stack.jsonData} />
       </SectionBox>